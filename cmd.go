@@ -3,18 +3,20 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
+	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/helper"
 )
 
 const help = `
 This is a tool to parse Redis' RDB files
 Options:
-  -c command, including: json/memory/aof/bigkey/hotkey/prefix/flamegraph
+  -c command, including: json/memory/aof/bigkey/hotkey/prefix/flamegraph/index/get/salvage/functions/slots/split/merge/rdb/convert
   -o output file path
-  -n number of result, using in command: bigkey/hotkey/prefix
+  -n number of result, using in command: bigkey/hotkey/prefix/slots
   -port listen port for flame graph web service
   -sep separator for flamegraph, rdb will separate key by it, default value is ":". 
     supporting multi separators: -sep sep1 -sep sep2 
@@ -22,6 +24,7 @@ Options:
     when specified, uses separator-based analysis instead of radix tree.
     supporting multi separators: -prefix-sep sep1 -prefix-sep sep2
   -regex using regex expression filter keys
+  -exclude-regex filter out keys matching regex expression
   -expire filter keys by its expiration time
     1. '1751731200~1751817600' get keys with expiration time in range [1751731200, 1751817600]
     2. '1751731200~now' 'now~1751731200' magic variable 'now' represents the current timestamp
@@ -33,9 +36,33 @@ Options:
     2. '10MB~inf' magic variable 'inf' represents the Infinity
     3. '1024~10KB' get keys with size in range [0Bytes, 10KB]
   -concurrent The number of concurrent json converters. 4 by default.
+  -parallel The number of goroutines decoding rdb file, available in all commands. 1 by default.
   -show-global-meta Show global meta likes redis-verion/ctime/functions
+  -metadata-only skip values and only read key/type/encoding/size/element count, much faster for huge files.
+    using in command: bigkey/hotkey/prefix/flamegraph
+  -key key to lookup, using in command: get
+  -db index of db to lookup, using in command: get. 0 by default.
+    in other commands, only keys in the db are used if it is specified.
+  -rename, -rename-to rewrite key names by regex replacement, using in command: rdb.
+    like '-rename ^user:(.*) -rename-to u:$1'
+  -nodes layout of cluster nodes for command slots/split: number of nodes to divide slots evenly,
+    or nodes with slot ranges like 'a=0-5460;b=5461-10922;c=10923-16383'
+  -analyse analyse size of slots in command slots even if the dump has slot info of valkey cluster
+  -policy which one to keep when the same key exists in multiple inputs, using in command: merge
+    first-wins/last-wins/newest-expire-wins/error. error by default.
+  -db-map move keys of a db in an input into another db, using in command: merge.
+    like '1:0=3,2:0=4', '1:0=3' moves db 0 of the second input into db 3
+  -target-version rdb version of output file, using in command: convert/rdb.
+    7 (redis 3.2) to 12 (redis 7.4), or 80 (valkey 9). for example 9 for redis 5.0 and 6.x
+  -dialect dialect of output file: redis/valkey, using in command: convert/rdb. the same as input by default
+  -downgrade-stream write streams in the latest format of target version, dropping metadata added by later formats,
+    like entries read of consumer groups. using in command: convert/rdb
+  -raw copy values verbatim without decoding them, much faster and keeps encodings. using in command: rdb/split
+  -format output format of command get: json/resp, command functions: json/csv/resp. json by default.
   -no-expired filter expired keys(deprecated, please use 'expire' option)
 
+Progress of parsing is printed on stderr.
+
 Examples:
 parameters between '[' and ']' is optional
 1. convert rdb to json
@@ -54,6 +81,33 @@ parameters between '[' and ']' is optional
   rdb -c flamegraph [-port 16379] [-sep :] dump.rdb
 7. get hottest keys by LFU frequency (requires maxmemory-policy allkeys-lfu/volatile-lfu)
   rdb -c hotkey [-o hotkey.csv] [-n 50] dump.rdb
+8. get largest keys quickly without reading values
+  rdb -c bigkey -metadata-only [-n 10] dump.rdb
+9. build index file dump.rdb.idx for random-access lookup
+  rdb -c index dump.rdb
+10. get a key by index, the index is built on first lookup and rebuilt if rdb changed
+  rdb -c get -key foo [-db 0] [-format resp] [-o foo.json] dump.rdb
+11. recover keys from a damaged rdb file into a new rdb file
+  rdb -c salvage -o recovered.rdb dump.rdb
+12. list function libraries, or generate FUNCTION LOAD REPLACE commands by '-format resp'
+  rdb -c functions [-format csv] [-o functions.csv] dump.rdb
+13. get the heaviest cluster slots and simulate distribution over 3 nodes, slot sizes recorded by valkey cluster
+  are compared with keys in each slot instead if the dump has them, unless '-analyse' is set
+  rdb -c slots [-analyse] [-n 10] [-nodes 3] [-o slots.csv] dump.rdb
+14. compare slot sizes recorded by valkey cluster with keys in each slot
+  rdb -c slots -o slots.csv valkey-dump.rdb
+15. split rdb into one rdb file per cluster node, like output/a.rdb, output/b.rdb
+  rdb -c split -nodes 'a=0-8191;b=8192-16383' -o output dump.rdb
+16. write keys passing filters into a new rdb file, like dropping expired keys and session:* in db 2
+  rdb -c rdb -no-expired -exclude-regex '^session:' -db 2 [-rename '^user:' -rename-to 'u:'] -o out.rdb dump.rdb
+17. merge rdb files into one rdb file
+  rdb -c merge [-policy last-wins] [-db-map '1:0=3'] -o merged.rdb dump1.rdb dump2.rdb
+18. convert rdb file of redis 7.x into rdb version 9 which can be loaded by redis 6.0
+  rdb -c convert -target-version 9 -o out.rdb dump.rdb
+19. convert rdb file of redis into rdb file of valkey
+  rdb -c convert -dialect valkey -o out.rdb dump.rdb
+20. filter keys quickly by copying values verbatim
+  rdb -c rdb -raw -regex '^user:' -o out.rdb dump.rdb
 `
 
 type separators []string
@@ -75,13 +129,29 @@ func main() {
 	var port int
 	var seps separators
 	var regexExpr string
+	var excludeRegexExpr string
+	var renameExpr string
+	var renameTo string
 	var noExpired bool
 	var expirationExpr string
 	var sizeExpr string
 	var maxDepth int
 	var concurrent int
+	var parallel int
 	var showGlobalMeta bool
+	var metadataOnly bool
 	var prefixSeps separators
+	var key string
+	var db int
+	var format string
+	var nodes string
+	var analyse bool
+	var policy string
+	var dbMap string
+	var targetVersion int
+	var dialect string
+	var raw bool
+	var downgradeStream bool
 	var err error
 	flagSet.StringVar(&cmd, "c", "", "command for rdb: json")
 	flagSet.StringVar(&output, "o", "", "output file path")
@@ -89,13 +159,29 @@ func main() {
 	flagSet.IntVar(&maxDepth, "max-depth", 0, "max depth of prefix tree")
 	flagSet.IntVar(&port, "port", 0, "listen port for web")
 	flagSet.IntVar(&concurrent, "concurrent", 0, "concurrent number for json converter")
+	flagSet.IntVar(&parallel, "parallel", 0, "number of goroutines decoding rdb")
 	flagSet.Var(&seps, "sep", "separator for flame graph")
 	flagSet.StringVar(&regexExpr, "regex", "", "regex expression")
+	flagSet.StringVar(&excludeRegexExpr, "exclude-regex", "", "regex expression of keys to filter out")
+	flagSet.StringVar(&renameExpr, "rename", "", "regex expression of key names to rewrite")
+	flagSet.StringVar(&renameTo, "rename-to", "", "replacement of key names matching -rename")
 	flagSet.StringVar(&expirationExpr, "expire", "", "expiration filter expression")
 	flagSet.StringVar(&sizeExpr, "size", "", "size filter expression")
 	flagSet.BoolVar(&noExpired, "no-expired", false, "filter expired keys(deprecated, please use expire)")
 	flagSet.Var(&prefixSeps, "prefix-sep", "separator for prefix analysis (flat-map mode, constant memory)")
 	flagSet.BoolVar(&showGlobalMeta, "show-global-meta", false, "Show global meta likes redis-verion/ctime/functions")
+	flagSet.BoolVar(&metadataOnly, "metadata-only", false, "skip values, using in bigkey/hotkey/prefix/flamegraph")
+	flagSet.StringVar(&key, "key", "", "key to lookup")
+	flagSet.IntVar(&db, "db", 0, "index of db to lookup")
+	flagSet.StringVar(&format, "format", "", "output format: json/csv/resp")
+	flagSet.StringVar(&nodes, "nodes", "", "layout of cluster nodes")
+	flagSet.BoolVar(&analyse, "analyse", false, "analyse size of slots even if rdb has slot info")
+	flagSet.StringVar(&policy, "policy", "", "policy of duplicate keys for merge")
+	flagSet.StringVar(&dbMap, "db-map", "", "move keys of a db in an input into another db")
+	flagSet.IntVar(&targetVersion, "target-version", 0, "rdb version of output file")
+	flagSet.StringVar(&dialect, "dialect", "", "dialect of output file: redis/valkey")
+	flagSet.BoolVar(&raw, "raw", false, "copy values verbatim without decoding them")
+	flagSet.BoolVar(&downgradeStream, "downgrade-stream", false, "downgrade streams to the format of target version")
 	_ = flagSet.Parse(os.Args[1:]) // ExitOnError
 	src := flagSet.Arg(0)
 
@@ -112,6 +198,29 @@ func main() {
 	if regexExpr != "" {
 		options = append(options, helper.WithRegexOption(regexExpr))
 	}
+	if excludeRegexExpr != "" {
+		options = append(options, helper.WithExcludeRegexOption(excludeRegexExpr))
+	}
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == "db" && cmd != "get" {
+			options = append(options, helper.WithDBOption(db))
+		}
+	})
+	if targetVersion > 0 {
+		options = append(options, helper.WithTargetVersion(targetVersion))
+	}
+	if dialect != "" {
+		options = append(options, helper.WithDialect(dialect))
+	}
+	if raw {
+		options = append(options, helper.WithRawCopy())
+	}
+	if downgradeStream {
+		options = append(options, helper.WithStreamDowngrade())
+	}
+	if renameExpr != "" {
+		options = append(options, helper.WithRenameOption(renameExpr, renameTo))
+	}
 	if noExpired {
 		options = append(options, helper.WithNoExpiredOption())
 	}
@@ -127,11 +236,20 @@ func main() {
 	if showGlobalMeta {
 		options = append(options, helper.WithGlobalMeta())
 	}
+	if metadataOnly {
+		options = append(options, helper.WithMetadataOnly())
+	}
+	if parallel > 1 {
+		options = append(options, helper.WithParallel(parallel, true))
+	}
+	progress := newProgressPrinter(os.Stderr)
+	progressOpt := helper.WithProgress(progress.update)
+	options = append(options, progressOpt)
 
 	var outputFile *os.File
 	if output == "" {
 		outputFile = os.Stdout
-	} else {
+	} else if cmd != "split" { // output of split is a directory
 		outputFile, err = os.Create(output)
 		if err != nil {
 			fmt.Printf("open output faild: %v", err)
@@ -147,7 +265,7 @@ func main() {
 	case "memory":
 		err = helper.MemoryProfile(src, output, options...)
 	case "aof":
-		err = helper.ToAOF(src, output, options)
+		err = helper.ToAOF(src, output, options...)
 	case "bigkey":
 		err = helper.FindBiggestKeys(src, n, outputFile, options...)
 	case "hotkey":
@@ -158,8 +276,54 @@ func main() {
 		} else {
 			err = helper.PrefixAnalyse(src, n, maxDepth, outputFile, options...)
 		}
+	case "index":
+		err = helper.BuildIndex(src, progressOpt)
+	case "get":
+		err = helper.GetKey(src, db, key, format, outputFile, progressOpt)
+	case "functions":
+		err = helper.ListFunctions(src, format, outputFile, options...)
+	case "slots":
+		// slot sizes recorded by valkey cluster are checked if there are any, otherwise slots are analysed
+		check := false
+		if !analyse {
+			check, err = helper.HasSlotInfo(src)
+			if err != nil {
+				break
+			}
+		}
+		if check {
+			err = helper.CheckSlots(src, outputFile, options...)
+			break
+		}
+		var layout []*helper.SlotNode
+		if nodes != "" {
+			layout, err = helper.ParseSlotLayout(nodes)
+			if err != nil {
+				break
+			}
+		}
+		err = helper.SlotAnalyse(src, n, layout, outputFile, options...)
+	case "split":
+		var layout []*helper.SlotNode
+		layout, err = helper.ParseSlotLayout(nodes)
+		if err != nil {
+			break
+		}
+		err = helper.SplitRDB(src, layout, output, options...)
+	case "rdb", "convert":
+		err = helper.ToRDB(src, output, options...)
+	case "merge":
+		err = helper.MergeRDB(flagSet.Args(), output, helper.MergePolicy(policy), helper.WithDBRemap(dbMap), progressOpt)
+	case "salvage":
+		var report *core.SalvageReport
+		report, err = helper.Salvage(src, output, options...)
+		progress.finish()
+		if report != nil {
+			printSalvageReport(os.Stdout, report)
+		}
 	case "flamegraph":
 		_, err = helper.FlameGraph(src, port, seps, options...)
+		progress.finish()
 		if err != nil {
 			fmt.Printf("error: %v\n", err)
 			return
@@ -169,8 +333,21 @@ func main() {
 		println("unknown command")
 		return
 	}
+	progress.finish()
 	if err != nil {
 		fmt.Printf("error: %v\n", err)
 		return
 	}
 }
+
+func printSalvageReport(w io.Writer, report *core.SalvageReport) {
+	_, _ = fmt.Fprintf(w, "recovered %d keys, skipped %d bytes in %d damaged regions\n",
+		report.Recovered, report.SkippedBytes, len(report.Damaged))
+	for _, region := range report.Damaged {
+		_, _ = fmt.Fprintf(w, "offset %d: skipped %d bytes after key %q, %v\n",
+			region.Offset, region.Length, region.LastKey, region.Err)
+	}
+	for _, key := range report.Unplaced {
+		_, _ = fmt.Fprintf(w, "key %q is not written, since db %d has been written before it\n", key.Key, key.DB)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"testing"
+
+	"github.com/hdt3213/rdb/core"
 )
 
 // just make sure it can parse command line args correctly
@@ -34,6 +38,11 @@ func TestCmd(t *testing.T) {
 	if f, _ := os.Stat("tmp/memory.csv"); f == nil {
 		t.Error("command memory failed")
 	}
+	os.Args = []string{"", "-c", "memory", "-parallel", "4", "-o", "tmp/memory_parallel.csv", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/memory_parallel.csv"); f == nil {
+		t.Error("command memory with parallel failed")
+	}
 	os.Args = []string{"", "-c", "aof", "-o", "tmp/memory.aof", "cases/memory.rdb"}
 	main()
 	if f, _ := os.Stat("tmp/memory.aof"); f == nil {
@@ -46,6 +55,11 @@ func TestCmd(t *testing.T) {
 	}
 	os.Args = []string{"", "-c", "bigkey", "-n", "10", "cases/memory.rdb"}
 	main()
+	os.Args = []string{"", "-c", "bigkey", "-o", "tmp/bigkey_meta.csv", "-n", "10", "-metadata-only", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/bigkey_meta.csv"); f == nil {
+		t.Error("command bigkey with metadata-only failed")
+	}
 
 	os.Args = []string{"", "-c", "memory", "-o", "tmp/memory_regex.csv", "-regex", "^l.*", "cases/memory.rdb"}
 	main()
@@ -69,6 +83,87 @@ func TestCmd(t *testing.T) {
 		t.Error("command prefix with prefix-sep failed")
 	}
 
+	rdbData, err := os.ReadFile("cases/memory.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile("tmp/dump.rdb", rdbData, 0644)
+	os.Args = []string{"", "-c", "index", "tmp/dump.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/dump.rdb.idx"); f == nil {
+		t.Error("command index failed")
+	}
+	os.Args = []string{"", "-c", "get", "-key", "list", "-o", "tmp/get.json", "tmp/dump.rdb"}
+	main()
+	if data, _ := os.ReadFile("tmp/get.json"); !bytes.Contains(data, []byte(`"key":"list"`)) {
+		t.Error("command get failed")
+	}
+	os.Args = []string{"", "-c", "get", "-key", "list", "-format", "resp", "-o", "tmp/get.aof", "tmp/dump.rdb"}
+	main()
+	if data, _ := os.ReadFile("tmp/get.aof"); !bytes.Contains(data, []byte("RPUSH")) {
+		t.Error("command get with resp format failed")
+	}
+	os.Args = []string{"", "-c", "get", "-key", "none", "tmp/dump.rdb"}
+	main()
+	rdbData[bytes.Index(rdbData, []byte("\x04zset"))-1] = 8 // damage type of key zset
+	_ = os.WriteFile("tmp/damaged.rdb", rdbData, 0644)
+	os.Args = []string{"", "-c", "salvage", "-o", "tmp/salvaged.rdb", "tmp/damaged.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/salvaged.rdb"); f == nil {
+		t.Error("command salvage failed")
+	}
+	os.Args = []string{"", "-c", "slots", "-o", "tmp/slots.csv", "cases/valkey_slots.rdb"}
+	main()
+	if data, _ := os.ReadFile("tmp/slots.csv"); !bytes.Contains(data, []byte("0,5474,3,2,0,0,false")) {
+		t.Error("command slots failed")
+	}
+	os.Args = []string{"", "-c", "slots", "-analyse", "-n", "2", "-nodes", "3", "-o", "tmp/slots.csv", "cases/valkey_slots.rdb"}
+	main()
+	if data, _ := os.ReadFile("tmp/slots.csv"); !bytes.Contains(data, []byte("node2,5461-10922,2,")) {
+		t.Error("command slots -analyse failed")
+	}
+	os.Args = []string{"", "-c", "slots", "-n", "2", "-o", "tmp/slots.csv", "cases/memory.rdb"}
+	main()
+	if data, _ := os.ReadFile("tmp/slots.csv"); !bytes.HasPrefix(data, []byte("slot,key_count,size")) {
+		t.Error("command slots failed for dump without slot info")
+	}
+	os.Args = []string{"", "-c", "split", "-nodes", "a=0-8191;b=8192-16383", "-o", "tmp/split", "cases/valkey_slots.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/split/b.rdb"); f == nil {
+		t.Error("command split failed")
+	}
+	os.Args = []string{"", "-c", "rdb", "-exclude-regex", "^l", "-db", "0", "-rename", "^s", "-rename-to", "str:",
+		"-o", "tmp/filtered.rdb", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/filtered.rdb"); f == nil {
+		t.Error("command rdb failed")
+	}
+	os.Args = []string{"", "-c", "convert", "-target-version", "9", "-o", "tmp/converted.rdb", "cases/memory.rdb"}
+	main()
+	if data, _ := os.ReadFile("tmp/converted.rdb"); !bytes.HasPrefix(data, []byte("REDIS0009")) {
+		t.Error("command convert failed")
+	}
+	os.Args = []string{"", "-c", "convert", "-dialect", "valkey", "-o", "tmp/valkey.rdb", "cases/hash_with_hfe.rdb"}
+	main()
+	if data, _ := os.ReadFile("tmp/valkey.rdb"); !bytes.HasPrefix(data, []byte("VALKEY080")) {
+		t.Error("command convert with dialect failed")
+	}
+	os.Args = []string{"", "-c", "rdb", "-raw", "-regex", "^b", "-o", "tmp/raw.rdb", "cases/redisbloom.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/raw.rdb"); f == nil {
+		t.Error("command rdb with raw failed")
+	}
+	os.Args = []string{"", "-c", "merge", "-policy", "first-wins", "-o", "tmp/merged.rdb", "tmp/split/a.rdb", "tmp/split/b.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/merged.rdb"); f == nil {
+		t.Error("command merge failed")
+	}
+	os.Args = []string{"", "-c", "functions", "-format", "csv", "-o", "tmp/functions.csv", "cases/function.rdb"}
+	main()
+	if data, _ := os.ReadFile("tmp/functions.csv"); !bytes.Contains(data, []byte("mylib,lua,myfunc")) {
+		t.Error("command functions failed")
+	}
+
 	// test error command line
 	os.Args = []string{"", "-c", "json", "-o", "tmp/output", "/none/a"}
 	main()
@@ -106,3 +201,34 @@ func TestCmd(t *testing.T) {
 	os.Args = []string{"", "-c", "aof"}
 	main()
 }
+
+func TestPrintSalvageReport(t *testing.T) {
+	buf := &bytes.Buffer{}
+	printSalvageReport(buf, &core.SalvageReport{
+		Recovered:    6,
+		SkippedBytes: 58,
+		Damaged: []*core.DamagedRegion{
+			{Offset: 249, Length: 58, LastKey: "list", Err: errors.New("unknown type flag: 1000")},
+		},
+	})
+	expect := "recovered 6 keys, skipped 58 bytes in 1 damaged regions\n" +
+		"offset 249: skipped 58 bytes after key \"list\", unknown type flag: 1000\n"
+	if buf.String() != expect {
+		t.Errorf("wrong report: %s", buf.String())
+	}
+}
+
+func TestProgressPrinter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	printer := newProgressPrinter(buf)
+	printer.update(core.Progress{ReadBytes: 512, TotalBytes: 2048, Objects: 3, DB: 1})
+	if buf.Len() != 0 {
+		t.Errorf("progress should be throttled, actual: %q", buf.String())
+	}
+	printer.finish()
+	printer.finish()
+	expect := "\rparsed 512B/2K (25.0%), 3 objects, db 1\033[K\n"
+	if buf.String() != expect {
+		t.Errorf("expect %q, actual %q", expect, buf.String())
+	}
+}
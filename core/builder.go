@@ -0,0 +1,277 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// listNodeEntries is the number of elements in a quicklist node written by ListBuilder.
+// The number of nodes is written before nodes, so nodes are split by count rather than by size.
+const listNodeEntries = 128
+
+// collectionBuilder writes elements of a collection one by one, its length is written before elements
+type collectionBuilder struct {
+	enc      *Encoder
+	key      string
+	count    uint64
+	appended uint64
+	ended    bool
+	err      error
+}
+
+// beginCollection writes type and key of a collection with count elements and locks enc until End
+func (enc *Encoder) beginCollection(key string, objType byte, count uint64, options ...interface{}) (*collectionBuilder, error) {
+	if count == 0 {
+		return nil, fmt.Errorf("cannot write empty collection: %s", key)
+	}
+	err := enc.beforeWriteObject(options...)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.write([]byte{objType})
+	if err != nil {
+		return nil, err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return nil, err
+	}
+	// other objects cannot be written until End
+	enc.state = writingObjectState
+	return &collectionBuilder{
+		enc:   enc,
+		key:   key,
+		count: count,
+	}, nil
+}
+
+// next returns error if all declared elements have been appended or the builder has failed
+func (b *collectionBuilder) next() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.appended >= b.count {
+		return fmt.Errorf("%s declares %d elements, cannot append more", b.key, b.count)
+	}
+	b.appended++
+	return nil
+}
+
+// fail keeps the first error, the rdb is broken after a failed write
+func (b *collectionBuilder) fail(err error) error {
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+	return err
+}
+
+func (b *collectionBuilder) end() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.ended {
+		return errors.New("collection has been ended")
+	}
+	if b.appended != b.count {
+		return b.fail(fmt.Errorf("%s declares %d elements, but %d are appended", b.key, b.count, b.appended))
+	}
+	b.ended = true
+	b.enc.state = writtenObjectState
+	return nil
+}
+
+// ListBuilder writes a list element by element, created by Encoder.BeginList
+type ListBuilder struct {
+	*collectionBuilder
+	quickList2 bool
+	page       []string
+}
+
+// BeginList starts writing a list with count elements in quicklist encoding, elements are passed by Append and
+// written in nodes of 128 elements, so only one node is kept in memory. End must be called after all elements are
+// appended, other objects cannot be written before that. Quicklist of listpack nodes is written if
+// WithEncoding(model.QuickList2Encoding) is passed and rdb version is 10 or later.
+// Supported options are TTLOption, IdleTimeOption, FreqOption, KeyMetaOption and EncodingOption.
+func (enc *Encoder) BeginList(key string, count uint64, options ...interface{}) (*ListBuilder, error) {
+	objType := byte(typeListQuickList)
+	quickList2 := hintedEncoding(options...) == model.QuickList2Encoding && enc.atLeastVersion(10)
+	if quickList2 {
+		objType = typeListQuickList2
+	}
+	b, err := enc.beginCollection(key, objType, count, options...)
+	if err != nil {
+		return nil, err
+	}
+	nodes := (count + listNodeEntries - 1) / listNodeEntries
+	err = enc.writeLength(nodes)
+	if err != nil {
+		return nil, b.fail(err)
+	}
+	return &ListBuilder{
+		collectionBuilder: b,
+		quickList2:        quickList2,
+		page:              make([]string, 0, listNodeEntries),
+	}, nil
+}
+
+// Append appends value to the tail of list, value is copied so it can be reused after Append returns
+func (b *ListBuilder) Append(value []byte) error {
+	err := b.next()
+	if err != nil {
+		return err
+	}
+	b.page = append(b.page, string(value))
+	if len(b.page) == listNodeEntries || b.appended == b.count {
+		return b.fail(b.flush())
+	}
+	return nil
+}
+
+// flush writes buffered elements as a quicklist node
+func (b *ListBuilder) flush() error {
+	defer func() {
+		b.page = b.page[:0]
+	}()
+	if !b.quickList2 {
+		return b.enc.writeZipList(b.page)
+	}
+	err := b.enc.writeLength(model.QuicklistNodeContainerPacked)
+	if err != nil {
+		return err
+	}
+	lp := newListPackBuilder()
+	for _, value := range b.page {
+		lp.appendString(value)
+	}
+	return b.enc.writeNanString(unsafeBytes2Str(lp.bytes()))
+}
+
+// End finishes the list, it returns error if the number of appended elements is not the declared count
+func (b *ListBuilder) End() error {
+	return b.end()
+}
+
+// SetBuilder writes a set member by member, created by Encoder.BeginSet
+type SetBuilder struct {
+	*collectionBuilder
+}
+
+// BeginSet starts writing a set with count members in hash table encoding, members are passed by Append and written
+// immediately. End must be called after all members are appended, other objects cannot be written before that.
+// Members are not deduplicated. Supported options are TTLOption, IdleTimeOption, FreqOption and KeyMetaOption.
+func (enc *Encoder) BeginSet(key string, count uint64, options ...interface{}) (*SetBuilder, error) {
+	b, err := enc.beginCollection(key, typeSet, count, options...)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.writeLength(count)
+	if err != nil {
+		return nil, b.fail(err)
+	}
+	return &SetBuilder{collectionBuilder: b}, nil
+}
+
+// Append appends a member to set
+func (b *SetBuilder) Append(member []byte) error {
+	err := b.next()
+	if err != nil {
+		return err
+	}
+	return b.fail(b.enc.writeString(unsafeBytes2Str(member)))
+}
+
+// End finishes the set, it returns error if the number of appended members is not the declared count
+func (b *SetBuilder) End() error {
+	return b.end()
+}
+
+// HashBuilder writes a hash field by field, created by Encoder.BeginHash
+type HashBuilder struct {
+	*collectionBuilder
+}
+
+// BeginHash starts writing a hash with count fields in hash table encoding, fields are passed by Append and written
+// immediately. End must be called after all fields are appended, other objects cannot be written before that.
+// Fields are not deduplicated. Supported options are TTLOption, IdleTimeOption, FreqOption and KeyMetaOption.
+func (enc *Encoder) BeginHash(key string, count uint64, options ...interface{}) (*HashBuilder, error) {
+	b, err := enc.beginCollection(key, typeHash, count, options...)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.writeLength(count)
+	if err != nil {
+		return nil, b.fail(err)
+	}
+	return &HashBuilder{collectionBuilder: b}, nil
+}
+
+// Append appends a field and its value to hash
+func (b *HashBuilder) Append(field string, value []byte) error {
+	err := b.next()
+	if err != nil {
+		return err
+	}
+	err = b.enc.writeString(field)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.fail(b.enc.writeString(unsafeBytes2Str(value)))
+}
+
+// End finishes the hash, it returns error if the number of appended fields is not the declared count
+func (b *HashBuilder) End() error {
+	return b.end()
+}
+
+// ZSetBuilder writes a sorted set member by member, created by Encoder.BeginZSet
+type ZSetBuilder struct {
+	*collectionBuilder
+	binaryScore bool
+}
+
+// BeginZSet starts writing a sorted set with count members in skiplist encoding, members are passed by Append and
+// written immediately, so they need not be sorted. End must be called after all members are appended, other objects
+// cannot be written before that. Members are not deduplicated, scores are written in strings before rdb version 8.
+// Supported options are TTLOption, IdleTimeOption, FreqOption and KeyMetaOption.
+func (enc *Encoder) BeginZSet(key string, count uint64, options ...interface{}) (*ZSetBuilder, error) {
+	objType := byte(typeZset)
+	binaryScore := enc.atLeastVersion(8)
+	if binaryScore {
+		objType = typeZset2
+	}
+	b, err := enc.beginCollection(key, objType, count, options...)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.writeLength(count)
+	if err != nil {
+		return nil, b.fail(err)
+	}
+	return &ZSetBuilder{
+		collectionBuilder: b,
+		binaryScore:       binaryScore,
+	}, nil
+}
+
+// Append appends a member and its score to sorted set
+func (b *ZSetBuilder) Append(member string, score float64) error {
+	err := b.next()
+	if err != nil {
+		return err
+	}
+	err = b.enc.writeString(member)
+	if err != nil {
+		return b.fail(err)
+	}
+	if b.binaryScore {
+		return b.fail(b.enc.writeFloat64(score))
+	}
+	return b.fail(b.enc.writeLiteralFloat(score))
+}
+
+// End finishes the sorted set, it returns error if the number of appended members is not the declared count
+func (b *ZSetBuilder) End() error {
+	return b.end()
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// buildCollections writes a list, set, hash and zset by builders, then reads them back
+func buildCollections(t *testing.T, enc *Encoder, buf *bytes.Buffer, size int, options ...interface{}) map[string]model.RedisObject {
+	err := enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteDBHeader(0, 4, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := enc.BeginList("list", uint64(size), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 0, 32)
+	for i := 0; i < size; i++ {
+		// value is reused by caller
+		value = strconv.AppendInt(value[:0], int64(i), 10)
+		value = append(value, "abc"[:i%4]...)
+		err = list.Append(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = list.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := enc.BeginSet("set", uint64(size), WithTTL(uint64(time.Now().Add(time.Hour).UnixMilli())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < size; i++ {
+		err = set.Append([]byte("m" + strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = set.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := enc.BeginHash("hash", uint64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < size; i++ {
+		err = hash.Append("f"+strconv.Itoa(i), []byte(strconv.Itoa(i*i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = hash.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zset, err := enc.BeginZSet("zset", uint64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < size; i++ {
+		score := float64(i) / 3
+		if i == 1 {
+			score = math.Inf(-1)
+		}
+		err = zset.Append("z"+strconv.Itoa(i), score)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = zset.End()
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := make(map[string]model.RedisObject)
+	err = NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+		objects[object.GetKey()] = object
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestBuilder(t *testing.T) {
+	for _, size := range []int{1, 128, 1000} {
+		for _, version := range []int{7, 10, MaxRedisEncoderVersion} {
+			buf := bytes.NewBuffer(nil)
+			enc, err := NewEncoderWithVersion(buf, version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			objects := buildCollections(t, enc, buf, size, WithEncoding(model.QuickList2Encoding))
+			list, ok := objects["list"].(*model.ListObject)
+			if !ok || len(list.Values) != size {
+				t.Fatalf("version %d size %d: wrong list %+v", version, size, objects["list"])
+			}
+			for i, value := range list.Values {
+				expect := strconv.Itoa(i) + "abc"[:i%4]
+				if string(value) != expect {
+					t.Errorf("version %d size %d: list[%d] expect %s, actual %s", version, size, i, expect, value)
+				}
+			}
+			expectEncoding := model.QuickList2Encoding
+			if version < 10 {
+				expectEncoding = model.QuickListEncoding
+			}
+			if list.GetEncoding() != expectEncoding {
+				t.Errorf("version %d: expect list in %s, actual %s", version, expectEncoding, list.GetEncoding())
+			}
+			set, ok := objects["set"].(*model.SetObject)
+			if !ok || len(set.Members) != size || set.GetExpiration() == nil {
+				t.Fatalf("version %d size %d: wrong set %+v", version, size, objects["set"])
+			}
+			for i, member := range set.Members {
+				if string(member) != "m"+strconv.Itoa(i) {
+					t.Errorf("version %d size %d: wrong set member %s", version, size, member)
+				}
+			}
+			hash, ok := objects["hash"].(*model.HashObject)
+			if !ok || len(hash.Hash) != size {
+				t.Fatalf("version %d size %d: wrong hash %+v", version, size, objects["hash"])
+			}
+			for i := 0; i < size; i++ {
+				if string(hash.Hash["f"+strconv.Itoa(i)]) != strconv.Itoa(i*i) {
+					t.Errorf("version %d size %d: wrong hash field f%d", version, size, i)
+				}
+			}
+			zset, ok := objects["zset"].(*model.ZSetObject)
+			if !ok || len(zset.Entries) != size {
+				t.Fatalf("version %d size %d: wrong zset %+v", version, size, objects["zset"])
+			}
+			for _, entry := range zset.Entries {
+				i, _ := strconv.Atoi(entry.Member[1:])
+				expect := float64(i) / 3
+				if i == 1 {
+					expect = math.Inf(-1)
+				}
+				if entry.Score != expect {
+					t.Errorf("version %d size %d: %s expect score %v, actual %v", version, size, entry.Member, expect, entry.Score)
+				}
+			}
+		}
+	}
+}
+
+func TestBuilderCount(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteDBHeader(0, 0, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = enc.BeginSet("empty", 0)
+	if err == nil {
+		t.Error("expect error for empty set")
+	}
+	list, err := enc.BeginList("list", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = list.Append([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteStringObject("a", []byte("a"))
+	if err == nil {
+		t.Error("expect error for writing object before End")
+	}
+	err = list.End()
+	if err == nil {
+		t.Error("expect error for less elements than declared")
+	}
+
+	enc = NewEncoder(bytes.NewBuffer(nil))
+	err = enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteDBHeader(0, 0, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := enc.BeginHash("hash", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = hash.Append("a", []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = hash.Append("b", []byte("2"))
+	if err == nil {
+		t.Error("expect error for more elements than declared")
+	}
+	err = hash.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = hash.End()
+	if err == nil {
+		t.Error("expect error for ending twice")
+	}
+	err = enc.WriteStringObject("a", []byte("a"))
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// KeepEncodingOption makes WriteObject write objects in encodings recorded in BaseObject.Encoding
+type KeepEncodingOption bool
+
+// WithKeepEncoding makes WriteObject write objects in encodings recorded in BaseObject.Encoding if possible,
+// like WithEncoding(object.GetEncoding()). Objects are written in encodings chosen by Encoder by default.
+func WithKeepEncoding() KeepEncodingOption {
+	return true
+}
+
+// KeyOption makes WriteObject write a key with another name
+type KeyOption string
+
+// WithKey makes WriteObject write a key with the given name instead of its own name, like renaming it
+func WithKey(key string) KeyOption {
+	return KeyOption(key)
+}
+
+// DBOption makes WriteObject write a key into another db
+type DBOption int
+
+// WithDB makes WriteObject write a key into the given db instead of its own db, like moving it
+func WithDB(db int) DBOption {
+	return DBOption(db)
+}
+
+// WriteObject writes an object read by Decoder. Keys are written with their expiration, LRU/LFU info, metadata
+// and field expirations, a db header is written before the first key of each db, so keys of a db should be
+// written together. Values read with Decoder.WithRawValue are copied by WriteRawObject. Aux fields, functions,
+// module aux data, db size and slot info or slot import of valkey are written as they are. Values of module types,
+// module aux data and metadata should be ModuleValues, which are read by Decoder with WithModuleValues.
+// Supported options are KeepEncodingOption, EncodingOption and StreamDowngradeOption, KeyOption and DBOption are
+// supported for keys.
+func (enc *Encoder) WriteObject(object model.RedisObject, options ...interface{}) error {
+	switch o := object.(type) {
+	case *model.AuxObject:
+		return enc.WriteAux(o.Key, o.Value)
+	case *model.FunctionsObject:
+		return enc.WriteFunctions(o.FunctionsLua)
+	case *model.ModuleAuxObject:
+		values, ok := o.Value.(ModuleValues)
+		if !ok {
+			return fmt.Errorf("cannot write value of module aux %s: %T", o.ModuleType, o.Value)
+		}
+		return enc.WriteModuleAux(o.ModuleType, o.EncVersion, o.When, values.Save)
+	case *model.SlotImportObject:
+		return enc.WriteSlotImport(o.JobName, o.Ranges)
+	case *model.DBSizeObject:
+		// db header is written before the first key of db, since Encoder does not allow empty db
+		enc.dbSize = o
+		return nil
+	case *model.SlotInfoObject:
+		err := enc.selectDB(o.DB)
+		if err != nil {
+			return err
+		}
+		return enc.WriteSlotInfo(o.Slot, o.KeyCount, o.ExpiresCount)
+	}
+	key := object.GetKey()
+	db := object.GetDBIndex()
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case KeyOption:
+			key = string(o)
+		case DBOption:
+			db = int(o)
+		}
+	}
+	err := enc.selectDB(db)
+	if err != nil {
+		return err
+	}
+	options = objectOptions(object, options...)
+	if info, ok := object.(model.RawValueInfo); ok {
+		if rawType, rawValue := info.GetRawValue(); rawValue != nil {
+			return enc.WriteRawObject(key, rawType, rawValue, options...)
+		}
+	}
+	switch o := object.(type) {
+	case *model.StringObject:
+		return enc.WriteStringObject(key, o.Value, options...)
+	case *model.ListObject:
+		return enc.WriteListObject(key, o.Values, options...)
+	case *model.SetObject:
+		return enc.WriteSetObject(key, o.Members, options...)
+	case *model.HashObject:
+		if len(o.FieldExpirations) > 0 {
+			return enc.WriteHashMapObjectEx(key, o.Hash, o.FieldExpirations, options...)
+		}
+		return enc.WriteHashMapObject(key, o.Hash, options...)
+	case *model.ZSetObject:
+		return enc.WriteZSetObject(key, o.Entries, options...)
+	case *model.StreamObject:
+		return enc.WriteStreamObject(key, o, options...)
+	case *model.ModuleTypeObject:
+		values, ok := o.Value.(ModuleValues)
+		if !ok {
+			return fmt.Errorf("cannot write value of module type %s: %T", o.ModuleType, o.Value)
+		}
+		return enc.WriteModuleTypeObject(key, o.ModuleType, o.EncVersion, values.Save, options...)
+	}
+	return fmt.Errorf("cannot write %s object", object.GetType())
+}
+
+// selectDB writes db header if db is not the db of the latest db header.
+// Resize db is written only if db size of the db is passed to WriteObject
+func (enc *Encoder) selectDB(db int) error {
+	if len(enc.existDB) > 0 && enc.db == uint(db) {
+		return nil
+	}
+	dbSize := enc.dbSize
+	enc.dbSize = nil
+	if dbSize != nil && dbSize.DB == db {
+		return enc.WriteDBHeader(uint(db), dbSize.KeyCount, dbSize.TTLCount)
+	}
+	return enc.writeSelectDB(uint(db))
+}
+
+// objectOptions returns options of expiration, LRU/LFU info, metadata and encoding of object
+func objectOptions(object model.RedisObject, options ...interface{}) []interface{} {
+	result := make([]interface{}, 0, len(options)+4)
+	for _, opt := range options {
+		switch opt.(type) {
+		case KeepEncodingOption:
+			result = append(result, WithEncoding(object.GetEncoding()))
+		case KeyOption, DBOption: // used by WriteObject
+		default:
+			result = append(result, opt)
+		}
+	}
+	if expiration := object.GetExpiration(); expiration != nil {
+		result = append(result, WithTTL(uint64(expiration.UnixNano()/1e6)))
+	}
+	if info, ok := object.(model.EvictionInfo); ok {
+		if idle := info.GetIdleTime(); idle >= 0 {
+			result = append(result, WithIdleTime(uint64(idle)))
+		} else if freq := info.GetFreq(); freq >= 0 {
+			result = append(result, WithFreq(uint8(freq)))
+		}
+	}
+	if info, ok := object.(model.KeyMetaInfo); ok && len(info.GetMetadata()) > 0 {
+		result = append(result, WithKeyMeta(info.GetMetadata()...))
+	}
+	return result
+}
+
+// rawTypeVersions is the earliest rdb version of value types since rdb version 7
+var rawTypeVersions = map[byte]int{
+	typeZset2:                 8,
+	typeModule2:               8,
+	typeStreamListPacks:       9,
+	typeHashListPack:          10,
+	typeZsetListPack:          10,
+	typeListQuickList2:        10,
+	typeStreamListPacks2:      10,
+	typeSetListPack:           11,
+	typeStreamListPacks3:      11,
+	typeHashWithHfeRc:         12,
+	typeHashListPackWithHfeRc: 12,
+	typeHashWithHfe:           12,
+	typeHashListPackWithHfe:   12,
+}
+
+// WriteRawObject writes a value encoded in rdb format, which is read by Decoder with WithRawValue,
+// like WriteRawObject(key, object.RawType, object.RawValue). Value is copied verbatim, so it keeps encoding and
+// LZF compression of the source rdb. Source rdb should be of the same dialect, types not supported by version
+// of enc are rejected. Supported options are TTLOption, IdleTimeOption, FreqOption and KeyMetaOption.
+func (enc *Encoder) WriteRawObject(key string, rawType byte, rawValue []byte, options ...interface{}) error {
+	if _, ok := encodingMap[int(rawType)]; !ok && rawType != typeModule2 && rawType != typeStreamListPacks3 {
+		return fmt.Errorf("unsupported value type: %d", rawType)
+	}
+	if enc.valkey && rawType > typeHash2 {
+		return fmt.Errorf("value type %d is not supported by valkey rdb", rawType)
+	}
+	if version, ok := rawTypeVersions[rawType]; ok {
+		err := enc.requireVersion(version, fmt.Sprintf("value type %d", rawType))
+		if err != nil {
+			return err
+		}
+	}
+	err := enc.beforeWriteObject(options...)
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{rawType})
+	if err != nil {
+		return err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return err
+	}
+	err = enc.write(rawValue)
+	if err != nil {
+		return err
+	}
+	enc.state = writtenObjectState
+	return nil
+}
+
+// Copy reads all objects from dec and writes them by enc, including rdb header and end.
+// It enables WithSpecialOpCode and WithModuleValues of dec, so aux fields, functions and data of modules are kept.
+// Encodings of objects are kept if possible, and the rdb version and dialect are decided by enc.
+// To copy values verbatim without decoding them, enable WithRawValue and WithMetadataOnly of dec.
+func Copy(dec *Decoder, enc *Encoder) error {
+	err := enc.WriteHeader()
+	if err != nil {
+		return err
+	}
+	var writeErr error
+	err = dec.WithSpecialOpCode().WithModuleValues().Parse(func(object model.RedisObject) bool {
+		writeErr = enc.WriteObject(object, WithKeepEncoding())
+		return writeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("write object failed: %v", writeErr)
+	}
+	return enc.WriteEnd()
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// readAllObjects returns all objects in rdb data with raw values
+func readAllObjects(t *testing.T, name string, data []byte) []model.RedisObject {
+	var objects []model.RedisObject
+	dec := NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().WithModuleValues().WithRawValue()
+	err := dec.Parse(func(object model.RedisObject) bool {
+		objects = append(objects, object)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return objects
+}
+
+// clearEncodingDetail clears Size, Extra and raw value of objects, since they depend on bytes of encoding,
+// like formats of scores in ziplist written by old redis
+func clearEncodingDetail(objects []model.RedisObject) {
+	for _, object := range objects {
+		base := reflect.ValueOf(object).Elem().FieldByName("BaseObject").Elem()
+		base.FieldByName("Size").SetInt(0)
+		base.FieldByName("Extra").Set(reflect.Zero(base.FieldByName("Extra").Type()))
+		base.FieldByName("RawType").SetUint(0)
+		base.FieldByName("RawValue").SetBytes(nil)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	files, err := filepath.Glob("../cases/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoder(buf)
+		if bytes.HasPrefix(data, magicNumberValkey) {
+			enc = NewEncoderValkey(buf)
+		}
+		err = Copy(NewDecoder(bytes.NewReader(data)), enc)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		expect := readAllObjects(t, file, data)
+		actual := readAllObjects(t, file+" copy", buf.Bytes())
+		clearEncodingDetail(expect)
+		clearEncodingDetail(actual)
+		if len(expect) != len(actual) {
+			t.Errorf("%s: expect %d objects, actual %d", file, len(expect), len(actual))
+			continue
+		}
+		for i := range expect {
+			if !reflect.DeepEqual(expect[i], actual[i]) {
+				t.Errorf("%s: object %d is not kept, expect %+v, actual %+v", file, i, expect[i], actual[i])
+			}
+		}
+	}
+}
+
+func TestWriteObject(t *testing.T) {
+	data, err := os.ReadFile("../cases/listpack.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	enc, err := NewEncoderWithVersion(buf, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// encodings not in rdb version 9 are replaced
+	err = Copy(NewDecoder(bytes.NewReader(data)), enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := readAllObjects(t, "listpack.rdb", data)
+	actual := readAllObjects(t, "listpack.rdb copy", buf.Bytes())
+	clearEncodingDetail(expect)
+	clearEncodingDetail(actual)
+	if len(expect) != len(actual) {
+		t.Fatalf("expect %d objects, actual %d", len(expect), len(actual))
+	}
+	for i := range expect {
+		encoding := actual[i].GetEncoding()
+		if encoding == model.ListPackEncoding || encoding == model.QuickList2Encoding {
+			t.Errorf("%s: %s is not supported by rdb version 9", actual[i].GetKey(), encoding)
+		}
+		for _, object := range []model.RedisObject{expect[i], actual[i]} {
+			reflect.ValueOf(object).Elem().FieldByName("BaseObject").Elem().FieldByName("Encoding").SetString("")
+		}
+		if !reflect.DeepEqual(expect[i], actual[i]) {
+			t.Errorf("object %d is not kept, expect %+v, actual %+v", i, expect[i], actual[i])
+		}
+	}
+
+	enc = NewEncoder(bytes.NewBuffer(nil))
+	err = enc.WriteHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteObject(&model.ModuleTypeObject{
+		BaseObject: &model.BaseObject{Key: "bloom"},
+		ModuleType: "MBbloom--",
+		Value:      struct{}{},
+	})
+	if err == nil {
+		t.Error("expect error for module value decoded by custom handler")
+	}
+
+	// keys are renamed and moved by options
+	buf = bytes.NewBuffer(nil)
+	enc = NewEncoder(buf)
+	err = enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteObject(&model.StringObject{
+			BaseObject: &model.BaseObject{Key: "a", DB: 1},
+			Value:      []byte("1"),
+		}, WithKey("b"), WithDB(2))
+	}
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := readAllObjects(t, "renamed", buf.Bytes())
+	if len(objects) != 1 || objects[0].GetKey() != "b" || objects[0].GetDBIndex() != 2 {
+		t.Errorf("key is not renamed or moved: %+v", objects)
+	}
+}
+
+func TestWriteRawObject(t *testing.T) {
+	files, err := filepath.Glob("../cases/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoder(buf)
+		if bytes.HasPrefix(data, magicNumberValkey) {
+			enc = NewEncoderValkey(buf)
+		}
+		// values are not decoded and copied verbatim
+		err = Copy(NewDecoder(bytes.NewReader(data)).WithRawValue().WithMetadataOnly(), enc)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		expect := readAllObjects(t, file, data)
+		actual := readAllObjects(t, file+" copy", buf.Bytes())
+		if len(expect) != len(actual) {
+			t.Errorf("%s: expect %d objects, actual %d", file, len(expect), len(actual))
+			continue
+		}
+		for i := range expect {
+			if !reflect.DeepEqual(expect[i], actual[i]) {
+				t.Errorf("%s: object %d is not kept, expect %+v, actual %+v", file, i, expect[i], actual[i])
+			}
+		}
+	}
+
+	enc, err := NewEncoderWithVersion(bytes.NewBuffer(nil), 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteDBHeader(0, 0, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteRawObject("a", typeSetListPack, []byte{})
+	if err == nil {
+		t.Error("expect error for listpack in rdb version 9")
+	}
+	err = enc.WriteRawObject("a", typeModule, []byte{})
+	if err == nil {
+		t.Error("expect error for unsupported type")
+	}
+	err = NewEncoderValkey(bytes.NewBuffer(nil)).WriteRawObject("a", typeHashWithHfe, []byte{})
+	if err == nil {
+		t.Error("expect error for hash with field expiration of redis in valkey rdb")
+	}
+}
@@ -4,63 +4,136 @@ package core
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"strconv"
 	"time"
 
+	"github.com/hdt3213/rdb/crc64jones"
 	"github.com/hdt3213/rdb/memprofiler"
 	"github.com/hdt3213/rdb/model"
 )
 
 // Decoder is an instance of rdb parsing process
 type Decoder struct {
+	reader    io.Reader
 	input     *bufio.Reader
 	readCount int
 	buffer    []byte
+	crc       hash.Hash64 // crc is nil in decoders not verifying checksum, like workers of parallel decoding
+	crcBuffer [1]byte
 
 	withSpecialOpCode bool
+	withoutChecksum   bool
 	withSpecialTypes  map[string]ModuleTypeHandleFunc
+	withModuleValues  bool
+	withRawValue      bool
+	capturing         bool   // capturing is true while reading value of a key with withRawValue
+	rawValue          []byte // rawValue stores bytes read while capturing
 
 	valkey     bool
 	rdbVersion int
+
+	elementCb func(event *ElementEvent) bool // elementCb is set by ParseElements
+	batchSize int
+
+	metadataOnly bool
+	skipBuffer   []byte
+
+	ctx          context.Context // ctx is set by ParseContext
+	done         <-chan struct{}
+	progressHook ProgressHookFunc
+	totalSize    int64
+	objectCount  int
+	currentDB    int
+
+	concurrency  int
+	ordered      bool
+	rangeSize    int64       // rangeSize is bytes of a range in parallel mode, 0 means auto
+	rng          *parseRange // rng is set for decoders of parallel workers
+	opOffset     int64       // opOffset is offset of current opcode
+	objectBegin  int64       // objectBegin is offset of the first opcode of current key, including expiration, lru, lfu, etc.
+	parallelRead *int64      // parallelRead is bytes decoded by parallel workers
+
+	maxLength uint64 // maxLength limits lengths of strings and collections in salvage mode, 0 means unlimited
 }
 
 // NewDecoder creates a new RDB decoder
 func NewDecoder(reader io.Reader) *Decoder {
 	parser := new(Decoder)
+	parser.reader = reader
 	parser.input = bufio.NewReader(reader)
 	parser.buffer = make([]byte, 8)
+	parser.crc = crc64jones.New()
 	parser.withSpecialTypes = make(map[string]ModuleTypeHandleFunc)
+	parser.totalSize = totalSizeOf(reader)
 	return parser
 }
 
-// WithSpecialOpCode enables returning model.AuxObject to callback
+// WithSpecialOpCode enables returning model.AuxObject, model.DBSizeObject, model.FunctionsObject,
+// model.ModuleAuxObject, model.SlotInfoObject and model.SlotImportObject to callback
 func (dec *Decoder) WithSpecialOpCode() *Decoder {
 	dec.withSpecialOpCode = true
 	return dec
 }
 
-// WithSpecialType enables returning redis module data structure to callback
+// WithoutChecksum disables verification of the CRC64 checksum at the end of rdb file
+func (dec *Decoder) WithoutChecksum() *Decoder {
+	dec.withoutChecksum = true
+	return dec
+}
+
+// WithSpecialType enables returning redis module data structure to callback.
+// The handler also decodes aux data of the module, see model.ModuleAuxObject
 func (dec *Decoder) WithSpecialType(moduleType string, f ModuleTypeHandleFunc) *Decoder {
 	dec.withSpecialTypes[moduleType] = f
 	return dec
 }
 
+// WithModuleValues makes Decoder read module types, module aux data and key metadata which have no handler
+// registered by WithSpecialType as ModuleValues, instead of skipping them. They can be written by Encoder.WriteObject
+func (dec *Decoder) WithModuleValues() *Decoder {
+	dec.withModuleValues = true
+	return dec
+}
+
+// WithRawValue makes Decoder keep encoded value of each key in rdb file, including the type byte,
+// as BaseObject.RawType and BaseObject.RawValue. They can be copied by Encoder.WriteRawObject.
+// Combined with WithMetadataOnly, values are copied without being decoded. It does not work with ParseElements.
+func (dec *Decoder) WithRawValue() *Decoder {
+	dec.withRawValue = true
+	return dec
+}
+
 var magicNumberRedis = []byte("REDIS")
 var magicNumberValkey = []byte("VALKEY")
 
 const (
 	minVersion       = 1
-	maxVersion       = 12
+	maxVersion       = 13
 	minVersionValkey = 80
 	maxVersionValkey = 80
+
+	minChecksumVersion = 5 // rdb files before version 5 have no checksum at the end
 )
 
+// ChecksumError means the CRC64 checksum at the end of rdb file does not match its content
+type ChecksumError struct {
+	Expected uint64 // Expected is the checksum recorded in rdb file
+	Actual   uint64 // Actual is the checksum computed from rdb content
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %016x, actual %016x", e.Expected, e.Actual)
+}
+
 const (
 	opCodeSlotImport   = 243 /* Slot import state. */
+	opCodeKeyMeta      = 243 /* Key metadata, since Redis 8 (same value as Valkey's opCodeSlotImport). */
 	opCodeSlotInfo     = 244 /* Foreign slot info, safe to ignore. */
 	opCodeFunction     = 245 /* function library data */
 	opCodeModuleAux    = 247 /* Module auxiliary data. */
@@ -330,13 +403,18 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 		stream.BaseObject = base
 		return stream, nil
 	case typeModule2:
-		moduleType, val, err := dec.readModuleType()
+		moduleId, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		moduleType, val, err := dec.handleModuleType(moduleId)
 		if err != nil {
 			return nil, err
 		}
 		return &model.ModuleTypeObject{
 			BaseObject: base,
 			ModuleType: moduleType,
+			EncVersion: int(moduleTypeEncVersionByID(moduleId)),
 			Value:      val,
 		}, nil
 	case typeSetListPack:
@@ -388,12 +466,44 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 	var expireMs int64
 	var lru *int64
 	var lfu *int64
+	var keyMeta []*model.KeyMeta
+	var reachEOF bool
+	var keyBegin int64 = -1 // offset of the first opcode of expiration, lru, lfu or metadata of next key
+	if dec.rng != nil {
+		// resume state of the first key in range
+		state := dec.rng.state
+		dbIndex = state.DB
+		if state.Expiration != nil {
+			expireMs = state.Expiration.UnixNano() / int64(time.Millisecond)
+		}
+		lru = state.IdleTime
+		lfu = state.Freq
+		keyMeta = state.Metadata
+	}
+	origin := cb
+	cb = func(object model.RedisObject) bool {
+		tbc := origin(object)
+		dec.emitted()
+		return tbc
+	}
 	for {
+		if err := dec.cancelled(); err != nil {
+			return err
+		}
+		dec.opOffset = int64(dec.readCount)
+		if dec.rng != nil && dec.opOffset >= dec.rng.end {
+			return nil
+		}
 		b, err := dec.readByte()
 		if err != nil {
 			return err
 		}
+		if keyBegin < 0 && (b == opCodeExpireTime || b == opCodeExpireTimeMs || b == opCodeIdle || b == opCodeFreq ||
+			(b == opCodeKeyMeta && !dec.valkey)) {
+			keyBegin = dec.opOffset
+		}
 		if b == opCodeEOF {
+			reachEOF = true
 			break
 		} else if b == opCodeSelectDB {
 			dbIndex64, _, err := dec.readLength()
@@ -401,6 +511,7 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 				return err
 			}
 			dbIndex = int(dbIndex64)
+			dec.currentDB = dbIndex
 			continue
 		} else if b == opCodeExpireTime {
 			err = dec.readFull(dec.buffer[:4])
@@ -479,10 +590,16 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 			lru = &v
 			continue
 		} else if b == opCodeModuleAux {
-			_, _, err = dec.readModuleType()
+			obj, err := dec.readModuleAux()
 			if err != nil {
 				return err
 			}
+			if dec.withSpecialOpCode {
+				tbc := cb(obj)
+				if !tbc {
+					break
+				}
+			}
 			continue
 		} else if b == opCodeFunction {
 			functionsLua, err := dec.readString()
@@ -497,6 +614,9 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 				obj.Type = model.FunctionsType
 				obj.Encoding = "functions"
 				obj.FunctionsLua = unsafeBytes2Str(functionsLua)
+				if lib, err := ParseFunctionLibrary(obj.FunctionsLua); err == nil {
+					obj.Libraries = []model.FunctionLibrary{lib}
+				}
 				tbc := cb(obj)
 				if !tbc {
 					break
@@ -507,52 +627,83 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 			if !dec.valkey {
 				return fmt.Errorf("unsupported opcode 244 in Redis RDB version %d", dec.rdbVersion)
 			}
-			// Valkey 9+: slot info metadata, safe to skip
+			// Valkey 9+: slot info metadata
 			var err error
-			var slot_id, slot_size, expires_slot_size uint64
-			slot_id, _, err = dec.readLength()
+			var slotId, slotSize, expiresSlotSize uint64
+			slotId, _, err = dec.readLength()
 			if err == nil {
-				slot_size, _, err = dec.readLength()
+				slotSize, _, err = dec.readLength()
 			}
 			if err == nil {
-				expires_slot_size, _, err = dec.readLength()
+				expiresSlotSize, _, err = dec.readLength()
 			}
 			if err != nil {
 				return err
 			}
-			_, _, _ = slot_id, slot_size, expires_slot_size // safe to skip
+			if dec.withSpecialOpCode {
+				obj := &model.SlotInfoObject{
+					BaseObject:   &model.BaseObject{},
+					Slot:         int(slotId),
+					KeyCount:     slotSize,
+					ExpiresCount: expiresSlotSize,
+				}
+				obj.DB = dbIndex
+				obj.Type = model.SlotInfoType
+				tbc := cb(obj)
+				if !tbc {
+					break
+				}
+			}
 			continue
-		} else if b == opCodeSlotImport { // opcode 243: Valkey=SlotImport, Redis 8.0+=KeyMeta
-			if dec.valkey {
-				// Valkey 9+: slot import state
-				job, err := dec.readString()
-				if err != nil {
-					return err
+		} else if b == opCodeSlotImport && dec.valkey { // opcode 243: Valkey=SlotImport, Redis 8.0+=KeyMeta
+			// Valkey 9+: slot import state
+			job, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			numSlotRanges, _, err := dec.readLength()
+			if err != nil {
+				return err
+			}
+			var slotFrom, slotTo uint64
+			ranges := make([]model.SlotRange, 0)
+			for i := uint64(0); i < numSlotRanges; i++ {
+				slotFrom, _, err = dec.readLength()
+				if err == nil {
+					slotTo, _, err = dec.readLength()
 				}
-				num_slot_ranges, _, err := dec.readLength()
 				if err != nil {
 					return err
 				}
-				var slot_from, slot_to uint64
-				ranges := make([]string, num_slot_ranges)
-				for i := uint64(0); i < num_slot_ranges; i++ {
-					slot_from, _, err = dec.readLength()
-					if err == nil {
-						slot_to, _, err = dec.readLength()
-					}
-					if err != nil {
-						return err
-					}
-					ranges[i] = fmt.Sprintf("%d-%d", slot_from, slot_to)
+				ranges = append(ranges, model.SlotRange{From: int(slotFrom), To: int(slotTo)})
+			}
+			if dec.withSpecialOpCode {
+				obj := &model.SlotImportObject{
+					BaseObject: &model.BaseObject{},
+					JobName:    string(job),
+					Ranges:     ranges,
 				}
-				_, _ = job, ranges // safe to skip
-			} else {
-				// Redis 8.0+: RDB_OPCODE_KEY_META (same opcode value 243)
-				// Not yet supported; return error to avoid silent data corruption
-				return fmt.Errorf("unsupported opcode: RDB_OPCODE_KEY_META (243) in Redis RDB version %d", dec.rdbVersion)
+				obj.Key = obj.JobName
+				obj.Type = model.SlotImportType
+				tbc := cb(obj)
+				if !tbc {
+					break
+				}
+			}
+			continue
+		} else if b == opCodeKeyMeta {
+			// Redis 8.0+: RDB_OPCODE_KEY_META, metadata of the following key
+			keyMeta, err = dec.readKeyMeta()
+			if err != nil {
+				return err
 			}
 			continue
 		}
+		dec.objectBegin = dec.opOffset
+		if keyBegin >= 0 {
+			dec.objectBegin = keyBegin
+			keyBegin = -1
+		}
 		key, err := dec.readString()
 		if err != nil {
 			return err
@@ -570,7 +721,36 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 		lru = nil // reset lru
 		base.Freq = lfu
 		lfu = nil // reset lfu
+		base.Metadata = keyMeta
+		keyMeta = nil // reset key meta
+		if dec.withRawValue && dec.elementCb == nil {
+			dec.capturing = true
+			dec.rawValue = nil
+		}
+		if dec.metadataOnly {
+			obj, err := dec.readObjectMetadata(b, base)
+			dec.endCapture(b, base)
+			if err != nil {
+				return err
+			}
+			if !cb(obj) {
+				break
+			}
+			continue
+		}
+		if dec.elementCb != nil {
+			tbc, err := dec.readObjectElements(b, base)
+			if err != nil {
+				return err
+			}
+			dec.emitted()
+			if !tbc {
+				break
+			}
+			continue
+		}
 		obj, err := dec.readObject(b, base)
+		dec.endCapture(b, base)
 		if err != nil {
 			return err
 		}
@@ -581,8 +761,48 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 			break
 		}
 	}
-	// read crc64 at the end
-	_ = dec.readFull(dec.buffer)
+	if !reachEOF {
+		return nil
+	}
+	err := dec.verifyChecksum()
+	dec.reportProgress()
+	return err
+}
+
+// endCapture stops capturing and saves type byte and captured value into base
+func (dec *Decoder) endCapture(flag byte, base *model.BaseObject) {
+	if !dec.capturing {
+		return
+	}
+	dec.capturing = false
+	base.RawType = flag
+	base.RawValue = dec.rawValue
+	dec.rawValue = nil
+}
+
+// verifyChecksum reads crc64 at the end and compares it with the checksum of consumed content
+func (dec *Decoder) verifyChecksum() error {
+	if dec.rdbVersion < minChecksumVersion {
+		return nil
+	}
+	// checksum itself is not a part of checksum, so do not use dec.readFull
+	_, err := io.ReadFull(dec.input, dec.buffer)
+	if err != nil {
+		return fmt.Errorf("read checksum failed: %v", err)
+	}
+	dec.readCount += len(dec.buffer)
+	expected := binary.LittleEndian.Uint64(dec.buffer)
+	if expected == 0 || dec.withoutChecksum {
+		// rdbchecksum is disabled when generating rdb
+		return nil
+	}
+	actual := dec.crc.Sum64()
+	if expected != actual {
+		return &ChecksumError{
+			Expected: expected,
+			Actual:   actual,
+		}
+	}
 	return nil
 }
 
@@ -598,6 +818,9 @@ func (dec *Decoder) Parse(cb func(object model.RedisObject) bool) (err error) {
 	if err != nil {
 		return err
 	}
+	if readerAt := dec.parallelReader(); readerAt != nil {
+		return dec.parseParallel(readerAt, cb)
+	}
 	return dec.parse(cb)
 }
 
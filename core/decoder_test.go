@@ -0,0 +1,67 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func encodeChecksumCase(t *testing.T) []byte {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteDBHeader(0, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteStringObject("greeting", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func parseBytes(dec *Decoder) error {
+	return dec.Parse(func(object model.RedisObject) bool {
+		return true
+	})
+}
+
+func TestChecksum(t *testing.T) {
+	data := encodeChecksumCase(t)
+	if err := parseBytes(NewDecoder(bytes.NewReader(data))); err != nil {
+		t.Errorf("valid rdb reports error: %v", err)
+	}
+
+	// flip a bit in value, it still can be decoded
+	corrupted := append([]byte(nil), data...)
+	index := bytes.Index(corrupted, []byte("hello"))
+	corrupted[index] ^= 0x01
+	err := parseBytes(NewDecoder(bytes.NewReader(corrupted)))
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Errorf("expect checksum error, actual: %v", err)
+	}
+	if err := parseBytes(NewDecoder(bytes.NewReader(corrupted)).WithoutChecksum()); err != nil {
+		t.Errorf("checksum should be skipped: %v", err)
+	}
+
+	// rdbchecksum no
+	disabled := append([]byte(nil), data...)
+	copy(disabled[len(disabled)-8:], make([]byte, 8))
+	disabled[index] ^= 0x01
+	if err := parseBytes(NewDecoder(bytes.NewReader(disabled))); err != nil {
+		t.Errorf("zero checksum should be skipped: %v", err)
+	}
+
+	// truncated checksum
+	truncated := data[:len(data)-4]
+	if err := parseBytes(NewDecoder(bytes.NewReader(truncated))); err == nil {
+		t.Error("expect error for truncated checksum")
+	}
+}
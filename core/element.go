@@ -0,0 +1,415 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hdt3213/rdb/memprofiler"
+	"github.com/hdt3213/rdb/model"
+)
+
+const (
+	// ObjectBeginEvent is emitted before elements of an object.
+	// Its Object has key/type/encoding/expiration but no elements.
+	ObjectBeginEvent = iota
+	// ObjectElementsEvent carries a batch of elements.
+	// Its Object is of the same type as the one in ObjectBeginEvent, and only holds elements in this batch.
+	ObjectElementsEvent
+	// ObjectEndEvent is emitted after all elements of an object.
+	// Its Object has no elements, but its size is final.
+	ObjectEndEvent
+)
+
+const defaultBatchSize = 1024
+
+// ElementEvent is a piece of redis object emitted by Decoder.ParseElements
+type ElementEvent struct {
+	Type   int               // Type is one of ObjectBeginEvent/ObjectElementsEvent/ObjectEndEvent
+	Object model.RedisObject // Object shares the same model.BaseObject in all events of a key
+	// ElemCount is the number of elements of the whole object, only valid in ObjectEndEvent
+	ElemCount int
+}
+
+// WithBatchSize sets the max number of elements in an ObjectElementsEvent
+func (dec *Decoder) WithBatchSize(batchSize int) *Decoder {
+	dec.batchSize = batchSize
+	return dec
+}
+
+func (dec *Decoder) getBatchSize() int {
+	if dec.batchSize <= 0 {
+		return defaultBatchSize
+	}
+	return dec.batchSize
+}
+
+// ParseElements parses rdb and callback element by element, so the memory usage is bounded no matter how big one key is.
+// Every key emits an ObjectBeginEvent, some ObjectElementsEvent and an ObjectEndEvent.
+// Objects enabled by WithSpecialOpCode or encoded in a compact way (ziplist, listpack, intset, etc.) are emitted
+// in one ObjectElementsEvent. In parallel mode (see WithParallel), all objects are emitted in one ObjectElementsEvent.
+// cb returns true to continue, returns false to stop the iteration
+func (dec *Decoder) ParseElements(cb func(event *ElementEvent) bool) (err error) {
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			err = fmt.Errorf("panic: %v", err2)
+		}
+	}()
+	err = dec.checkHeader()
+	if err != nil {
+		return err
+	}
+	dec.elementCb = cb
+	defer func() {
+		dec.elementCb = nil
+	}()
+	if readerAt := dec.parallelReader(); readerAt != nil {
+		// objects are decoded by workers entirely
+		return dec.parseParallel(readerAt, dec.emitWholeObject)
+	}
+	return dec.parse(dec.emitWholeObject)
+}
+
+// emitWholeObject emits an object which has been read entirely
+func (dec *Decoder) emitWholeObject(obj model.RedisObject) bool {
+	if !dec.elementCb(&ElementEvent{Type: ObjectBeginEvent, Object: obj}) {
+		return false
+	}
+	if !dec.elementCb(&ElementEvent{Type: ObjectElementsEvent, Object: obj}) {
+		return false
+	}
+	return dec.elementCb(&ElementEvent{Type: ObjectEndEvent, Object: obj, ElemCount: obj.GetElemCount()})
+}
+
+// elementEmitter sends events of a huge object and evaluates its size
+type elementEmitter struct {
+	dec   *Decoder
+	base  *model.BaseObject
+	sizer *memprofiler.ElementSizer
+	count int
+}
+
+func (dec *Decoder) beginElements(obj model.RedisObject, base *model.BaseObject, length int) (*elementEmitter, bool) {
+	base.Type = obj.GetType()
+	e := &elementEmitter{
+		dec:   dec,
+		base:  base,
+		sizer: memprofiler.NewElementSizer(obj, length),
+	}
+	return e, dec.elementCb(&ElementEvent{Type: ObjectBeginEvent, Object: obj})
+}
+
+func (e *elementEmitter) emit(batch model.RedisObject) bool {
+	e.sizer.Add(batch)
+	e.count += batch.GetElemCount()
+	return e.dec.elementCb(&ElementEvent{Type: ObjectElementsEvent, Object: batch})
+}
+
+func (e *elementEmitter) end(final model.RedisObject) bool {
+	e.base.Size = e.sizer.Size(final)
+	return e.dec.elementCb(&ElementEvent{Type: ObjectEndEvent, Object: final, ElemCount: e.count})
+}
+
+// readObjectElements reads an object and sends it by ElementEvent, returns false if callback stops the iteration
+func (dec *Decoder) readObjectElements(flag byte, base *model.BaseObject) (bool, error) {
+	base.Encoding = encodingMap[int(flag)]
+	switch flag {
+	case typeList:
+		return dec.readListElements(base)
+	case typeSet:
+		return dec.readSetElements(base)
+	case typeHash:
+		return dec.readHashElements(base)
+	case typeZset, typeZset2:
+		return dec.readZSetElements(base, flag == typeZset2)
+	case typeListQuickList:
+		return dec.readQuickListElements(base)
+	case typeListQuickList2:
+		return dec.readQuickList2Elements(base)
+	case typeStreamListPacks, typeStreamListPacks2, typeStreamListPacks3:
+		var version uint = 1
+		if flag == typeStreamListPacks2 {
+			version = 2
+		} else if flag == typeStreamListPacks3 {
+			version = 3
+		}
+		return dec.readStreamElements(base, version)
+	case typeHashWithHfe, typeHashWithHfeRc:
+		return dec.readHashExElements(base, flag == typeHashWithHfeRc)
+	}
+	obj, err := dec.readObject(flag, base)
+	if err != nil {
+		return false, err
+	}
+	base.Size = memprofiler.SizeOfObject(obj)
+	base.Type = obj.GetType()
+	return dec.emitWholeObject(obj), nil
+}
+
+func (dec *Decoder) readListElements(base *model.BaseObject) (bool, error) {
+	size, _, err := dec.readSize()
+	if err != nil {
+		return false, err
+	}
+	e, tbc := dec.beginElements(&model.ListObject{BaseObject: base}, base, int(size))
+	if !tbc {
+		return false, nil
+	}
+	batchSize := dec.getBatchSize()
+	values := make([][]byte, 0, batchSize)
+	for i := uint64(0); i < size; i++ {
+		val, err := dec.readString()
+		if err != nil {
+			return false, err
+		}
+		values = append(values, val)
+		if len(values) == batchSize || i == size-1 {
+			if !e.emit(&model.ListObject{BaseObject: base, Values: values}) {
+				return false, nil
+			}
+			values = make([][]byte, 0, batchSize)
+		}
+	}
+	return e.end(&model.ListObject{BaseObject: base}), nil
+}
+
+func (dec *Decoder) readSetElements(base *model.BaseObject) (bool, error) {
+	size, _, err := dec.readSize()
+	if err != nil {
+		return false, err
+	}
+	e, tbc := dec.beginElements(&model.SetObject{BaseObject: base}, base, int(size))
+	if !tbc {
+		return false, nil
+	}
+	batchSize := dec.getBatchSize()
+	members := make([][]byte, 0, batchSize)
+	for i := uint64(0); i < size; i++ {
+		val, err := dec.readString()
+		if err != nil {
+			return false, err
+		}
+		members = append(members, val)
+		if len(members) == batchSize || i == size-1 {
+			if !e.emit(&model.SetObject{BaseObject: base, Members: members}) {
+				return false, nil
+			}
+			members = make([][]byte, 0, batchSize)
+		}
+	}
+	return e.end(&model.SetObject{BaseObject: base}), nil
+}
+
+func (dec *Decoder) readHashElements(base *model.BaseObject) (bool, error) {
+	size, _, err := dec.readSize()
+	if err != nil {
+		return false, err
+	}
+	e, tbc := dec.beginElements(&model.HashObject{BaseObject: base}, base, int(size))
+	if !tbc {
+		return false, nil
+	}
+	batchSize := dec.getBatchSize()
+	m := make(map[string][]byte, batchSize)
+	for i := uint64(0); i < size; i++ {
+		field, err := dec.readString()
+		if err != nil {
+			return false, err
+		}
+		value, err := dec.readString()
+		if err != nil {
+			return false, err
+		}
+		m[unsafeBytes2Str(field)] = value
+		if len(m) == batchSize || i == size-1 {
+			if !e.emit(&model.HashObject{BaseObject: base, Hash: m}) {
+				return false, nil
+			}
+			m = make(map[string][]byte, batchSize)
+		}
+	}
+	return e.end(&model.HashObject{BaseObject: base}), nil
+}
+
+// readHashExElements reads hash with field-level expiration, see readHashMapEx and readHashMapExValkey
+func (dec *Decoder) readHashExElements(base *model.BaseObject, rc bool) (bool, error) {
+	minExpire, size, err := dec.readHashExHeader(!dec.valkey && !rc)
+	if err != nil {
+		return false, err
+	}
+	e, tbc := dec.beginElements(&model.HashObject{BaseObject: base}, base, int(size))
+	if !tbc {
+		return false, nil
+	}
+	batchSize := dec.getBatchSize()
+	m := make(map[string][]byte, batchSize)
+	expires := make(map[string]int64, batchSize)
+	for i := uint64(0); i < size; i++ {
+		var field, value []byte
+		var expire int64
+		if dec.valkey {
+			field, value, expire, err = dec.readHashExFieldValkey()
+		} else {
+			field, value, expire, err = dec.readHashExField(rc, minExpire)
+		}
+		if err != nil {
+			return false, err
+		}
+		m[unsafeBytes2Str(field)] = value
+		expires[unsafeBytes2Str(field)] = expire
+		if len(m) == batchSize || i == size-1 {
+			batch := &model.HashObject{BaseObject: base, Hash: m, FieldExpirations: expires}
+			if !e.emit(batch) {
+				return false, nil
+			}
+			m = make(map[string][]byte, batchSize)
+			expires = make(map[string]int64, batchSize)
+		}
+	}
+	return e.end(&model.HashObject{BaseObject: base}), nil
+}
+
+func (dec *Decoder) readZSetElements(base *model.BaseObject, zset2 bool) (bool, error) {
+	size, _, err := dec.readSize()
+	if err != nil {
+		return false, err
+	}
+	e, tbc := dec.beginElements(&model.ZSetObject{BaseObject: base}, base, int(size))
+	if !tbc {
+		return false, nil
+	}
+	batchSize := dec.getBatchSize()
+	entries := make([]*model.ZSetEntry, 0, batchSize)
+	for i := uint64(0); i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return false, err
+		}
+		var score float64
+		if zset2 {
+			score, err = dec.readFloat()
+		} else {
+			score, err = dec.readLiteralFloat()
+		}
+		if err != nil {
+			return false, err
+		}
+		entries = append(entries, &model.ZSetEntry{
+			Member: unsafeBytes2Str(member),
+			Score:  score,
+		})
+		if len(entries) == batchSize || i == size-1 {
+			if !e.emit(&model.ZSetObject{BaseObject: base, Entries: entries}) {
+				return false, nil
+			}
+			entries = make([]*model.ZSetEntry, 0, batchSize)
+		}
+	}
+	return e.end(&model.ZSetObject{BaseObject: base}), nil
+}
+
+// readQuickListElements emits a batch for each ziplist node
+func (dec *Decoder) readQuickListElements(base *model.BaseObject) (bool, error) {
+	size, _, err := dec.readSize()
+	if err != nil {
+		return false, err
+	}
+	e, tbc := dec.beginElements(&model.ListObject{BaseObject: base}, base, int(size))
+	if !tbc {
+		return false, nil
+	}
+	for i := uint64(0); i < size; i++ {
+		page, err := dec.readZipList()
+		if err != nil {
+			return false, err
+		}
+		batch := &model.ListObject{
+			BaseObject: base,
+			Values:     page,
+		}
+		if !e.emit(batch) {
+			return false, nil
+		}
+	}
+	return e.end(&model.ListObject{BaseObject: base}), nil
+}
+
+// readQuickList2Elements emits a batch for each listpack or plain node, batch.Extra is detail of this node
+func (dec *Decoder) readQuickList2Elements(base *model.BaseObject) (bool, error) {
+	size, _, err := dec.readSize()
+	if err != nil {
+		return false, err
+	}
+	e, tbc := dec.beginElements(&model.ListObject{BaseObject: base}, base, int(size))
+	if !tbc {
+		return false, nil
+	}
+	for i := uint64(0); i < size; i++ {
+		length, _, err := dec.readLength()
+		if err != nil {
+			return false, err
+		}
+		detail := &model.Quicklist2Detail{}
+		var page [][]byte
+		if length == model.QuicklistNodeContainerPlain {
+			entry, err := dec.readString()
+			if err != nil {
+				return false, err
+			}
+			page = [][]byte{entry}
+			detail.NodeEncodings = []int{model.QuicklistNodeContainerPlain}
+		} else if length == model.QuicklistNodeContainerPacked {
+			var lengths []uint32
+			page, lengths, err = dec.readListPack()
+			if err != nil {
+				return false, err
+			}
+			detail.NodeEncodings = []int{model.QuicklistNodeContainerPacked}
+			detail.ListPackEntrySize = [][]uint32{lengths}
+		} else {
+			return false, errors.New("unknown quicklist node type")
+		}
+		// batch shares BaseObject with other events, so node detail is kept in a copy
+		nodeBase := *base
+		nodeBase.Extra = detail
+		batch := &model.ListObject{
+			BaseObject: &nodeBase,
+			Values:     page,
+		}
+		if !e.emit(batch) {
+			return false, nil
+		}
+	}
+	return e.end(&model.ListObject{BaseObject: base}), nil
+}
+
+// readStreamElements emits a batch for each stream entry(node of radix tree), groups are in ObjectEndEvent
+func (dec *Decoder) readStreamElements(base *model.BaseObject, version uint) (bool, error) {
+	length, _, err := dec.readSize()
+	if err != nil {
+		return false, err
+	}
+	e, tbc := dec.beginElements(&model.StreamObject{BaseObject: base, Version: version}, base, int(length))
+	if !tbc {
+		return false, nil
+	}
+	for i := uint64(0); i < length; i++ {
+		entry, err := dec.readStreamEntry()
+		if err != nil {
+			return false, err
+		}
+		batch := &model.StreamObject{
+			BaseObject: base,
+			Version:    version,
+			Entries:    []*model.StreamEntry{entry},
+		}
+		if !e.emit(batch) {
+			return false, nil
+		}
+	}
+	final := &model.StreamObject{BaseObject: base}
+	err = dec.readStreamMeta(final, version)
+	if err != nil {
+		return false, err
+	}
+	return e.end(final), nil
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// mergeElements merges batches of an object into the begin object
+func mergeElements(merged model.RedisObject, batch model.RedisObject) {
+	switch o := merged.(type) {
+	case *model.ListObject:
+		o.Values = append(o.Values, batch.(*model.ListObject).Values...)
+	case *model.SetObject:
+		o.Members = append(o.Members, batch.(*model.SetObject).Members...)
+	case *model.ZSetObject:
+		o.Entries = append(o.Entries, batch.(*model.ZSetObject).Entries...)
+	case *model.StreamObject:
+		o.Entries = append(o.Entries, batch.(*model.StreamObject).Entries...)
+	case *model.HashObject:
+		b := batch.(*model.HashObject)
+		if o.Hash == nil {
+			o.Hash = make(map[string][]byte)
+		}
+		for k, v := range b.Hash {
+			o.Hash[k] = v
+		}
+		if b.FieldExpirations != nil && o.FieldExpirations == nil {
+			o.FieldExpirations = make(map[string]int64)
+		}
+		for k, v := range b.FieldExpirations {
+			o.FieldExpirations[k] = v
+		}
+	}
+}
+
+func TestParseElements(t *testing.T) {
+	files, err := filepath.Glob("../cases/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range files {
+		expect := make(map[string]model.RedisObject)
+		rdbFile, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewDecoder(rdbFile).Parse(func(object model.RedisObject) bool {
+			expect[object.GetKey()] = object
+			return true
+		})
+		_ = rdbFile.Close()
+		if err != nil {
+			t.Errorf("parse %s failed: %v", filename, err)
+			continue
+		}
+
+		rdbFile, err = os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		var merged model.RedisObject
+		var batches int
+		err = NewDecoder(rdbFile).WithBatchSize(3).ParseElements(func(event *ElementEvent) bool {
+			switch event.Type {
+			case ObjectBeginEvent:
+				if merged != nil {
+					t.Errorf("%s: begin %s before end of previous object", filename, event.Object.GetKey())
+				}
+				merged = event.Object
+				batches = 0
+			case ObjectElementsEvent:
+				batches++
+				if event.Object != merged {
+					mergeElements(merged, event.Object)
+				}
+			case ObjectEndEvent:
+				count++
+				if stream, ok := event.Object.(*model.StreamObject); ok && event.Object != merged {
+					stream.Entries = merged.(*model.StreamObject).Entries
+					merged = stream
+				}
+				origin := expect[merged.GetKey()]
+				if origin == nil {
+					t.Errorf("%s: unexpected key %s", filename, merged.GetKey())
+				} else {
+					if origin.GetSize() != event.Object.GetSize() {
+						t.Errorf("%s: %s expect size %d, actual %d", filename, origin.GetKey(), origin.GetSize(), event.Object.GetSize())
+					}
+					if origin.GetElemCount() != event.ElemCount {
+						t.Errorf("%s: %s expect elem count %d, actual %d", filename, origin.GetKey(), origin.GetElemCount(), event.ElemCount)
+					}
+					expectJSON, _ := json.Marshal(origin)
+					actualJSON, _ := json.Marshal(merged)
+					if string(expectJSON) != string(actualJSON) {
+						t.Errorf("%s: %s expect %s, actual %s", filename, origin.GetKey(), expectJSON, actualJSON)
+					}
+				}
+				merged = nil
+			}
+			return true
+		})
+		_ = rdbFile.Close()
+		if err != nil {
+			t.Errorf("parse elements of %s failed: %v", filename, err)
+			continue
+		}
+		if count != len(expect) {
+			t.Errorf("%s: expect %d objects, actual %d", filename, len(expect), count)
+		}
+	}
+}
+
+func TestParseElementsStop(t *testing.T) {
+	rdbFile, err := os.Open("../cases/linkedlist.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	batches := 0
+	err = NewDecoder(rdbFile).WithBatchSize(10).ParseElements(func(event *ElementEvent) bool {
+		if event.Type == ObjectElementsEvent {
+			batches++
+			return batches < 2
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if batches != 2 {
+		t.Errorf("expect stop after 2 batches, actual %d", batches)
+	}
+}
@@ -2,11 +2,13 @@ package core
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 
 	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/hdt3213/rdb/model"
 )
 
 // Encoder is used to generate RDB file
@@ -23,7 +25,16 @@ type Encoder struct {
 	zsetZipListOpt  *zipListOpt
 	listZipListSize int
 
-	valkey bool
+	hashListPackOpt *listPackOpt
+	zsetListPackOpt *listPackOpt
+	setListPackOpt  *listPackOpt
+	streamNodeOpt   *streamNodeOpt
+
+	valkey  bool
+	version int // version is rdb version set by NewEncoderWithVersion, 0 means no limits of version
+
+	db     uint                // db is index of the latest db header
+	dbSize *model.DBSizeObject // dbSize is passed to WriteObject, it is written with the next db header
 }
 
 type zipListOpt struct {
@@ -50,13 +61,44 @@ func (zop *zipListOpt) getMaxEntries() int {
 	return zop.maxEntries
 }
 
+// listPackOpt is like zipListOpt, but has different default thresholds like hash-max-listpack-entries
+type listPackOpt struct {
+	maxValue   int // if any value is larger than maxValue, abort listpack encoding
+	maxEntries int // if number of entries is larger than maxEntries, abort listpack encoding
+}
+
+const (
+	defaultListPackMaxValue   = 64
+	defaultListPackMaxEntries = 128
+)
+
+func (lop *listPackOpt) getMaxValue() int {
+	if lop == nil || lop.maxValue == 0 {
+		return defaultListPackMaxValue
+	}
+	return lop.maxValue
+}
+
+func (lop *listPackOpt) getMaxEntries() int {
+	if lop == nil || lop.maxEntries == 0 {
+		return defaultListPackMaxEntries
+	}
+	return lop.maxEntries
+}
+
 const (
 	startState           = "Start"
 	writtenHeaderState   = "WrittenHeader"
 	writtenDBHeaderState = "writtenHeader"
 	writtenAuxState      = "WrittenAux"
+	writtenFunctionState = "WrittenFunction"
 	writtenTTLState      = "WrittenTTL"
+	writtenEvictionState = "WrittenEviction"
+	writtenKeyMetaState  = "WrittenKeyMeta"
+	writtenSlotInfoState = "WrittenSlotInfo"
 	writtenObjectState   = "WrittenObject"
+	writingObjectState   = "WritingObject"
+	writtenAfterRDBState = "WrittenAfterRDB"
 	writtenEndState      = "WritingEnd"
 )
 
@@ -68,25 +110,63 @@ var stateChanges = map[string]map[string]struct{}{ // state -> allow next states
 	},
 	writtenHeaderState: {
 		writtenAuxState:      placeholder,
+		writtenFunctionState: placeholder,
 		writtenDBHeaderState: placeholder,
+		writtenAfterRDBState: placeholder,
 		writtenEndState:      placeholder,
 	},
 	writtenAuxState: {
 		writtenAuxState:      placeholder,
+		writtenFunctionState: placeholder,
 		writtenDBHeaderState: placeholder,
+		writtenAfterRDBState: placeholder,
+		writtenEndState:      placeholder,
+	},
+	writtenFunctionState: { // functions are written after aux fields and before keys
+		writtenFunctionState: placeholder,
+		writtenDBHeaderState: placeholder,
+		writtenAfterRDBState: placeholder,
 		writtenEndState:      placeholder,
 	},
 	writtenDBHeaderState: { // do not allow empty db
-		writtenTTLState:    placeholder,
-		writtenObjectState: placeholder,
+		writtenTTLState:      placeholder,
+		writtenEvictionState: placeholder,
+		writtenKeyMetaState:  placeholder,
+		writtenObjectState:   placeholder,
+		writtenSlotInfoState: placeholder,
+	},
+	writtenSlotInfoState: { // slot info is followed by keys in the slot
+		writtenTTLState:      placeholder,
+		writtenEvictionState: placeholder,
+		writtenKeyMetaState:  placeholder,
+		writtenObjectState:   placeholder,
+		writtenSlotInfoState: placeholder,
 	},
 	writtenTTLState: {
+		writtenEvictionState: placeholder,
+		writtenKeyMetaState:  placeholder,
+		writtenObjectState:   placeholder,
+	},
+	writtenEvictionState: {
+		writtenKeyMetaState: placeholder,
+		writtenObjectState:  placeholder,
+	},
+	writtenKeyMetaState: {
 		writtenObjectState: placeholder,
 	},
 	writtenObjectState: {
 		writtenTTLState:      placeholder,
+		writtenEvictionState: placeholder,
+		writtenKeyMetaState:  placeholder,
 		writtenObjectState:   placeholder,
+		writtenSlotInfoState: placeholder,
 		writtenDBHeaderState: placeholder, // start another db
+		writtenAfterRDBState: placeholder,
+		writtenEndState:      placeholder,
+	},
+	writingObjectState: {}, // elements of a collection are being written by builder, see BeginList
+	writtenAfterRDBState: { // module aux data saved after keys
+		writtenAfterRDBState: placeholder,
 		writtenEndState:      placeholder,
 	},
 	writtenEndState: {},
@@ -111,6 +191,42 @@ func NewEncoderValkey(writer io.Writer) *Encoder {
 	return enc
 }
 
+const (
+	// MinEncoderVersion is the oldest rdb version Encoder can write, which is used by redis 3.2
+	MinEncoderVersion = 7
+	// MaxRedisEncoderVersion is the latest redis rdb version Encoder can write, which is used by redis 7.4
+	MaxRedisEncoderVersion = 12
+	// ValkeyEncoderVersion is the valkey rdb version Encoder can write, which is used by valkey 9
+	ValkeyEncoderVersion = 80
+)
+
+// NewEncoderWithVersion creates an encoder which writes rdb file of given version, it only uses encodings
+// valid for the version, and returns an error when writing an object cannot be represented in the version.
+// Supported versions are MinEncoderVersion to MaxRedisEncoderVersion, and ValkeyEncoderVersion.
+// Streams of later formats are downgraded only with WithStreamDowngrade, see WriteStreamObject.
+func NewEncoderWithVersion(writer io.Writer, version int) (*Encoder, error) {
+	if version != ValkeyEncoderVersion && (version < MinEncoderVersion || version > MaxRedisEncoderVersion) {
+		return nil, fmt.Errorf("unsupported rdb version: %d", version)
+	}
+	enc := NewEncoder(writer)
+	enc.version = version
+	enc.valkey = version == ValkeyEncoderVersion
+	return enc, nil
+}
+
+// atLeastVersion returns whether enc can write features of the rdb version
+func (enc *Encoder) atLeastVersion(version int) bool {
+	return enc.version == 0 || enc.version >= version
+}
+
+// requireVersion returns error if version of enc is older than minVersion, feature is used in error message
+func (enc *Encoder) requireVersion(minVersion int, feature string) error {
+	if enc.atLeastVersion(minVersion) {
+		return nil
+	}
+	return fmt.Errorf("%s requires rdb version %d or later, but target version is %d", feature, minVersion, enc.version)
+}
+
 // SetListZipListOpt sets list-max-ziplist-value and list-max-ziplist-entries
 func (enc *Encoder) SetListZipListOpt(maxValue, maxEntries int) *Encoder {
 	enc.listZipListOpt = &zipListOpt{
@@ -138,7 +254,46 @@ func (enc *Encoder) SetZSetZipListOpt(maxValue, maxEntries int) *Encoder {
 	return enc
 }
 
-// remain unfixed bugs, don't open
+// SetHashListPackOpt sets hash-max-listpack-value and hash-max-listpack-entries
+func (enc *Encoder) SetHashListPackOpt(maxValue, maxEntries int) *Encoder {
+	enc.hashListPackOpt = &listPackOpt{
+		maxValue:   maxValue,
+		maxEntries: maxEntries,
+	}
+	return enc
+}
+
+// SetZSetListPackOpt sets zset-max-listpack-value and zset-max-listpack-entries
+func (enc *Encoder) SetZSetListPackOpt(maxValue, maxEntries int) *Encoder {
+	enc.zsetListPackOpt = &listPackOpt{
+		maxValue:   maxValue,
+		maxEntries: maxEntries,
+	}
+	return enc
+}
+
+// SetSetListPackOpt sets set-max-listpack-value and set-max-listpack-entries
+func (enc *Encoder) SetSetListPackOpt(maxValue, maxEntries int) *Encoder {
+	enc.setListPackOpt = &listPackOpt{
+		maxValue:   maxValue,
+		maxEntries: maxEntries,
+	}
+	return enc
+}
+
+// SetStreamNodeOpt sets stream-node-max-bytes and stream-node-max-entries (4096 and 100 by default in redis),
+// then messages of streams are repacked into nodes within the limits like XADD does. 0 of maxBytes means nodes are
+// limited to 1GB, and 0 of maxEntries means no limit. Nodes in StreamObject.Entries are kept by default.
+func (enc *Encoder) SetStreamNodeOpt(maxBytes, maxEntries int) *Encoder {
+	enc.streamNodeOpt = &streamNodeOpt{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+	}
+	return enc
+}
+
+// EnableCompress makes encoder compress strings and ziplist, listpack or intset blobs longer than 20 bytes with LZF
+// like redis-server does with rdbcompression on. Values are written uncompressed if compression does not shrink them.
 func (enc *Encoder) EnableCompress() *Encoder {
 	enc.compress = true
 	return enc
@@ -172,6 +327,9 @@ func (enc *Encoder) WriteHeader() error {
 	if enc.valkey {
 		rdbHeader = rdbHeaderValkey
 	}
+	if enc.version > 0 && !enc.valkey {
+		rdbHeader = []byte(fmt.Sprintf("REDIS%04d", enc.version))
+	}
 	err := enc.write(rdbHeader)
 	if err != nil {
 		return err
@@ -201,20 +359,135 @@ func (enc *Encoder) WriteAux(key, value string) error {
 	return nil
 }
 
-// WriteDBHeader write db index and resize db into rdb file
-func (enc *Encoder) WriteDBHeader(dbIndex uint, keyCount, ttlCount uint64) error {
-	if !enc.validateStateChange(writtenDBHeaderState) {
-		return fmt.Errorf("cannot writing db header at state: %s", enc.state)
+// WriteFunctions writes source code of a function library, like `#!lua name=mylib ...`.
+// Call it once for each library, after aux fields and before keys. Functions require rdb version 10.
+func (enc *Encoder) WriteFunctions(lua string) error {
+	if !enc.validateStateChange(writtenFunctionState) {
+		return fmt.Errorf("cannot writing functions at state: %s", enc.state)
 	}
-	if _, ok := enc.existDB[dbIndex]; ok {
-		return fmt.Errorf("db %d existed", dbIndex)
+	err := enc.requireVersion(10, "functions")
+	if err != nil {
+		return err
 	}
-	enc.existDB[dbIndex] = struct{}{}
-	err := enc.write([]byte{opCodeSelectDB})
+	err = enc.write([]byte{opCodeFunction})
 	if err != nil {
 		return err
 	}
-	err = enc.writeLength(uint64(dbIndex))
+	err = enc.writeString(lua)
+	if err != nil {
+		return err
+	}
+	enc.state = writtenFunctionState
+	return nil
+}
+
+// WriteModuleAux writes global data of a module like aux_save callback of the module, moduleType is name of the
+// module type in 9 characters, encVersion is encoding version of the module data. when is model.ModuleAuxBeforeRDB
+// or model.ModuleAuxAfterRDB, data of ModuleAuxBeforeRDB is written before functions and keys, and
+// data of ModuleAuxAfterRDB is written after keys. save writes the data, the end mark is written by WriteModuleAux.
+func (enc *Encoder) WriteModuleAux(moduleType string, encVersion int, when int,
+	save func(w ModuleTypeWriter) error) error {
+	toState := writtenAuxState
+	if when == model.ModuleAuxAfterRDB {
+		toState = writtenAfterRDBState
+	} else if when != model.ModuleAuxBeforeRDB {
+		return fmt.Errorf("illegal when of module aux: %d", when)
+	}
+	if !enc.validateStateChange(toState) {
+		return fmt.Errorf("cannot writing module aux at state: %s", enc.state)
+	}
+	err := enc.requireVersion(9, "module aux")
+	if err != nil {
+		return err
+	}
+	moduleId, err := moduleTypeIDByName(moduleType, encVersion)
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{opCodeModuleAux})
+	if err != nil {
+		return err
+	}
+	w := moduleTypeWriterImpl{enc: enc}
+	err = enc.writeLength(moduleId)
+	if err != nil {
+		return err
+	}
+	err = w.WriteUInt(uint64(when))
+	if err != nil {
+		return err
+	}
+	err = save(w)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(ModuleOpcodeEOF))
+	if err != nil {
+		return err
+	}
+	enc.state = toState
+	return nil
+}
+
+// WriteSlotImport writes an in-flight slot import job of valkey cluster, it is global metadata written like aux fields
+func (enc *Encoder) WriteSlotImport(jobName string, ranges []model.SlotRange) error {
+	if !enc.validateStateChange(writtenAuxState) {
+		return fmt.Errorf("cannot writing slot import at state: %s", enc.state)
+	}
+	if !enc.valkey {
+		return errors.New("slot import is only supported by valkey rdb")
+	}
+	err := enc.write([]byte{opCodeSlotImport})
+	if err != nil {
+		return err
+	}
+	err = enc.writeString(jobName)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(ranges)))
+	if err != nil {
+		return err
+	}
+	for _, rng := range ranges {
+		err = enc.writeLength(uint64(rng.From))
+		if err != nil {
+			return err
+		}
+		err = enc.writeLength(uint64(rng.To))
+		if err != nil {
+			return err
+		}
+	}
+	enc.state = writtenAuxState
+	return nil
+}
+
+// WriteSlotInfo writes number of keys in a slot of valkey cluster, it should be followed by keys in the slot
+func (enc *Encoder) WriteSlotInfo(slot int, keyCount, expiresCount uint64) error {
+	if !enc.validateStateChange(writtenSlotInfoState) {
+		return fmt.Errorf("cannot writing slot info at state: %s", enc.state)
+	}
+	if !enc.valkey {
+		return errors.New("slot info is only supported by valkey rdb")
+	}
+	err := enc.write([]byte{opCodeSlotInfo})
+	if err != nil {
+		return err
+	}
+	for _, v := range []uint64{uint64(slot), keyCount, expiresCount} {
+		err = enc.writeLength(v)
+		if err != nil {
+			return err
+		}
+	}
+	enc.state = writtenSlotInfoState
+	return nil
+}
+
+// WriteDBHeader write db index and resize db into rdb file
+func (enc *Encoder) WriteDBHeader(dbIndex uint, keyCount, ttlCount uint64) error {
+	err := enc.writeSelectDB(dbIndex)
 	if err != nil {
 		return err
 	}
@@ -234,6 +507,28 @@ func (enc *Encoder) WriteDBHeader(dbIndex uint, keyCount, ttlCount uint64) error
 	return nil
 }
 
+// writeSelectDB writes db index without resize db, which is written by redis before rdb version 7
+func (enc *Encoder) writeSelectDB(dbIndex uint) error {
+	if !enc.validateStateChange(writtenDBHeaderState) {
+		return fmt.Errorf("cannot writing db header at state: %s", enc.state)
+	}
+	if _, ok := enc.existDB[dbIndex]; ok {
+		return fmt.Errorf("db %d existed", dbIndex)
+	}
+	enc.existDB[dbIndex] = struct{}{}
+	enc.db = dbIndex
+	err := enc.write([]byte{opCodeSelectDB})
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(dbIndex))
+	if err != nil {
+		return err
+	}
+	enc.state = writtenDBHeaderState
+	return nil
+}
+
 // WriteEnd writes EOF and crc sum
 func (enc *Encoder) WriteEnd() error {
 	if !enc.validateStateChange(writtenEndState) {
@@ -277,18 +572,191 @@ func WithTTL(expirationMs uint64) TTLOption {
 	return TTLOption(expirationMs)
 }
 
+// IdleTimeOption specific LRU idle time in seconds for object
+type IdleTimeOption uint64
+
+// WithIdleTime specific LRU idle time in seconds for object, it is used when maxmemory-policy is LRU
+func WithIdleTime(idleSeconds uint64) IdleTimeOption {
+	return IdleTimeOption(idleSeconds)
+}
+
+// FreqOption specific LFU frequency for object
+type FreqOption uint8
+
+// WithFreq specific LFU frequency for object, it is used when maxmemory-policy is LFU
+func WithFreq(freq uint8) FreqOption {
+	return FreqOption(freq)
+}
+
+// KeyMetaOption specific metadata of object
+type KeyMetaOption []*model.KeyMeta
+
+// WithKeyMeta specific metadata of object, which is saved by RDB_OPCODE_KEY_META since redis 8.
+// Values of metadata should be ModuleValues, like metadata read by Decoder with WithModuleValues
+func WithKeyMeta(metas ...*model.KeyMeta) KeyMetaOption {
+	return metas
+}
+
+// EncodingOption hints encoding of object
+type EncodingOption string
+
+// WithEncoding hints encoding of object, like model.ZipListEncoding or model.QuickListEncoding.
+// It helps to keep encodings of objects read by Decoder. If it is a compact encoding (ziplist, listpack, intset
+// or zipmap), Encoder writes a compact encoding regardless of SetXXXZipListOpt and SetXXXListPackOpt if possible,
+// otherwise Encoder does not write compact encodings.
+func WithEncoding(encoding string) EncodingOption {
+	return EncodingOption(encoding)
+}
+
+// encodingHint returns whether a compact encoding is hinted by EncodingOption, hinted is false if there is no hint
+func encodingHint(options ...interface{}) (compact bool, hinted bool) {
+	for _, opt := range options {
+		if o, ok := opt.(EncodingOption); ok {
+			switch string(o) {
+			case model.ZipListEncoding, model.ListPackEncoding, model.IntSetEncoding, model.ZipMapEncoding,
+				model.ListPackExEncoding:
+				return true, true
+			}
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// hintedEncoding returns encoding hinted by EncodingOption, or empty string if there is no hint
+func hintedEncoding(options ...interface{}) string {
+	for _, opt := range options {
+		if o, ok := opt.(EncodingOption); ok {
+			return string(o)
+		}
+	}
+	return ""
+}
+
+// preferListPack returns whether small hashes and zsets are written in listpack rather than ziplist.
+// Listpack is used since rdb version 10 (redis 7.0) unless ziplist or zipmap is hinted.
+func (enc *Encoder) preferListPack(options ...interface{}) bool {
+	if !enc.atLeastVersion(10) {
+		return false
+	}
+	hint := hintedEncoding(options...)
+	return hint != model.ZipListEncoding && hint != model.ZipMapEncoding
+}
+
+// writeEviction writes opCodeIdle or opCodeFreq, redis saves only one of them depending on maxmemory-policy
+func (enc *Encoder) writeEviction(opCode byte, value uint64) error {
+	if !enc.validateStateChange(writtenEvictionState) {
+		return fmt.Errorf("cannot write idle time or frequency at state: %s", enc.state)
+	}
+	err := enc.requireVersion(9, "LRU/LFU info")
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{opCode})
+	if err != nil {
+		return err
+	}
+	if opCode == opCodeFreq {
+		err = enc.write([]byte{byte(value)})
+	} else {
+		err = enc.writeLength(value)
+	}
+	if err != nil {
+		return err
+	}
+	enc.state = writtenEvictionState
+	return nil
+}
+
 func (enc *Encoder) beforeWriteObject(options ...interface{}) error {
 	if !enc.validateStateChange(writtenObjectState) {
 		return fmt.Errorf("cannot write object at state: %s", enc.state)
 	}
+	var ttl *TTLOption
+	var idle *IdleTimeOption
+	var freq *FreqOption
+	var metas KeyMetaOption
 	for _, opt := range options {
 		switch o := opt.(type) {
 		case TTLOption:
-			err := enc.writeTTL(uint64(o))
-			if err != nil {
-				return err
-			}
+			ttl = &o
+		case IdleTimeOption:
+			idle = &o
+		case FreqOption:
+			freq = &o
+		case KeyMetaOption:
+			metas = o
+		}
+	}
+	// write in the same order as redis: expiration, idle time or frequency, metadata
+	if ttl != nil {
+		err := enc.writeTTL(uint64(*ttl))
+		if err != nil {
+			return err
+		}
+	}
+	if idle != nil {
+		err := enc.writeEviction(opCodeIdle, uint64(*idle))
+		if err != nil {
+			return err
+		}
+	} else if freq != nil {
+		err := enc.writeEviction(opCodeFreq, uint64(*freq))
+		if err != nil {
+			return err
+		}
+	}
+	if len(metas) > 0 {
+		err := enc.writeKeyMeta(metas)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeKeyMeta writes opCodeKeyMeta, each metadata is a class id followed by its value in module serialization format
+func (enc *Encoder) writeKeyMeta(metas []*model.KeyMeta) error {
+	if !enc.validateStateChange(writtenKeyMetaState) {
+		return fmt.Errorf("cannot write key metadata at state: %s", enc.state)
+	}
+	if enc.valkey {
+		return errors.New("key metadata is not supported by valkey rdb")
+	}
+	err := enc.requireVersion(12, "key metadata")
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{opCodeKeyMeta})
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(metas)))
+	if err != nil {
+		return err
+	}
+	for _, meta := range metas {
+		values, ok := meta.Value.(ModuleValues)
+		if !ok {
+			return fmt.Errorf("cannot write value of key metadata %s: %T", meta.Class, meta.Value)
+		}
+		classId, err := moduleTypeIDByName(meta.Class, meta.EncVersion)
+		if err != nil {
+			return err
+		}
+		err = enc.writeLength(classId)
+		if err != nil {
+			return err
+		}
+		err = values.Save(moduleTypeWriterImpl{enc: enc})
+		if err != nil {
+			return err
+		}
+		err = enc.writeLength(uint64(ModuleOpcodeEOF))
+		if err != nil {
+			return err
 		}
 	}
+	enc.state = writtenKeyMetaState
 	return nil
 }
@@ -2,8 +2,13 @@ package core
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/hdt3213/rdb/model"
+	"math"
 	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -114,3 +119,465 @@ func TestEncode(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestEncodingHint(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	bigHash := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		bigHash[RandString(10)] = []byte(RandString(100))
+	}
+	steps := []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteDBHeader(0, 5, 0) },
+		func() error {
+			return enc.WriteListObject("list", [][]byte{[]byte("a")}, WithEncoding(model.QuickListEncoding))
+		},
+		func() error { return enc.WriteHashMapObject("hash", bigHash, WithEncoding(model.ListPackEncoding)) },
+		func() error { return enc.WriteSetObject("set", [][]byte{[]byte("1")}, WithEncoding(model.SetEncoding)) },
+		func() error {
+			return enc.WriteZSetObject("zset", []*model.ZSetEntry{{Member: "a", Score: 1}}, WithEncoding(model.ZSet2Encoding))
+		},
+		func() error { return enc.WriteHashMapObject("small", map[string][]byte{"a": []byte("1")}) },
+		enc.WriteEnd,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := map[string]string{
+		"list":  model.QuickListEncoding,
+		"hash":  model.ListPackEncoding,
+		"set":   model.SetEncoding,
+		"zset":  model.ZSet2Encoding,
+		"small": model.ListPackEncoding,
+	}
+	err := NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+		if expect[object.GetKey()] != object.GetEncoding() {
+			t.Errorf("%s: expect encoding %s, actual %s", object.GetKey(), expect[object.GetKey()], object.GetEncoding())
+		}
+		if object.GetKey() == "hash" && object.GetElemCount() != len(bigHash) {
+			t.Errorf("hash: expect %d fields, actual %d", len(bigHash), object.GetElemCount())
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncoderWithVersion(t *testing.T) {
+	for _, version := range []int{6, 13, 79} {
+		if _, err := NewEncoderWithVersion(bytes.NewBuffer(nil), version); err == nil {
+			t.Errorf("expect error for unsupported version %d", version)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	enc, err := NewEncoderWithVersion(buf, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []*model.ZSetEntry{
+		{Member: "a", Score: 0.1},
+		{Member: "b", Score: math.Inf(1)},
+		{Member: "c", Score: math.Inf(-1)},
+		{Member: "d", Score: -1e100},
+	}
+	steps := []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteDBHeader(0, 1, 0) },
+		func() error { return enc.WriteZSetObject("zset", entries, WithEncoding(model.ZSet2Encoding)) },
+	}
+	for _, step := range steps {
+		if err = step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = enc.WriteStringObject("lru", []byte("1"), WithIdleTime(1)); err == nil {
+		t.Error("expect error for LRU info in rdb version 7")
+	}
+	if err = enc.WriteStreamObject("stream", &model.StreamObject{}); err == nil {
+		t.Error("expect error for stream in rdb version 7")
+	}
+	if err = enc.WriteHashMapObjectEx("hash", map[string][]byte{"a": []byte("1")}, map[string]int64{"a": 1}); err == nil {
+		t.Error("expect error for hash field expiration in rdb version 7")
+	}
+	if err = enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("REDIS0007")) {
+		t.Errorf("wrong header: %q", buf.Bytes()[:9])
+	}
+	err = NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+		zset, ok := object.(*model.ZSetObject)
+		if !ok || zset.Encoding != model.ZSetEncoding {
+			t.Errorf("expect zset encoding, actual %s", object.GetEncoding())
+			return true
+		}
+		for i, entry := range zset.Entries {
+			if entry.Member != entries[i].Member || entry.Score != entries[i].Score {
+				t.Errorf("expect %s %v, actual %s %v", entries[i].Member, entries[i].Score, entry.Member, entry.Score)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// LRU/LFU info is introduced in rdb version 9
+	enc, err = NewEncoderWithVersion(bytes.NewBuffer(nil), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = enc.WriteHeader(); err == nil {
+		err = enc.WriteDBHeader(0, 2, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = enc.WriteStringObject("lru", []byte("1"), WithIdleTime(1)); err == nil {
+		t.Error("expect error for LRU info in rdb version 8")
+	}
+	if err = enc.WriteStringObject("lfu", []byte("1"), WithFreq(1)); err == nil {
+		t.Error("expect error for LFU info in rdb version 8")
+	}
+
+	buf = bytes.NewBuffer(nil)
+	enc, err = NewEncoderWithVersion(buf, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := &model.StreamObject{
+		Version:           3,
+		Length:            0,
+		LastId:            &model.StreamId{Ms: 1, Sequence: 1},
+		FirstId:           &model.StreamId{Ms: 1, Sequence: 1},
+		MaxDeletedId:      &model.StreamId{},
+		AddedEntriesCount: 1,
+		Groups: []*model.StreamGroup{
+			{Name: "group", LastId: &model.StreamId{Ms: 1, Sequence: 1}, EntriesRead: 1},
+		},
+	}
+	if err = enc.WriteStreamObject("stream", stream); err == nil {
+		t.Error("expect error for stream version 3 in rdb version 9")
+	}
+	steps = []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteDBHeader(0, 1, 0) },
+		func() error { return enc.WriteStreamObject("stream", stream, WithFreq(3), WithStreamDowngrade()) },
+		enc.WriteEnd,
+	}
+	for _, step := range steps {
+		if err = step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+		decoded, ok := object.(*model.StreamObject)
+		if !ok || decoded.Version != 1 || len(decoded.Groups) != 1 || decoded.Groups[0].Name != "group" {
+			t.Errorf("stream is not downgraded to version 1: %+v", object)
+		}
+		if object.(model.EvictionInfo).GetFreq() != 3 {
+			t.Errorf("expect freq 3, actual %d", object.(model.EvictionInfo).GetFreq())
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	buf = bytes.NewBuffer(nil)
+	enc, err = NewEncoderWithVersion(buf, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps = []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteDBHeader(0, 1, 0) },
+		func() error {
+			return enc.WriteHashMapObjectEx("hash", map[string][]byte{"a": []byte("1")}, map[string]int64{"a": 1e12})
+		},
+		enc.WriteEnd,
+	}
+	for _, step := range steps {
+		if err = step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("REDIS0012")) {
+		t.Errorf("wrong header: %q", buf.Bytes()[:9])
+	}
+}
+
+func TestEncodeSlotInfo(t *testing.T) {
+	enc := NewEncoder(bytes.NewBuffer(nil))
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteSlotImport("job", []model.SlotRange{{From: 0, To: 100}}); err == nil {
+		t.Error("expect error for slot import in redis rdb")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	enc, err := NewEncoderWithVersion(buf, ValkeyEncoderVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := []model.SlotRange{{From: 0, To: 100}, {From: 200, To: 300}}
+	steps := []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteSlotImport("job", ranges) },
+		func() error { return enc.WriteDBHeader(0, 2, 0) },
+		func() error { return enc.WriteSlotInfo(15495, 1, 0) },
+		func() error { return enc.WriteStringObject("a", []byte("1")) },
+		func() error { return enc.WriteSlotInfo(3168, 1, 0) },
+		func() error { return enc.WriteStringObject("b", []byte("1")) },
+		enc.WriteEnd,
+	}
+	for _, step := range steps {
+		if err = step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var slots []int
+	err = NewDecoder(buf).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.SlotImportObject:
+			if o.JobName != "job" || len(o.Ranges) != 2 || o.Ranges[1] != ranges[1] {
+				t.Errorf("wrong slot import: %+v", o)
+			}
+		case *model.SlotInfoObject:
+			slots = append(slots, o.Slot)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 || slots[0] != 15495 || slots[1] != 3168 {
+		t.Errorf("wrong slot info: %v", slots)
+	}
+}
+
+func TestEncodeCompressed(t *testing.T) {
+	var list, set [][]byte
+	hash := make(map[string][]byte)
+	var entries []*model.ZSetEntry
+	for i := 0; i < 100; i++ {
+		list = append(list, []byte(fmt.Sprintf("item:%d", i%10)))
+		set = append(set, []byte(strconv.Itoa(i)))
+		hash[fmt.Sprintf("field:%d", i)] = []byte("value")
+		entries = append(entries, &model.ZSetEntry{Member: fmt.Sprintf("member:%d", i), Score: float64(i)})
+	}
+	write := func(enc *Encoder) error {
+		steps := []func() error{
+			enc.WriteHeader,
+			func() error { return enc.WriteDBHeader(0, 6, 0) },
+			func() error { return enc.WriteStringObject("string", bytes.Repeat([]byte("abc"), 100)) },
+			func() error { return enc.WriteListObject("ziplist", list) },
+			func() error { return enc.WriteListObject("quicklist", append(list, bytes.Repeat([]byte("a"), 100))) },
+			func() error { return enc.WriteSetObject("intset", set) },
+			func() error { return enc.WriteHashMapObject("hash", hash) },
+			func() error { return enc.WriteZSetObject("zset", entries) },
+			enc.WriteEnd,
+		}
+		for _, step := range steps {
+			if err := step(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	raw := bytes.NewBuffer(nil)
+	if err := write(NewEncoder(raw)); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := write(NewEncoder(buf).EnableCompress()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len()*2 > raw.Len() {
+		t.Errorf("compressed rdb is %d bytes, uncompressed is %d bytes", buf.Len(), raw.Len())
+	}
+	count := 0
+	err := NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+		count++
+		switch o := object.(type) {
+		case *model.StringObject:
+			if !bytes.Equal(o.Value, bytes.Repeat([]byte("abc"), 100)) {
+				t.Error("wrong value of string")
+			}
+		case *model.ListObject:
+			expect := list
+			if o.Key == "quicklist" {
+				expect = append(list, bytes.Repeat([]byte("a"), 100))
+			}
+			if !reflect.DeepEqual(o.Values, expect) {
+				t.Errorf("wrong values of %s", o.Key)
+			}
+		case *model.SetObject:
+			if o.Encoding != model.IntSetEncoding || !reflect.DeepEqual(o.Members, set) {
+				t.Errorf("wrong members of %s", o.Key)
+			}
+		case *model.HashObject:
+			if o.Encoding != model.ListPackEncoding || !reflect.DeepEqual(o.Hash, hash) {
+				t.Errorf("wrong fields of %s", o.Key)
+			}
+		case *model.ZSetObject:
+			if o.Encoding != model.ListPackEncoding || !reflect.DeepEqual(o.Entries, entries) {
+				t.Errorf("wrong entries of %s", o.Key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Errorf("expect 6 keys, actual %d", count)
+	}
+}
+
+func TestEncodeGlobalMetadata(t *testing.T) {
+	data, err := os.ReadFile("../cases/function.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var functions []*model.FunctionsObject
+	err = NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+		if o, ok := object.(*model.FunctionsObject); ok {
+			functions = append(functions, o)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(functions) == 0 {
+		t.Fatal("no functions in function.rdb")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	steps := []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteAux("redis-ver", "7.2.5") },
+		func() error {
+			return enc.WriteModuleAux("testtype1", 3, model.ModuleAuxBeforeRDB, func(w ModuleTypeWriter) error {
+				for _, err := range []error{w.WriteUInt(1), w.WriteSInt(-2), w.WriteFloat32(0.5), w.WriteDouble(-1.5)} {
+					if err != nil {
+						return err
+					}
+				}
+				return w.WriteString([]byte("before"))
+			})
+		},
+	}
+	for _, f := range functions {
+		lua := f.FunctionsLua
+		steps = append(steps, func() error { return enc.WriteFunctions(lua) })
+	}
+	steps = append(steps,
+		func() error { return enc.WriteDBHeader(0, 2, 0) },
+		func() error { return enc.WriteStringObject("lru", []byte("1"), WithIdleTime(100)) },
+		func() error { return enc.WriteStringObject("lfu", []byte("1"), WithFreq(5), WithTTL(4102444800000)) },
+		func() error {
+			return enc.WriteModuleAux("testtype1", 3, model.ModuleAuxAfterRDB, func(w ModuleTypeWriter) error {
+				return w.WriteString([]byte("after"))
+			})
+		},
+		enc.WriteEnd,
+	)
+	for _, step := range steps {
+		if err = step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var actualFunctions []*model.FunctionsObject
+	var moduleAux []*model.ModuleAuxObject
+	dec := NewDecoder(buf).WithSpecialOpCode().WithSpecialType("testtype1",
+		func(h ModuleTypeHandler, encVersion int) (interface{}, error) {
+			var values []interface{}
+			for {
+				opcode, err := h.ReadOpcode()
+				if err != nil {
+					return nil, err
+				}
+				var value interface{}
+				switch opcode {
+				case ModuleOpcodeEOF:
+					return values, nil
+				case ModuleOpcodeUInt:
+					value, err = h.ReadUInt()
+				case ModuleOpcodeSInt:
+					value, err = h.ReadSInt()
+				case ModuleOpcodeFloat:
+					value, err = h.ReadFloat32()
+				case ModuleOpcodeDouble:
+					value, err = h.ReadDouble()
+				case ModuleOpcodeString:
+					var s []byte
+					s, err = h.ReadString()
+					value = string(s)
+				}
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+		})
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.FunctionsObject:
+			actualFunctions = append(actualFunctions, o)
+		case *model.ModuleAuxObject:
+			moduleAux = append(moduleAux, o)
+		case *model.StringObject:
+			if o.Key == "lru" && (o.IdleTime == nil || *o.IdleTime != 100 || o.Freq != nil) {
+				t.Errorf("wrong idle time of lru: %v", o.IdleTime)
+			}
+			if o.Key == "lfu" && (o.Freq == nil || *o.Freq != 5 || o.IdleTime != nil || o.Expiration == nil) {
+				t.Errorf("wrong freq of lfu: %v", o.Freq)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(functions, actualFunctions) {
+		t.Errorf("functions are not kept, expect %v, actual %v", functions, actualFunctions)
+	}
+	if len(moduleAux) != 2 {
+		t.Fatalf("expect 2 module aux, actual %d", len(moduleAux))
+	}
+	expect := []interface{}{uint64(1), int64(-2), float32(0.5), -1.5, "before"}
+	if aux := moduleAux[0]; aux.ModuleType != "testtype1" || aux.EncVersion != 3 || aux.When != model.ModuleAuxBeforeRDB ||
+		!reflect.DeepEqual(aux.Value, expect) {
+		t.Errorf("wrong module aux before rdb: %+v", aux)
+	}
+	if aux := moduleAux[1]; aux.When != model.ModuleAuxAfterRDB || !reflect.DeepEqual(aux.Value, []interface{}{"after"}) {
+		t.Errorf("wrong module aux after rdb: %+v", aux)
+	}
+
+	enc = NewEncoder(bytes.NewBuffer(nil))
+	if err = enc.WriteFunctions("#!lua name=lib"); err == nil {
+		t.Error("expect error for functions before header")
+	}
+	_ = enc.WriteHeader()
+	if err = enc.WriteModuleAux("bad", 0, model.ModuleAuxBeforeRDB, nil); err == nil {
+		t.Error("expect error for illegal module type name")
+	}
+	if err = enc.WriteModuleAux("testtype1", 0, 3, nil); err == nil {
+		t.Error("expect error for illegal when")
+	}
+	enc, _ = NewEncoderWithVersion(bytes.NewBuffer(nil), 9)
+	_ = enc.WriteHeader()
+	if err = enc.WriteFunctions("#!lua name=lib"); err == nil {
+		t.Error("expect error for functions in rdb version 9")
+	}
+}
@@ -0,0 +1,328 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+const (
+	luaName = iota
+	luaNumber
+	luaString
+	luaSymbol
+)
+
+type luaToken struct {
+	kind int
+	text string // text is the value of string literal, or the token itself
+}
+
+func (t luaToken) is(kind int, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// ParseFunctionLibrary parses metadata of a function library from its source code.
+// Engine and name come from the shebang line like `#!lua name=mylib`, functions come from calls of
+// redis.register_function. Functions whose names are not string literals are omitted.
+func ParseFunctionLibrary(code string) (model.FunctionLibrary, error) {
+	lib := model.FunctionLibrary{
+		Functions: make([]model.Function, 0),
+	}
+	if !strings.HasPrefix(code, "#!") {
+		return lib, errors.New("missing shebang of function library")
+	}
+	shebang, body := code, ""
+	if i := strings.IndexByte(code, '\n'); i >= 0 {
+		shebang, body = code[:i], code[i+1:]
+	}
+	fields := strings.Fields(shebang[2:])
+	if len(fields) == 0 {
+		return lib, errors.New("missing engine of function library")
+	}
+	lib.Engine = fields[0]
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "name=") {
+			lib.Name = strings.TrimPrefix(field, "name=")
+		}
+	}
+	if lib.Name == "" {
+		return lib, errors.New("missing name of function library")
+	}
+	tokens, err := luaTokens(body)
+	if err != nil {
+		return lib, fmt.Errorf("parse library %s failed: %v", lib.Name, err)
+	}
+	for i := 0; i+3 < len(tokens); i++ {
+		if !tokens[i].is(luaName, "redis") || !tokens[i+1].is(luaSymbol, ".") ||
+			!tokens[i+2].is(luaName, "register_function") {
+			continue
+		}
+		// register_function('name', callback), register_function({...}) or register_function{...}
+		var fn model.Function
+		j := i + 3
+		if tokens[j].is(luaSymbol, "(") && j+1 < len(tokens) {
+			j++
+			if tokens[j].kind == luaString {
+				fn.Name = tokens[j].text
+			}
+		}
+		if tokens[j].is(luaSymbol, "{") {
+			fn = parseRegisterTable(tokens, j)
+		}
+		if fn.Name != "" {
+			lib.Functions = append(lib.Functions, fn)
+		}
+	}
+	return lib, nil
+}
+
+// parseRegisterTable reads function_name, flags and description from the table constructor begins at tokens[begin]
+func parseRegisterTable(tokens []luaToken, begin int) model.Function {
+	var fn model.Function
+	i := begin + 1
+	for i < len(tokens) && !tokens[i].is(luaSymbol, "}") {
+		field := ""
+		if tokens[i].kind == luaName && i+1 < len(tokens) && tokens[i+1].is(luaSymbol, "=") {
+			field = tokens[i].text
+			i += 2
+		}
+		end := skipLuaExpr(tokens, i)
+		value := tokens[i:end]
+		switch {
+		case field == "function_name" && len(value) == 1 && value[0].kind == luaString:
+			fn.Name = value[0].text
+		case field == "description" && len(value) == 1 && value[0].kind == luaString:
+			fn.Description = value[0].text
+		case field == "flags" && len(value) > 0 && value[0].is(luaSymbol, "{"):
+			for _, token := range value {
+				if token.kind == luaString {
+					fn.Flags = append(fn.Flags, token.text)
+				}
+			}
+		}
+		i = end
+		if i < len(tokens) && (tokens[i].is(luaSymbol, ",") || tokens[i].is(luaSymbol, ";")) {
+			i++
+		}
+	}
+	return fn
+}
+
+// skipLuaExpr returns position of the separator or closing bracket after the expression begins at tokens[begin]
+func skipLuaExpr(tokens []luaToken, begin int) int {
+	depth := 0 // depth of brackets and blocks, like function ... end
+	i := begin
+	for ; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.kind == luaSymbol {
+			switch token.text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth == 0 {
+					return i
+				}
+				depth--
+			case ",", ";":
+				if depth == 0 {
+					return i
+				}
+			}
+		} else if token.kind == luaName {
+			switch token.text {
+			case "function", "if", "do", "repeat":
+				depth++
+			case "end", "until":
+				depth--
+			}
+		}
+	}
+	return i
+}
+
+// luaTokens splits lua source code into tokens, comments are dropped
+func luaTokens(code string) ([]luaToken, error) {
+	var tokens []luaToken
+	i := 0
+	for i < len(code) {
+		c := code[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f':
+			i++
+		case strings.HasPrefix(code[i:], "--"):
+			i += 2
+			if level := luaLongBracketLevel(code[i:]); level >= 0 {
+				_, n, err := readLuaLongBracket(code[i:], level)
+				if err != nil {
+					return nil, errors.New("unfinished long comment")
+				}
+				i += n
+			} else if n := strings.IndexByte(code[i:], '\n'); n >= 0 {
+				i += n + 1
+			} else {
+				i = len(code)
+			}
+		case c == '_' || isLetter(c):
+			j := i + 1
+			for j < len(code) && (code[j] == '_' || isLetter(code[j]) || isDigit(code[j])) {
+				j++
+			}
+			tokens = append(tokens, luaToken{kind: luaName, text: code[i:j]})
+			i = j
+		case isDigit(c) || (c == '.' && i+1 < len(code) && isDigit(code[i+1])):
+			j := i + 1
+			for j < len(code) && (code[j] == '.' || code[j] == '_' || isLetter(code[j]) || isDigit(code[j])) {
+				j++
+			}
+			tokens = append(tokens, luaToken{kind: luaNumber, text: code[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			s, n, err := readLuaQuotedString(code[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, luaToken{kind: luaString, text: s})
+			i += n
+		case c == '[' && luaLongBracketLevel(code[i:]) >= 0:
+			s, n, err := readLuaLongBracket(code[i:], luaLongBracketLevel(code[i:]))
+			if err != nil {
+				return nil, errors.New("unfinished long string")
+			}
+			tokens = append(tokens, luaToken{kind: luaString, text: s})
+			i += n
+		default:
+			tokens = append(tokens, luaToken{kind: luaSymbol, text: code[i : i+1]})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// luaLongBracketLevel returns number of '=' in opening long bracket like [==[, or -1 if s does not begin with it
+func luaLongBracketLevel(s string) int {
+	if len(s) == 0 || s[0] != '[' {
+		return -1
+	}
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level < len(s) && s[level] == '[' {
+		return level - 1
+	}
+	return -1
+}
+
+// readLuaLongBracket returns content of long bracket at the beginning of s and its length
+func readLuaLongBracket(s string, level int) (string, int, error) {
+	open := level + 2
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(s[open:], closing)
+	if end < 0 {
+		return "", 0, errors.New("unfinished long bracket")
+	}
+	content := s[open : open+end]
+	// a newline immediately following the opening bracket is skipped
+	if strings.HasPrefix(content, "\r\n") {
+		content = content[2:]
+	} else if strings.HasPrefix(content, "\n") {
+		content = content[1:]
+	}
+	return content, open + end + len(closing), nil
+}
+
+// readLuaQuotedString returns value of quoted string at the beginning of s and its length in s
+func readLuaQuotedString(s string) (string, int, error) {
+	quote := s[0]
+	var sb strings.Builder
+	i := 1
+	for i < len(s) {
+		c := s[i]
+		if c == quote {
+			return sb.String(), i + 1, nil
+		}
+		if c == '\n' {
+			break
+		}
+		if c != '\\' {
+			sb.WriteByte(c)
+			i++
+			continue
+		}
+		i++
+		if i >= len(s) {
+			break
+		}
+		c = s[i]
+		i++
+		switch c {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'v':
+			sb.WriteByte('\v')
+		case 'x':
+			if i+2 > len(s) {
+				return "", 0, errors.New("invalid escape sequence in string")
+			}
+			b, err := strconv.ParseUint(s[i:i+2], 16, 8)
+			if err != nil {
+				return "", 0, errors.New("invalid escape sequence in string")
+			}
+			sb.WriteByte(byte(b))
+			i += 2
+		case 'z':
+			for i < len(s) && strings.IndexByte(" \t\r\n\v\f", s[i]) >= 0 {
+				i++
+			}
+		case 'u':
+			end := strings.IndexByte(s[i:], '}')
+			if !strings.HasPrefix(s[i:], "{") || end < 0 {
+				return "", 0, errors.New("invalid escape sequence in string")
+			}
+			r, err := strconv.ParseUint(s[i+1:i+end], 16, 32)
+			if err != nil {
+				return "", 0, errors.New("invalid escape sequence in string")
+			}
+			sb.WriteRune(rune(r))
+			i += end + 1
+		default:
+			if isDigit(c) {
+				j := i
+				for j < len(s) && j < i+2 && isDigit(s[j]) {
+					j++
+				}
+				b, err := strconv.ParseUint(s[i-1:j], 10, 8)
+				if err != nil {
+					return "", 0, errors.New("invalid escape sequence in string")
+				}
+				sb.WriteByte(byte(b))
+				i = j
+			} else {
+				sb.WriteByte(c) // \\, \', \" and escaped newline
+			}
+		}
+	}
+	return "", 0, errors.New("unfinished string")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/hdt3213/rdb/model"
@@ -20,10 +21,12 @@ func TestParseFunctions(t *testing.T) {
 
 	dec := NewDecoder(rdbFile).WithSpecialOpCode()
 	var functionLua string
+	var libraries []model.FunctionLibrary
 	dec.Parse(func(object model.RedisObject) bool {
 		if object.GetType() == model.FunctionsType {
 			functionObj := object.(*model.FunctionsObject)
 			functionLua = functionObj.FunctionsLua
+			libraries = functionObj.Libraries
 			return false
 		}
 		return true
@@ -32,4 +35,74 @@ func TestParseFunctions(t *testing.T) {
 	if functionLua != expect {
 		t.Error("function lua is not equals")
 	}
-}
\ No newline at end of file
+	if len(libraries) != 1 || libraries[0].Name != "mylib" || len(libraries[0].Functions) != 1 ||
+		libraries[0].Functions[0].Name != "myfunc" {
+		t.Errorf("wrong libraries: %+v", libraries)
+	}
+}
+func TestParseFunctionLibrary(t *testing.T) {
+	code := `#!lua name=mylib
+-- redis.register_function('commented', function() end)
+--[[
+redis.register_function('long_commented', function() end)
+]]
+local function helper(keys)
+  if #keys > 0 then
+    return keys[1]
+  end
+  return nil
+end
+
+redis.register_function('plain', function(keys, args) return helper(keys) end)
+
+redis.register_function{
+  function_name = 'readonly',
+  callback = function(keys, args)
+    local a, b = 1, 2
+    for i = 1, 2 do a = a + i end
+    return {a, b}
+  end,
+  flags = { 'no-writes', "allow-stale" },
+  description = [[returns "a" and 'b']],
+}
+
+redis.register_function({function_name="escaped\tname", callback=function() return 'x, y' end; description='it\'s\065'})
+
+local name = 'dynamic'
+redis.register_function(name, function() return 1 end)
+`
+	lib, err := ParseFunctionLibrary(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.Engine != "lua" || lib.Name != "mylib" {
+		t.Errorf("wrong library: %s %s", lib.Engine, lib.Name)
+	}
+	expect := []model.Function{
+		{Name: "plain"},
+		{Name: "readonly", Flags: []string{"no-writes", "allow-stale"}, Description: `returns "a" and 'b'`},
+		{Name: "escaped\tname", Description: "it'sA"},
+	}
+	if len(lib.Functions) != len(expect) {
+		t.Fatalf("expect %d functions, actual %d: %+v", len(expect), len(lib.Functions), lib.Functions)
+	}
+	for i, fn := range expect {
+		actual := lib.Functions[i]
+		if actual.Name != fn.Name || actual.Description != fn.Description ||
+			strings.Join(actual.Flags, ",") != strings.Join(fn.Flags, ",") {
+			t.Errorf("expect function %+v, actual %+v", fn, actual)
+		}
+	}
+
+	errCases := []string{
+		"redis.register_function('f', function() end)",
+		"#!lua\nredis.register_function('f', function() end)",
+		"#!lua name=lib\nredis.register_function('f, function() end)",
+		"#!lua name=lib\n--[[ unfinished comment",
+	}
+	for _, code := range errCases {
+		if _, err := ParseFunctionLibrary(code); err == nil {
+			t.Errorf("expect error of %q", code)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 
 	"github.com/hdt3213/rdb/model"
 )
@@ -13,8 +14,13 @@ import (
 	if hlen <= ZIPMAP_VALUE_MAX_FREE
 */
 
+const (
+	zipMapBigLen = 253 // lengths from zipMapBigLen are stored in 5 bytes
+	zipMapEnd    = 255
+)
+
 func (dec *Decoder) readHashMap() (map[string][]byte, error) {
-	size, _, err := dec.readLength()
+	size, _, err := dec.readSize()
 	if err != nil {
 		return nil, err
 	}
@@ -36,50 +42,14 @@ func (dec *Decoder) readHashMap() (map[string][]byte, error) {
 // readHashMapEx reads hash with field-level expiration for Redis 7.4+ (typeHashWithHfe / typeHashWithHfeRc).
 // rc=true for 7.4 RC format (absolute TTL, no minExpire header), rc=false for 7.4 GA format (relative TTL with minExpire header).
 func (dec *Decoder) readHashMapEx(rc bool) (map[string][]byte, map[string]int64, error) {
-	var minExpire int64 = EB_EXPIRE_TIME_INVALID
-	var expire int64
-	if !rc {
-		// Hash with HFEs. min TTL at start (7.4+), 7.4RC not included
-		min, err := dec.readInt64()
-		if err != nil {
-			return nil, nil, err
-		}
-		if min > EB_EXPIRE_TIME_INVALID {
-			return nil, nil, fmt.Errorf("hash read invalid minExpire value: %d", min)
-		}
-		minExpire = min
-	}
-	size, _, err := dec.readLength()
+	minExpire, size, err := dec.readHashExHeader(!rc)
 	if err != nil {
 		return nil, nil, err
-	} else if size == 0 {
-		return nil, nil, fmt.Errorf("hash read empty key")
 	}
 	m := make(map[string][]byte)
 	e := make(map[string]int64)
 	for i := 0; i < int(size); i++ {
-		ttl, _, err := dec.readLength()
-		if err != nil {
-			return nil, nil, err
-		}
-		if rc {
-			// Value is absolute for 7.4RC
-			expire = int64(ttl)
-		} else if ttl == 0 {
-			// 0 Indicates no TTL. This is common case so we keep it small.
-			expire = 0
-		} else {
-			// TTL is relative to minExpire (with +1 to avoid 0 that already taken)
-			expire = int64(ttl) + minExpire - 1
-		}
-		if expire > EB_EXPIRE_TIME_MAX {
-			return nil, nil, fmt.Errorf("invalid expireAt time: %d", expire)
-		}
-		field, err := dec.readString()
-		if err != nil {
-			return nil, nil, err
-		}
-		value, err := dec.readString()
+		field, value, expire, err := dec.readHashExField(rc, minExpire)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -90,39 +60,99 @@ func (dec *Decoder) readHashMapEx(rc bool) (map[string][]byte, map[string]int64,
 }
 
 // readHashMapExValkey reads hash with field-level expiration for Valkey 9+ (typeHash2).
-// Valkey stores absolute expiration timestamps as int64 after each field-value pair.
-// -1 (or negative) means no TTL, which is normalized to 0.
 func (dec *Decoder) readHashMapExValkey() (map[string][]byte, map[string]int64, error) {
-	size, _, err := dec.readLength()
+	_, size, err := dec.readHashExHeader(false)
 	if err != nil {
 		return nil, nil, err
-	} else if size == 0 {
-		return nil, nil, fmt.Errorf("hash read empty key")
 	}
 	m := make(map[string][]byte)
 	e := make(map[string]int64)
 	for i := 0; i < int(size); i++ {
-		field, err := dec.readString()
-		if err != nil {
-			return nil, nil, err
-		}
-		value, err := dec.readString()
+		field, value, expire, err := dec.readHashExFieldValkey()
 		if err != nil {
 			return nil, nil, err
 		}
-		expire, err := dec.readInt64()
-		if err != nil {
-			return nil, nil, err
-		}
-		if expire < 0 {
-			expire = 0 // valkey uses -1 to indicate no TTL
-		}
 		m[unsafeBytes2Str(field)] = value
 		e[unsafeBytes2Str(field)] = expire
 	}
 	return m, e, nil
 }
 
+// readHashExHeader reads the header of hash with field-level expiration: minExpire (only in redis 7.4 GA format,
+// EB_EXPIRE_TIME_INVALID if absent) and number of fields. It is shared by readHashMapEx, readHashMapExValkey
+// and readHashExElements.
+func (dec *Decoder) readHashExHeader(withMinExpire bool) (int64, uint64, error) {
+	var minExpire int64 = EB_EXPIRE_TIME_INVALID
+	if withMinExpire {
+		// Hash with HFEs. min TTL at start (7.4+), 7.4RC not included
+		min, err := dec.readInt64()
+		if err != nil {
+			return 0, 0, err
+		}
+		if min > EB_EXPIRE_TIME_INVALID {
+			return 0, 0, fmt.Errorf("hash read invalid minExpire value: %d", min)
+		}
+		minExpire = min
+	}
+	size, _, err := dec.readSize()
+	if err != nil {
+		return 0, 0, err
+	} else if size == 0 {
+		return 0, 0, fmt.Errorf("hash read empty key")
+	}
+	return minExpire, size, nil
+}
+
+// readHashExField reads a field of redis hash with field-level expiration, ttl is before field and value
+func (dec *Decoder) readHashExField(rc bool, minExpire int64) ([]byte, []byte, int64, error) {
+	ttl, _, err := dec.readLength()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var expire int64
+	if rc {
+		// Value is absolute for 7.4RC
+		expire = int64(ttl)
+	} else if ttl != 0 {
+		// TTL is relative to minExpire (with +1 to avoid 0 that already taken), 0 Indicates no TTL
+		expire = int64(ttl) + minExpire - 1
+	}
+	if expire > EB_EXPIRE_TIME_MAX {
+		return nil, nil, 0, fmt.Errorf("invalid expireAt time: %d", expire)
+	}
+	field, err := dec.readString()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	value, err := dec.readString()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return field, value, expire, nil
+}
+
+// readHashExFieldValkey reads a field of valkey hash with field-level expiration.
+// Valkey stores absolute expiration timestamps as int64 after each field-value pair.
+// -1 (or negative) means no TTL, which is normalized to 0.
+func (dec *Decoder) readHashExFieldValkey() ([]byte, []byte, int64, error) {
+	field, err := dec.readString()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	value, err := dec.readString()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	expire, err := dec.readInt64()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if expire < 0 {
+		expire = 0 // valkey uses -1 to indicate no TTL
+	}
+	return field, value, expire, nil
+}
+
 func (dec *Decoder) readZipMapHash() (map[string][]byte, error) {
 	buf, err := dec.readString()
 	if err != nil {
@@ -320,7 +350,15 @@ func (enc *Encoder) WriteHashMapObject(key string, hash map[string][]byte, optio
 	if err != nil {
 		return err
 	}
-	ok, err := enc.tryWriteZipListHashMap(key, hash, options...)
+	var ok bool
+	if hintedEncoding(options...) == model.ZipMapEncoding {
+		ok, err = enc.tryWriteZipMapHashMap(key, hash)
+	} else if enc.preferListPack(options...) {
+		ok, err = enc.tryWriteListPackHashMap(key, hash, options...)
+	}
+	if err == nil && !ok && !enc.preferListPack(options...) {
+		ok, err = enc.tryWriteZipListHashMap(key, hash, options...)
+	}
 	if err != nil {
 		return err
 	}
@@ -335,13 +373,19 @@ func (enc *Encoder) WriteHashMapObject(key string, hash map[string][]byte, optio
 }
 
 func (enc *Encoder) WriteHashMapObjectEx(key string, hash map[string][]byte, expire map[string]int64, options ...interface{}) error {
-	err := enc.beforeWriteObject(options...)
+	err := enc.requireVersion(12, "hash field expiration")
+	if err != nil {
+		return err
+	}
+	err = enc.beforeWriteObject(options...)
 	if err != nil {
 		return err
 	}
 
 	if enc.valkey {
 		err = enc.writeHash2Encoding(key, hash, expire, options...)
+	} else if hintedEncoding(options...) == model.ListPackExEncoding {
+		err = enc.writeListPackHashEx(key, hash, expire)
 	} else {
 		err = enc.writeHashEncodingEx(key, hash, expire, options...)
 	}
@@ -473,14 +517,20 @@ func (enc *Encoder) writeHash2Encoding(key string, hash map[string][]byte, expir
 }
 
 func (enc *Encoder) tryWriteZipListHashMap(key string, hash map[string][]byte, options ...interface{}) (bool, error) {
-	if len(hash) > enc.hashZipListOpt.getMaxEntries() {
+	compact, hinted := encodingHint(options...)
+	if hinted && !compact {
 		return false, nil
 	}
-	maxValue := enc.hashZipListOpt.getMaxValue()
-	for _, v := range hash {
-		if len(v) > maxValue {
+	if !compact {
+		if len(hash) > enc.hashZipListOpt.getMaxEntries() {
 			return false, nil
 		}
+		maxValue := enc.hashZipListOpt.getMaxValue()
+		for _, v := range hash {
+			if len(v) > maxValue {
+				return false, nil
+			}
+		}
 	}
 	err := enc.write([]byte{typeHashZipList})
 	if err != nil {
@@ -500,3 +550,113 @@ func (enc *Encoder) tryWriteZipListHashMap(key string, hash map[string][]byte, o
 	}
 	return true, nil
 }
+
+func (enc *Encoder) tryWriteListPackHashMap(key string, hash map[string][]byte, options ...interface{}) (bool, error) {
+	compact, hinted := encodingHint(options...)
+	if hinted && !compact {
+		return false, nil
+	}
+	if !compact {
+		if len(hash) > enc.hashListPackOpt.getMaxEntries() {
+			return false, nil
+		}
+		maxValue := enc.hashListPackOpt.getMaxValue()
+		for k, v := range hash {
+			if len(k) > maxValue || len(v) > maxValue {
+				return false, nil
+			}
+		}
+	}
+	err := enc.write([]byte{typeHashListPack})
+	if err != nil {
+		return true, err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return true, err
+	}
+	lp := newListPackBuilder()
+	for k, v := range hash {
+		lp.appendString(k)
+		lp.appendString(unsafeBytes2Str(v))
+	}
+	err = enc.writeNanString(unsafeBytes2Str(lp.bytes()))
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// writeListPackHashEx writes hash with field expiration in listpack of field, value and expiration time triples.
+// Like redis, fields are sorted by expiration time and fields without expiration are at the end
+func (enc *Encoder) writeListPackHashEx(key string, hash map[string][]byte, expire map[string]int64) error {
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		ei, ej := expire[fields[i]], expire[fields[j]]
+		if ei != ej {
+			return ej == 0 || (ei != 0 && ei < ej)
+		}
+		return fields[i] < fields[j]
+	})
+	minExpire := EB_EXPIRE_TIME_INVALID
+	if len(fields) > 0 && expire[fields[0]] > 0 {
+		minExpire = expire[fields[0]]
+	}
+	err := enc.write([]byte{typeHashListPackWithHfe})
+	if err != nil {
+		return err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(enc.buffer, uint64(minExpire))
+	err = enc.write(enc.buffer)
+	if err != nil {
+		return err
+	}
+	lp := newListPackBuilder()
+	for _, field := range fields {
+		lp.appendString(field)
+		lp.appendString(unsafeBytes2Str(hash[field]))
+		lp.appendInt(expire[field])
+	}
+	return enc.writeNanString(unsafeBytes2Str(lp.bytes()))
+}
+
+// tryWriteZipMapHashMap writes hash in zipmap which is written by redis before 2.6.
+// It returns false if hash has too many fields or too long fields or values to be stored in zipmap
+func (enc *Encoder) tryWriteZipMapHashMap(key string, hash map[string][]byte) (bool, error) {
+	if len(hash) >= zipMapBigLen {
+		return false, nil
+	}
+	for field, value := range hash {
+		if len(field) >= zipMapBigLen || len(value) >= zipMapBigLen {
+			return false, nil
+		}
+	}
+	err := enc.write([]byte{typeHashZipMap})
+	if err != nil {
+		return true, err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return true, err
+	}
+	buf := []byte{byte(len(hash))}
+	for field, value := range hash {
+		buf = append(buf, byte(len(field)))
+		buf = append(buf, field...)
+		buf = append(buf, byte(len(value)), 0) // no free bytes after value
+		buf = append(buf, value...)
+	}
+	buf = append(buf, zipMapEnd)
+	err = enc.writeNanString(unsafeBytes2Str(buf))
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
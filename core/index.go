@@ -0,0 +1,399 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// Index file layout:
+//
+//	header: magic(8) version(4) rdbSize(8) rdbModTime(8) count(8) tableOffset(8)
+//	entries: db(uvarint) keyLen(uvarint) key offset(uvarint) size(uvarint) typeLen(uvarint) type
+//	table: count * (hash(8) entryOffset(8)), sorted by hash
+const (
+	indexMagic      = "RDBINDEX"
+	indexVersion    = 1
+	indexHeaderSize = 8 + 4 + 8 + 8 + 8 + 8
+	indexSlotSize   = 16
+	// IndexSuffix is appended to rdb filename as the name of its index file
+	IndexSuffix = ".idx"
+)
+
+// IndexEntry is the position and metadata of a key in rdb file
+type IndexEntry struct {
+	DB     int    `json:"db"`
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"` // Offset of the first opcode of the key, including expiration, lru, lfu, etc.
+	Type   string `json:"type"`
+	Size   int    `json:"size"`
+}
+
+type indexSlot struct {
+	hash   uint64
+	offset int64
+}
+
+type indexHeader struct {
+	rdbSize     int64
+	rdbModTime  int64
+	count       int64
+	tableOffset int64
+}
+
+func hashIndexKey(db int, key string) uint64 {
+	h := fnv.New64a()
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(db))
+	_, _ = h.Write(buf[:n])
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// BuildIndex scans rdb file and writes an index file mapping (db, key) to offset, type and size of the key.
+// A ProgressHookFunc in options reports progress of scanning.
+func BuildIndex(rdbFilename string, indexFilename string, options ...interface{}) error {
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	info, err := rdbFile.Stat()
+	if err != nil {
+		return err
+	}
+	tmpFilename := indexFilename + ".tmp"
+	indexFile, err := os.Create(tmpFilename)
+	if err != nil {
+		return fmt.Errorf("create index %s failed, %v", tmpFilename, err)
+	}
+	defer func() {
+		_ = indexFile.Close()
+		_ = os.Remove(tmpFilename)
+	}()
+
+	writer := bufio.NewWriter(indexFile)
+	position := int64(indexHeaderSize)
+	_, err = writer.Write(make([]byte, indexHeaderSize)) // header is written at last
+	if err != nil {
+		return err
+	}
+	var slots []indexSlot
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		_, err = writer.Write(buf[:n])
+		position += int64(n)
+	}
+	writeString := func(s string) {
+		writeUvarint(uint64(len(s)))
+		if err == nil {
+			_, err = writer.WriteString(s)
+			position += int64(len(s))
+		}
+	}
+	dec := NewDecoder(rdbFile).WithMetadataOnly()
+	for _, opt := range options {
+		if hook, ok := opt.(ProgressHookFunc); ok {
+			dec.WithProgress(hook)
+		}
+	}
+	parseErr := dec.Parse(func(object model.RedisObject) bool {
+		slots = append(slots, indexSlot{
+			hash:   hashIndexKey(object.GetDBIndex(), object.GetKey()),
+			offset: position,
+		})
+		writeUvarint(uint64(object.GetDBIndex()))
+		writeString(object.GetKey())
+		writeUvarint(uint64(dec.objectBegin))
+		writeUvarint(uint64(object.GetSize()))
+		writeString(object.GetType())
+		return err == nil
+	})
+	if parseErr != nil {
+		return fmt.Errorf("scan rdb failed: %v", parseErr)
+	}
+	if err != nil {
+		return fmt.Errorf("write index failed: %v", err)
+	}
+
+	sort.Slice(slots, func(i, j int) bool {
+		if slots[i].hash != slots[j].hash {
+			return slots[i].hash < slots[j].hash
+		}
+		return slots[i].offset < slots[j].offset
+	})
+	header := indexHeader{
+		rdbSize:     info.Size(),
+		rdbModTime:  info.ModTime().UnixNano(),
+		count:       int64(len(slots)),
+		tableOffset: position,
+	}
+	slotBuf := make([]byte, indexSlotSize)
+	for _, slot := range slots {
+		binary.LittleEndian.PutUint64(slotBuf[0:8], slot.hash)
+		binary.LittleEndian.PutUint64(slotBuf[8:16], uint64(slot.offset))
+		_, err = writer.Write(slotBuf)
+		if err != nil {
+			return fmt.Errorf("write index failed: %v", err)
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		return fmt.Errorf("write index failed: %v", err)
+	}
+	_, err = indexFile.WriteAt(header.marshal(), 0)
+	if err != nil {
+		return fmt.Errorf("write index failed: %v", err)
+	}
+	err = indexFile.Close()
+	if err != nil {
+		return fmt.Errorf("write index failed: %v", err)
+	}
+	return os.Rename(tmpFilename, indexFilename)
+}
+
+func (h *indexHeader) marshal() []byte {
+	buf := make([]byte, indexHeaderSize)
+	copy(buf, indexMagic)
+	binary.LittleEndian.PutUint32(buf[8:12], indexVersion)
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(h.rdbSize))
+	binary.LittleEndian.PutUint64(buf[20:28], uint64(h.rdbModTime))
+	binary.LittleEndian.PutUint64(buf[28:36], uint64(h.count))
+	binary.LittleEndian.PutUint64(buf[36:44], uint64(h.tableOffset))
+	return buf
+}
+
+func readIndexHeader(file *os.File) (*indexHeader, error) {
+	buf := make([]byte, indexHeaderSize)
+	_, err := file.ReadAt(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if string(buf[:8]) != indexMagic {
+		return nil, errors.New("not an index file")
+	}
+	if version := binary.LittleEndian.Uint32(buf[8:12]); version != indexVersion {
+		return nil, fmt.Errorf("unsupported index version: %d", version)
+	}
+	return &indexHeader{
+		rdbSize:     int64(binary.LittleEndian.Uint64(buf[12:20])),
+		rdbModTime:  int64(binary.LittleEndian.Uint64(buf[20:28])),
+		count:       int64(binary.LittleEndian.Uint64(buf[28:36])),
+		tableOffset: int64(binary.LittleEndian.Uint64(buf[36:44])),
+	}, nil
+}
+
+// IndexedRDB is a rdb file with an index file, it supports random-access lookup of single keys
+type IndexedRDB struct {
+	rdbFile   *os.File
+	indexFile *os.File
+	header    *indexHeader
+	dec       *Decoder // dec has read header of rdb file
+}
+
+// OpenIndexed opens rdb file and its index file (path + IndexSuffix).
+// The index is built if it is missing or does not match the rdb file, options are passed to BuildIndex.
+func OpenIndexed(path string, options ...interface{}) (*IndexedRDB, error) {
+	rdbFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open rdb %s failed, %v", path, err)
+	}
+	idx, err := openIndexed(rdbFile, path+IndexSuffix, options...)
+	if err != nil {
+		_ = rdbFile.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+func openIndexed(rdbFile *os.File, indexFilename string, options ...interface{}) (*IndexedRDB, error) {
+	info, err := rdbFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	indexFile, header, err := openIndexFile(indexFilename)
+	if err != nil || header.rdbSize != info.Size() || header.rdbModTime != info.ModTime().UnixNano() {
+		if indexFile != nil {
+			_ = indexFile.Close()
+		}
+		err = BuildIndex(rdbFile.Name(), indexFilename, options...)
+		if err != nil {
+			return nil, fmt.Errorf("build index failed: %v", err)
+		}
+		indexFile, header, err = openIndexFile(indexFilename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dec := NewDecoder(io.NewSectionReader(rdbFile, 0, header.rdbSize))
+	err = dec.checkHeader()
+	if err != nil {
+		_ = indexFile.Close()
+		return nil, err
+	}
+	return &IndexedRDB{
+		rdbFile:   rdbFile,
+		indexFile: indexFile,
+		header:    header,
+		dec:       dec,
+	}, nil
+}
+
+func openIndexFile(indexFilename string) (*os.File, *indexHeader, error) {
+	indexFile, err := os.Open(indexFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := readIndexHeader(indexFile)
+	if err != nil {
+		return indexFile, nil, err
+	}
+	return indexFile, header, nil
+}
+
+// WithSpecialType registers handler of module type for Get, see Decoder.WithSpecialType
+func (idx *IndexedRDB) WithSpecialType(moduleType string, f ModuleTypeHandleFunc) *IndexedRDB {
+	idx.dec.WithSpecialType(moduleType, f)
+	return idx
+}
+
+// Len returns number of keys in rdb
+func (idx *IndexedRDB) Len() int {
+	return int(idx.header.count)
+}
+
+func (idx *IndexedRDB) readSlot(i int64) (uint64, int64, error) {
+	buf := make([]byte, indexSlotSize)
+	_, err := idx.indexFile.ReadAt(buf, idx.header.tableOffset+i*indexSlotSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	return binary.LittleEndian.Uint64(buf[0:8]), int64(binary.LittleEndian.Uint64(buf[8:16])), nil
+}
+
+func (idx *IndexedRDB) readEntry(offset int64) (*IndexEntry, error) {
+	reader := bufio.NewReader(io.NewSectionReader(idx.indexFile, offset, idx.header.tableOffset-offset))
+	readString := func() (string, error) {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return "", err
+		}
+		buf := make([]byte, length)
+		_, err = io.ReadFull(reader, buf)
+		return string(buf), err
+	}
+	db, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	key, err := readString()
+	if err != nil {
+		return nil, err
+	}
+	keyOffset, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := readString()
+	if err != nil {
+		return nil, err
+	}
+	return &IndexEntry{
+		DB:     int(db),
+		Key:    key,
+		Offset: int64(keyOffset),
+		Type:   typ,
+		Size:   int(size),
+	}, nil
+}
+
+// Lookup returns position and metadata of the key, returns nil if the key is not found
+func (idx *IndexedRDB) Lookup(db int, key string) (*IndexEntry, error) {
+	hash := hashIndexKey(db, key)
+	var searchErr error
+	i := sort.Search(int(idx.header.count), func(i int) bool {
+		h, _, err := idx.readSlot(int64(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return h >= hash
+	})
+	if searchErr != nil {
+		return nil, fmt.Errorf("read index failed: %v", searchErr)
+	}
+	for ; i < int(idx.header.count); i++ {
+		h, offset, err := idx.readSlot(int64(i))
+		if err != nil {
+			return nil, fmt.Errorf("read index failed: %v", err)
+		}
+		if h != hash {
+			break
+		}
+		entry, err := idx.readEntry(offset)
+		if err != nil {
+			return nil, fmt.Errorf("read index failed: %v", err)
+		}
+		if entry.DB == db && entry.Key == key {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// Get decodes the key from rdb file, returns nil if the key is not found
+func (idx *IndexedRDB) Get(db int, key string) (obj model.RedisObject, err error) {
+	entry, err := idx.Lookup(db, key)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			err = fmt.Errorf("panic: %v", err2)
+		}
+	}()
+	rng := &parseRange{
+		begin: entry.Offset,
+		end:   idx.header.rdbSize,
+		state: &model.BaseObject{DB: db},
+	}
+	sub := idx.dec.fork()
+	sub.input = bufio.NewReader(io.NewSectionReader(idx.rdbFile, rng.begin, rng.end-rng.begin))
+	sub.readCount = int(rng.begin)
+	sub.withoutChecksum = true
+	sub.rng = rng
+	err = sub.parse(func(object model.RedisObject) bool {
+		obj = object
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil || obj.GetKey() != key {
+		return nil, errors.New("index does not match rdb file")
+	}
+	return obj, nil
+}
+
+// Close closes rdb file and index file
+func (idx *IndexedRDB) Close() error {
+	err := idx.indexFile.Close()
+	if err2 := idx.rdbFile.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func copyFile(t *testing.T, src, dst string) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(dst, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexedRDB(t *testing.T) {
+	files, err := filepath.Glob("../cases/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, filename := range files {
+		rdbFilename := filepath.Join(dir, filepath.Base(filename))
+		copyFile(t, filename, rdbFilename)
+		file, err := os.Open(rdbFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expect []model.RedisObject
+		err = NewDecoder(file).Parse(func(object model.RedisObject) bool {
+			expect = append(expect, object)
+			return true
+		})
+		_ = file.Close()
+		if err != nil {
+			t.Errorf("parse %s failed: %v", filename, err)
+			continue
+		}
+
+		idx, err := OpenIndexed(rdbFilename)
+		if err != nil {
+			t.Errorf("open %s failed: %v", filename, err)
+			continue
+		}
+		if idx.Len() != len(expect) {
+			t.Errorf("%s: expect %d keys in index, actual %d", filename, len(expect), idx.Len())
+		}
+		for _, object := range expect {
+			entry, err := idx.Lookup(object.GetDBIndex(), object.GetKey())
+			if err != nil || entry == nil {
+				t.Errorf("%s: lookup %s failed: %v", filename, object.GetKey(), err)
+				continue
+			}
+			if entry.Type != object.GetType() {
+				t.Errorf("%s: expect type %s, actual %s", filename, object.GetType(), entry.Type)
+			}
+			actual, err := idx.Get(object.GetDBIndex(), object.GetKey())
+			if err != nil || actual == nil {
+				t.Errorf("%s: get %s failed: %v", filename, object.GetKey(), err)
+				continue
+			}
+			expectJSON, _ := json.Marshal(object)
+			actualJSON, _ := json.Marshal(actual)
+			if string(expectJSON) != string(actualJSON) {
+				t.Errorf("%s: expect %s, actual %s", filename, expectJSON, actualJSON)
+			}
+		}
+		obj, err := idx.Get(0, "not-exists")
+		if err != nil || obj != nil {
+			t.Errorf("%s: expect nil for missing key, actual %v, %v", filename, obj, err)
+		}
+		obj, err = idx.Get(100, "not-exists")
+		if err != nil || obj != nil {
+			t.Errorf("%s: expect nil for missing db, actual %v, %v", filename, obj, err)
+		}
+		_ = idx.Close()
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	dir := t.TempDir()
+	rdbFilename := filepath.Join(dir, "dump.rdb")
+	copyFile(t, "../cases/memory.rdb", rdbFilename)
+	idx, err := OpenIndexed(rdbFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = idx.Close()
+	info, err := os.Stat(rdbFilename + IndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	built := info.ModTime()
+
+	// fresh index is reused
+	idx, err = OpenIndexed(rdbFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = idx.Close()
+	info, _ = os.Stat(rdbFilename + IndexSuffix)
+	if !info.ModTime().Equal(built) {
+		t.Error("fresh index should not be rebuilt")
+	}
+
+	// rdb replaced by another file
+	copyFile(t, "../cases/hash.rdb", rdbFilename)
+	future := time.Now().Add(time.Hour)
+	_ = os.Chtimes(rdbFilename, future, future)
+	idx, err = OpenIndexed(rdbFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = idx.Close()
+	}()
+	obj, err := idx.Get(0, "force_dictionary")
+	if err != nil || obj == nil {
+		t.Errorf("stale index is used: %v", err)
+	}
+	obj, err = idx.Get(0, "hash")
+	if err != nil || obj != nil {
+		t.Errorf("stale index is used: %v", err)
+	}
+
+	// corrupted index
+	_ = idx.Close()
+	_ = os.WriteFile(rdbFilename+IndexSuffix, []byte("bad"), 0644)
+	idx, err = OpenIndexed(rdbFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() == 0 {
+		t.Error("index should be rebuilt")
+	}
+}
@@ -22,12 +22,12 @@ const (
 )
 
 func (dec *Decoder) readList() ([][]byte, error) {
-	size64, _, err := dec.readLength()
+	size64, _, err := dec.readSize()
 	if err != nil {
 		return nil, err
 	}
 	size := int(size64)
-	values := make([][]byte, 0, size)
+	values := make([][]byte, 0, dec.capacity(size64))
 	for i := 0; i < size; i++ {
 		val, err := dec.readString()
 		if err != nil {
@@ -39,7 +39,7 @@ func (dec *Decoder) readList() ([][]byte, error) {
 }
 
 func (dec *Decoder) readQuickList() ([][]byte, *model.QuicklistDetail, error) {
-	size, _, err := dec.readLength()
+	size, _, err := dec.readSize()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -58,7 +58,7 @@ func (dec *Decoder) readQuickList() ([][]byte, *model.QuicklistDetail, error) {
 
 // readQuickList2 returns
 func (dec *Decoder) readQuickList2() ([][]byte, *model.Quicklist2Detail, error) {
-	size, _, err := dec.readLength()
+	size, _, err := dec.readSize()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -102,7 +102,18 @@ func (enc *Encoder) WriteListObject(key string, values [][]byte, options ...inte
 		return err
 	}
 	if !ok {
-		err = enc.writeQuickList(key, values, options...)
+		switch hintedEncoding(options...) {
+		case model.ListEncoding:
+			err = enc.writeLinkedList(key, values)
+		case model.QuickList2Encoding:
+			if enc.atLeastVersion(10) {
+				err = enc.writeQuickList2(key, values)
+			} else {
+				err = enc.writeQuickList(key, values, options...)
+			}
+		default:
+			err = enc.writeQuickList(key, values, options...)
+		}
 		if err != nil {
 			return err
 		}
@@ -112,13 +123,17 @@ func (enc *Encoder) WriteListObject(key string, values [][]byte, options ...inte
 }
 
 func (enc *Encoder) tryWriteListZipList(key string, values [][]byte, options ...interface{}) (bool, error) {
-	if len(values) > enc.listZipListOpt.getMaxEntries() {
+	compact, hinted := encodingHint(options...)
+	if hinted && !compact {
+		return false, nil
+	}
+	if !compact && len(values) > enc.listZipListOpt.getMaxEntries() {
 		return false, nil
 	}
 	strList := make([]string, 0, len(values))
 	maxValue := enc.listZipListOpt.getMaxValue()
 	for _, v := range values {
-		if len(v) > maxValue {
+		if !compact && len(v) > maxValue {
 			return false, nil
 		}
 		strList = append(strList, unsafeBytes2Str(v))
@@ -175,6 +190,75 @@ func (enc *Encoder) writeQuickList(key string, values [][]byte, options ...inter
 	return nil
 }
 
+// writeLinkedList writes list in linked list encoding which is used before redis 3.2
+func (enc *Encoder) writeLinkedList(key string, values [][]byte) error {
+	err := enc.write([]byte{typeList})
+	if err != nil {
+		return err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(values)))
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		err = enc.writeString(unsafeBytes2Str(value))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeQuickList2 writes quicklist of listpack nodes which is used since redis 7.0, pages are split like writeQuickList
+func (enc *Encoder) writeQuickList2(key string, values [][]byte) error {
+	var pages [][][]byte
+	pageSize := 0
+	var curPage [][]byte
+	for _, value := range values {
+		curPage = append(curPage, value)
+		pageSize += len(value)
+		if pageSize >= enc.listZipListSize {
+			pageSize = 0
+			pages = append(pages, curPage)
+			curPage = nil
+		}
+	}
+	if len(curPage) > 0 {
+		pages = append(pages, curPage)
+	}
+	err := enc.write([]byte{typeListQuickList2})
+	if err != nil {
+		return err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(pages)))
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		err = enc.writeLength(model.QuicklistNodeContainerPacked)
+		if err != nil {
+			return err
+		}
+		lp := newListPackBuilder()
+		for _, value := range page {
+			lp.appendString(unsafeBytes2Str(value))
+		}
+		err = enc.writeNanString(unsafeBytes2Str(lp.bytes()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (enc *Encoder) writeZipList(values []string) error {
 	buf := make([]byte, 10) // reserve 10 bytes for zip list header
 	zlBytes := 11           // header(10bytes) + zl end(1byte)
@@ -192,6 +276,10 @@ func (enc *Encoder) writeZipList(values []string) error {
 	buf = append(buf, 0xff)
 	binary.LittleEndian.PutUint32(buf[0:4], uint32(zlBytes))
 	binary.LittleEndian.PutUint32(buf[4:8], uint32(zlTail))
-	binary.LittleEndian.PutUint16(buf[8:10], uint16(len(values)))
+	count := len(values)
+	if count > zipListUnknownLength {
+		count = zipListUnknownLength
+	}
+	binary.LittleEndian.PutUint16(buf[8:10], uint16(count))
 	return enc.writeNanString(unsafeBytes2Str(buf))
 }
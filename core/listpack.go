@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 )
 
@@ -37,9 +38,53 @@ func readListPackLength(buf []byte, cursor *int) int {
 	// list pack buf: [0, 4] -> total bytes, [4:6] -> entry count
 	size := int(binary.LittleEndian.Uint16(buf[start:end]))
 	*cursor += 6
+	if size == listPackUnknownLength {
+		size = countListPackEntries(buf, *cursor)
+	}
 	return size
 }
 
+// countListPackEntries walks entries from cursor until listPackEOF, like lpLength when the count in header is unknown.
+// It stops at the first broken entry, which will be reported when the entry is read.
+func countListPackEntries(buf []byte, cursor int) int {
+	count := 0
+	for cursor < len(buf) && buf[cursor] != listPackEOF {
+		header := buf[cursor]
+		var length int // length of encoding and content
+		switch {
+		case header>>7 == 0: // 0xxxxxxx, uint7
+			length = 1
+		case header>>6 == 2: // 10xxxxxx, string(len<=63)
+			length = 1 + int(header&0x3f)
+		case header>>5 == 6: // 110xxxxx yyyyyyyy, int13
+			length = 2
+		case header>>4 == 14: // 1110xxxx yyyyyyyy, string(len < 1<<12)
+			if cursor+1 >= len(buf) {
+				return count
+			}
+			length = 2 + (int(header&0x0f)<<8 | int(buf[cursor+1]))
+		case header == 0xf0: // 11110000 + 4 bytes length, string
+			if cursor+5 > len(buf) {
+				return count
+			}
+			length = 5 + int(binary.LittleEndian.Uint32(buf[cursor+1:cursor+5]))
+		case header == 0xf1: // int16
+			length = 3
+		case header == 0xf2: // int24
+			length = 4
+		case header == 0xf3: // int32
+			length = 5
+		case header == 0xf4: // int64
+			length = 9
+		default:
+			return count
+		}
+		cursor += length + int(getBackLen(uint32(length)))
+		count++
+	}
+	return count
+}
+
 func getBackLen(elementLen uint32) uint32 {
 	if elementLen <= 127 {
 		return 1
@@ -204,3 +249,116 @@ func (dec *Decoder) readListPackEntryAsInt(buf []byte, cursor *int) (int64, erro
 	}
 	return intval, nil
 }
+
+const (
+	listPackEOF = 0xff
+	// listPackUnknownLength is written in header if there are 65535 or more entries
+	listPackUnknownLength = 65535
+)
+
+// listPackBuilder builds listpack blob in the same layout as lpAppend of redis
+type listPackBuilder struct {
+	buf   []byte
+	count int
+}
+
+func newListPackBuilder() *listPackBuilder {
+	return &listPackBuilder{
+		buf: make([]byte, listPackHeaderSize, 64),
+	}
+}
+
+// appendString appends s in integer encoding if s is the canonical form of an int64 like lpStringToInt64,
+// otherwise in string encoding
+func (b *listPackBuilder) appendString(s string) {
+	if v, ok := listPackStringToInt(s); ok {
+		b.appendInt(v)
+		return
+	}
+	start := len(b.buf)
+	length := len(s)
+	if length < 64 {
+		// 10xxxxxx + content, string(len<=63)
+		b.buf = append(b.buf, byte(0x80|length))
+	} else if length < 4096 {
+		// 1110xxxx yyyyyyyy + content, string(len < 1<<12)
+		b.buf = append(b.buf, byte(0xE0|(length>>8)), byte(length&0xff))
+	} else {
+		// 11110000 aaaaaaaa bbbbbbbb cccccccc dddddddd + content, string(len < 1<<32)
+		b.buf = append(b.buf, 0xF0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b.buf[len(b.buf)-4:], uint32(length))
+	}
+	b.buf = append(b.buf, s...)
+	b.appendBacklen(len(b.buf) - start)
+}
+
+// appendInt appends v in the smallest integer encoding like lpEncodeIntegerGetType
+func (b *listPackBuilder) appendInt(v int64) {
+	start := len(b.buf)
+	if v >= 0 && v <= 127 {
+		// 0xxxxxxx, uint7
+		b.buf = append(b.buf, byte(v))
+	} else if v >= -4096 && v <= 4095 {
+		// 110xxxxx yyyyyyyy, int13
+		if v < 0 {
+			v += 1 << 13
+		}
+		b.buf = append(b.buf, byte(0xC0|(v>>8)), byte(v&0xff))
+	} else if v >= math.MinInt16 && v <= math.MaxInt16 {
+		// 11110001 aaaaaaaa bbbbbbbb, int16
+		b.buf = append(b.buf, 0xF1, byte(v), byte(v>>8))
+	} else if v >= -(1<<23) && v <= 1<<23-1 {
+		// 11110010 aaaaaaaa bbbbbbbb cccccccc, int24
+		b.buf = append(b.buf, 0xF2, byte(v), byte(v>>8), byte(v>>16))
+	} else if v >= math.MinInt32 && v <= math.MaxInt32 {
+		// 11110011 aaaaaaaa bbbbbbbb cccccccc dddddddd, int32
+		b.buf = append(b.buf, 0xF3, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	} else {
+		// 11110100 8Byte -> int64
+		b.buf = append(b.buf, 0xF4, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(b.buf[len(b.buf)-8:], uint64(v))
+	}
+	b.appendBacklen(len(b.buf) - start)
+}
+
+// appendBacklen appends length of the previous entry, which is read from right to left, like lpEncodeBacklen
+func (b *listPackBuilder) appendBacklen(l int) {
+	switch getBackLen(uint32(l)) {
+	case 1:
+		b.buf = append(b.buf, byte(l))
+	case 2:
+		b.buf = append(b.buf, byte(l>>7), byte(l&127|128))
+	case 3:
+		b.buf = append(b.buf, byte(l>>14), byte((l>>7)&127|128), byte(l&127|128))
+	case 4:
+		b.buf = append(b.buf, byte(l>>21), byte((l>>14)&127|128), byte((l>>7)&127|128), byte(l&127|128))
+	default:
+		b.buf = append(b.buf, byte(l>>28), byte((l>>21)&127|128), byte((l>>14)&127|128),
+			byte((l>>7)&127|128), byte(l&127|128))
+	}
+	b.count++
+}
+
+// bytes returns the listpack with header and end mark, builder should not be used after it
+func (b *listPackBuilder) bytes() []byte {
+	b.buf = append(b.buf, listPackEOF)
+	binary.LittleEndian.PutUint32(b.buf[0:4], uint32(len(b.buf)))
+	count := b.count
+	if count > listPackUnknownLength {
+		count = listPackUnknownLength
+	}
+	binary.LittleEndian.PutUint16(b.buf[4:6], uint16(count))
+	return b.buf
+}
+
+// listPackStringToInt returns whether s can be stored as integer in listpack, see string2ll of redis
+func listPackStringToInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > maxIntStringLen {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
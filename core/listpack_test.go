@@ -0,0 +1,202 @@
+package core
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// readRawListPack returns listpack blob of key written by redis, it finds the object by type and key
+func readRawListPack(t *testing.T, filename string, objType byte, key string) []byte {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := append([]byte{objType, byte(len(key))}, key...)
+	index := bytes.Index(data, prefix)
+	if index < 0 {
+		t.Fatalf("%s is not found in %s", key, filename)
+	}
+	blob, err := NewDecoder(bytes.NewReader(data[index+len(prefix):])).readString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+func TestListPackBuilder(t *testing.T) {
+	cases := []struct {
+		filename string
+		objType  byte
+		key      string
+	}{
+		{filename: "../cases/listpack.rdb", objType: typeZsetListPack, key: "z"},
+		{filename: "../cases/listpack.rdb", objType: typeHashListPack, key: "h"},
+		{filename: "../cases/set_listpack.rdb", objType: typeSetListPack, key: "s"},
+	}
+	dec := NewDecoder(nil)
+	for _, c := range cases {
+		expect := readRawListPack(t, c.filename, c.objType, c.key)
+		cursor := 0
+		size := readListPackLength(expect, &cursor)
+		lp := newListPackBuilder()
+		for i := 0; i < size; i++ {
+			entry, err := dec.readListPackEntryAsString(expect, &cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lp.appendString(string(entry))
+		}
+		if actual := lp.bytes(); !bytes.Equal(expect, actual) {
+			t.Errorf("%s: listpack differs from redis\nexpect %v\nactual %v", c.key, expect, actual)
+		}
+	}
+}
+
+func TestListPackBuilderRoundTrip(t *testing.T) {
+	values := []string{"", "a", "-", "+1", "01", "-0", "0", "127", "128", "-1", "-4096", "4095", "4096", "-4097",
+		strconv.Itoa(math.MinInt16), strconv.Itoa(math.MaxInt16 + 1), strconv.Itoa(-(1 << 23)), strconv.Itoa(1 << 23),
+		strconv.Itoa(math.MinInt32), strconv.Itoa(math.MaxInt32 + 1), strconv.FormatInt(math.MinInt64, 10),
+		strconv.FormatInt(math.MaxInt64, 10), "9223372036854775808", "1.5",
+		strings.Repeat("a", 63), strings.Repeat("a", 64), strings.Repeat("a", 4095), strings.Repeat("a", 4096),
+		RandString(20000), RandString(1 << 21)}
+	lp := newListPackBuilder()
+	for _, v := range values {
+		lp.appendString(v)
+	}
+	buf := lp.bytes()
+	if buf[len(buf)-1] != listPackEOF {
+		t.Error("listpack should end with EOF")
+	}
+	b := bytes.NewBuffer(nil)
+	if err := NewEncoder(b).writeNanString(unsafeBytes2Str(buf)); err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := NewDecoder(b).readListPack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(values) {
+		t.Fatalf("expect %d entries, actual %d", len(values), len(entries))
+	}
+	for i, v := range values {
+		if string(entries[i]) != v {
+			t.Errorf("entry %d: expect %.20s, actual %.20s", i, v, entries[i])
+		}
+	}
+}
+
+func TestWriteListPackObjects(t *testing.T) {
+	hash := map[string][]byte{"a": []byte("1"), "b": []byte("-20000"), "c": []byte("")}
+	zset := []*model.ZSetEntry{{Member: "b", Score: 2}, {Member: "a", Score: 2}, {Member: "c", Score: -1.5}}
+	set := [][]byte{[]byte("a"), []byte("1")}
+	intSet := [][]byte{[]byte("1"), []byte("2")}
+	bigSet := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	testCases := []struct {
+		version int
+		expect  map[string]string
+	}{
+		{version: 9, expect: map[string]string{"hash": model.ZipListEncoding, "zset": model.ZipListEncoding,
+			"set": model.SetEncoding, "intset": model.IntSetEncoding, "bigset": model.SetEncoding}},
+		{version: 10, expect: map[string]string{"hash": model.ListPackEncoding, "zset": model.ListPackEncoding,
+			"set": model.SetEncoding, "intset": model.IntSetEncoding, "bigset": model.SetEncoding}},
+		{version: 11, expect: map[string]string{"hash": model.ListPackEncoding, "zset": model.ListPackEncoding,
+			"set": model.ListPackEncoding, "intset": model.IntSetEncoding, "bigset": model.SetEncoding}},
+	}
+	for _, c := range testCases {
+		buf := bytes.NewBuffer(nil)
+		enc, err := NewEncoderWithVersion(buf, c.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc.SetSetListPackOpt(0, 2)
+		steps := []func() error{
+			enc.WriteHeader,
+			func() error { return enc.WriteDBHeader(0, 5, 0) },
+			func() error { return enc.WriteHashMapObject("hash", hash) },
+			func() error { return enc.WriteZSetObject("zset", zset) },
+			func() error { return enc.WriteSetObject("set", set) },
+			func() error { return enc.WriteSetObject("intset", intSet) },
+			func() error { return enc.WriteSetObject("bigset", bigSet) },
+			enc.WriteEnd,
+		}
+		for _, step := range steps {
+			if err = step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+			key := object.GetKey()
+			if c.expect[key] != object.GetEncoding() {
+				t.Errorf("version %d %s: expect encoding %s, actual %s", c.version, key, c.expect[key], object.GetEncoding())
+			}
+			switch o := object.(type) {
+			case *model.HashObject:
+				if len(o.Hash) != len(hash) {
+					t.Errorf("version %d: wrong hash %v", c.version, o.Hash)
+				}
+				for field, value := range hash {
+					if !bytes.Equal(o.Hash[field], value) {
+						t.Errorf("version %d: wrong value of field %s", c.version, field)
+					}
+				}
+			case *model.ZSetObject:
+				if c.version >= 10 { // listpack is ordered by score then member
+					if o.Entries[0].Member != "c" || o.Entries[1].Member != "a" || o.Entries[2].Member != "b" ||
+						o.Entries[0].Score != -1.5 || o.Entries[2].Score != 2 {
+						t.Errorf("version %d: wrong zset entries", c.version)
+					}
+				}
+			case *model.SetObject:
+				if o.GetElemCount() != 2 && key != "bigset" {
+					t.Errorf("version %d: wrong set members of %s", c.version, key)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf).SetHashListPackOpt(1, 0).SetZSetListPackOpt(0, 2)
+	steps := []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteDBHeader(0, 4, 0) },
+		func() error { return enc.WriteHashMapObject("hash", map[string][]byte{"a": []byte("10")}) },
+		func() error { return enc.WriteZSetObject("zset", zset) },
+		func() error {
+			return enc.WriteHashMapObject("hinted", map[string][]byte{"a": []byte("10")}, WithEncoding(model.ListPackEncoding))
+		},
+		func() error {
+			return enc.WriteHashMapObject("ziplist", map[string][]byte{"a": []byte("1")}, WithEncoding(model.ZipListEncoding))
+		},
+		enc.WriteEnd,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := map[string]string{
+		"hash":    model.HashEncoding,
+		"zset":    model.ZSet2Encoding,
+		"hinted":  model.ListPackEncoding,
+		"ziplist": model.ZipListEncoding,
+	}
+	err := NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+		if expect[object.GetKey()] != object.GetEncoding() {
+			t.Errorf("%s: expect encoding %s, actual %s", object.GetKey(), expect[object.GetKey()], object.GetEncoding())
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
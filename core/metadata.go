@@ -0,0 +1,401 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hdt3213/rdb/lzf"
+	"github.com/hdt3213/rdb/memprofiler"
+	"github.com/hdt3213/rdb/model"
+)
+
+const (
+	// maxIntStringLen is the max length of a string which may be an int64, like "-9223372036854775808"
+	maxIntStringLen = 20
+	// skipChunkSize is the size of buffer used to skip values
+	skipChunkSize = 4096
+
+	zipListHeaderSize  = 10 // <zlbytes><zltail><zllen>
+	listPackHeaderSize = 6  // <total_bytes><size>
+	intSetHeaderSize   = 8  // <encoding><length>
+)
+
+// WithMetadataOnly enables fast scan mode, Decoder skips values instead of reading them.
+// Objects are returned as model.MetadataObject which only has key, type, encoding, size, element count
+// and other metadata. Sizes are evaluated from lengths of values and headers of ziplist/listpack/intset,
+// so size of list encoded in ziplist or quicklist may differ slightly from the normal mode.
+// Streams, module types, zipmaps and listpacks with field expiration are still read entirely.
+func (dec *Decoder) WithMetadataOnly() *Decoder {
+	dec.metadataOnly = true
+	return dec
+}
+
+// skip discards next n bytes, they are still counted into checksum
+func (dec *Decoder) skip(n int) error {
+	if dec.skipBuffer == nil {
+		dec.skipBuffer = make([]byte, skipChunkSize)
+	}
+	for n > 0 {
+		chunk := dec.skipBuffer
+		if n < len(chunk) {
+			chunk = chunk[:n]
+		}
+		err := dec.readFull(chunk)
+		if err != nil {
+			return err
+		}
+		n -= len(chunk)
+	}
+	return nil
+}
+
+// skipString skips a string and returns its length and whether it is an integer
+func (dec *Decoder) skipString() (int, bool, error) {
+	length, special, err := dec.readSize()
+	if err != nil {
+		return 0, false, err
+	}
+	if special {
+		switch length {
+		case encodeInt8:
+			_, err = dec.readByte()
+			return 0, true, err
+		case encodeInt16:
+			_, err = dec.readInt16()
+			return 0, true, err
+		case encodeInt32:
+			_, err = dec.readInt32()
+			return 0, true, err
+		case encodeLZF:
+			inLen, _, err := dec.readSize()
+			if err != nil {
+				return 0, false, err
+			}
+			outLen, _, err := dec.readLength()
+			if err != nil {
+				return 0, false, err
+			}
+			return int(outLen), false, dec.skip(int(inLen))
+		default:
+			return 0, false, errors.New("Unknown string encode type ")
+		}
+	}
+	if length > maxIntStringLen {
+		return int(length), false, dec.skip(int(length))
+	}
+	// short strings are checked whether they are shared integers
+	buf := dec.skipBuffer
+	if buf == nil {
+		buf = make([]byte, skipChunkSize)
+		dec.skipBuffer = buf
+	}
+	buf = buf[:length]
+	err = dec.readFull(buf)
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = strconv.ParseInt(unsafeBytes2Str(buf), 10, 64)
+	return int(length), err == nil, nil
+}
+
+// skipLiteralFloat skips a float stored as string, see readLiteralFloat
+func (dec *Decoder) skipLiteralFloat() error {
+	first, err := dec.readByte()
+	if err != nil {
+		return err
+	}
+	if first >= 0xfd { // -inf, +inf or nan
+		return nil
+	}
+	return dec.skip(int(first))
+}
+
+// readBlobHeader reads a string holding ziplist/listpack/intset and returns its first n bytes and its length.
+// Only header of a LZF compressed blob is decompressed, unless whole is not nil and returns true for the header,
+// then the whole blob is returned, e.g. the number of entries in header is unknown.
+func (dec *Decoder) readBlobHeader(n int, whole func(header []byte) bool) ([]byte, int, error) {
+	length, special, err := dec.readSize()
+	if err != nil {
+		return nil, 0, err
+	}
+	if special {
+		if length != encodeLZF {
+			return nil, 0, fmt.Errorf("unexpected string encoding for blob: %d", length)
+		}
+		inLen, _, err := dec.readSize()
+		if err != nil {
+			return nil, 0, err
+		}
+		outLen, _, err := dec.readLength()
+		if err != nil {
+			return nil, 0, err
+		}
+		buf := make([]byte, inLen)
+		err = dec.readFull(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		header, err := lzf.DecompressPrefix(buf, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(header) < n {
+			return nil, 0, errors.New("blob is shorter than its header")
+		}
+		if whole != nil && whole(header) {
+			blob, err := lzf.Decompress(buf, int(inLen), int(outLen))
+			if err != nil {
+				return nil, 0, err
+			}
+			return blob, int(outLen), nil
+		}
+		return header, int(outLen), nil
+	}
+	if int(length) < n {
+		return nil, 0, errors.New("blob is shorter than its header")
+	}
+	header := make([]byte, n)
+	err = dec.readFull(header)
+	if err != nil {
+		return nil, 0, err
+	}
+	if whole != nil && whole(header) {
+		blob := make([]byte, length)
+		copy(blob, header)
+		return blob, int(length), dec.readFull(blob[n:])
+	}
+	return header, int(length), dec.skip(int(length) - n)
+}
+
+// skipZipList returns number of entries and length of a ziplist, entries are counted one by one
+// if there are 65535 or more entries
+func (dec *Decoder) skipZipList() (int, int, error) {
+	blob, length, err := dec.readBlobHeader(zipListHeaderSize, func(header []byte) bool {
+		return binary.LittleEndian.Uint16(header[8:10]) == zipListUnknownLength
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	cursor := 0
+	return readZipListLength(blob, &cursor), length, nil
+}
+
+// skipListPack returns number of entries and length of a listpack, entries are counted one by one
+// if there are 65535 or more entries
+func (dec *Decoder) skipListPack() (int, int, error) {
+	blob, length, err := dec.readBlobHeader(listPackHeaderSize, func(header []byte) bool {
+		return binary.LittleEndian.Uint16(header[4:6]) == listPackUnknownLength
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	cursor := 0
+	return readListPackLength(blob, &cursor), length, nil
+}
+
+// skipIntSet returns number of entries and length of an intset
+func (dec *Decoder) skipIntSet() (int, int, error) {
+	header, length, err := dec.readBlobHeader(intSetHeaderSize, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(binary.LittleEndian.Uint32(header[4:8])), length, nil
+}
+
+// skipStrings skips count elements each consisting of n strings, and adds them to sizer
+func (dec *Decoder) skipStrings(sizer *memprofiler.ElementSizer, count int, n int, skipScore func() error) error {
+	sizes := make([]int, n)
+	for i := 0; i < count; i++ {
+		for j := 0; j < n; j++ {
+			length, isInt, err := dec.skipString()
+			if err != nil {
+				return err
+			}
+			sizes[j] = memprofiler.SizeOfStringLen(length, isInt)
+		}
+		if skipScore != nil {
+			if err := skipScore(); err != nil {
+				return err
+			}
+		}
+		sizer.AddElement(sizes...)
+	}
+	return nil
+}
+
+// readObjectMetadata skips value of object and evaluates its size and element count
+func (dec *Decoder) readObjectMetadata(flag byte, base *model.BaseObject) (model.RedisObject, error) {
+	base.Encoding = encodingMap[int(flag)]
+	obj := &model.MetadataObject{BaseObject: base}
+	var sizer *memprofiler.ElementSizer
+	switch flag {
+	case typeString:
+		base.Type = model.StringType
+		length, isInt, err := dec.skipString()
+		if err != nil {
+			return nil, err
+		}
+		sizer = memprofiler.NewElementSizer(obj, 0)
+		sizer.AddRaw(memprofiler.SizeOfStringLen(length, isInt))
+	case typeList, typeSet, typeHash, typeZset, typeZset2:
+		var n = 1 // number of strings in an element
+		var skipScore func() error
+		switch flag {
+		case typeList:
+			base.Type = model.ListType
+		case typeSet:
+			base.Type = model.SetType
+		case typeHash:
+			base.Type = model.HashType
+			n = 2
+		case typeZset:
+			base.Type = model.ZSetType
+			skipScore = dec.skipLiteralFloat
+		case typeZset2:
+			base.Type = model.ZSetType
+			skipScore = func() error {
+				return dec.skip(8)
+			}
+		}
+		count, _, err := dec.readSize()
+		if err != nil {
+			return nil, err
+		}
+		obj.ElemCount = int(count)
+		sizer = memprofiler.NewElementSizer(obj, obj.ElemCount)
+		err = dec.skipStrings(sizer, obj.ElemCount, n, skipScore)
+		if err != nil {
+			return nil, err
+		}
+	case typeHashWithHfe, typeHashWithHfeRc:
+		base.Type = model.HashType
+		if !dec.valkey && flag == typeHashWithHfe {
+			_, err := dec.readInt64() // min expire
+			if err != nil {
+				return nil, err
+			}
+		}
+		count, _, err := dec.readSize()
+		if err != nil {
+			return nil, err
+		}
+		obj.ElemCount = int(count)
+		sizer = memprofiler.NewElementSizer(obj, obj.ElemCount)
+		var sizes [2]int
+		for i := 0; i < obj.ElemCount; i++ {
+			if !dec.valkey {
+				_, _, err = dec.readLength() // ttl
+				if err != nil {
+					return nil, err
+				}
+			}
+			for j := range sizes {
+				length, isInt, err := dec.skipString()
+				if err != nil {
+					return nil, err
+				}
+				sizes[j] = memprofiler.SizeOfStringLen(length, isInt)
+			}
+			if dec.valkey {
+				_, err = dec.readInt64() // expire
+				if err != nil {
+					return nil, err
+				}
+			}
+			sizer.AddElement(sizes[:]...)
+		}
+	case typeListZipList, typeHashZipList, typeZsetZipList:
+		count, length, err := dec.skipZipList()
+		if err != nil {
+			return nil, err
+		}
+		switch flag {
+		case typeListZipList:
+			base.Type = model.ListType
+			obj.ElemCount = count
+		case typeHashZipList:
+			base.Type = model.HashType
+			obj.ElemCount = count / 2
+		case typeZsetZipList:
+			base.Type = model.ZSetType
+			obj.ElemCount = count / 2
+		}
+		sizer = memprofiler.NewElementSizer(obj, obj.ElemCount)
+		sizer.AddRaw(length)
+	case typeHashListPack, typeZsetListPack, typeSetListPack:
+		count, length, err := dec.skipListPack()
+		if err != nil {
+			return nil, err
+		}
+		switch flag {
+		case typeHashListPack:
+			base.Type = model.HashType
+			obj.ElemCount = count / 2
+		case typeZsetListPack:
+			base.Type = model.ZSetType
+			obj.ElemCount = count / 2
+		case typeSetListPack:
+			base.Type = model.SetType
+			obj.ElemCount = count
+		}
+		sizer = memprofiler.NewElementSizer(obj, obj.ElemCount)
+		sizer.AddRaw(length)
+	case typeSetIntSet:
+		base.Type = model.SetType
+		count, length, err := dec.skipIntSet()
+		if err != nil {
+			return nil, err
+		}
+		obj.ElemCount = count
+		sizer = memprofiler.NewElementSizer(obj, obj.ElemCount)
+		sizer.AddRaw(length)
+	case typeListQuickList, typeListQuickList2:
+		base.Type = model.ListType
+		nodes, _, err := dec.readSize()
+		if err != nil {
+			return nil, err
+		}
+		sizer = memprofiler.NewElementSizer(obj, int(nodes))
+		for i := 0; i < int(nodes); i++ {
+			container := uint64(model.QuicklistNodeContainerPacked)
+			if flag == typeListQuickList2 {
+				container, _, err = dec.readLength()
+				if err != nil {
+					return nil, err
+				}
+			}
+			var count, length int
+			switch {
+			case container == model.QuicklistNodeContainerPlain:
+				length, _, err = dec.skipString()
+				count = 1
+			case flag == typeListQuickList:
+				count, length, err = dec.skipZipList()
+			case container == model.QuicklistNodeContainerPacked:
+				count, length, err = dec.skipListPack()
+			default:
+				err = errors.New("unknown quicklist node type")
+			}
+			if err != nil {
+				return nil, err
+			}
+			obj.ElemCount += count
+			sizer.AddNode(length, container == model.QuicklistNodeContainerPlain)
+		}
+	default:
+		// values which cannot be skipped without parsing
+		full, err := dec.readObject(flag, base)
+		if err != nil {
+			return nil, err
+		}
+		base.Size = memprofiler.SizeOfObject(full)
+		base.Type = full.GetType()
+		base.Extra = nil
+		obj.ElemCount = full.GetElemCount()
+		return obj, nil
+	}
+	base.Size = sizer.Size(obj)
+	return obj, nil
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func TestMetadataOnly(t *testing.T) {
+	files, err := filepath.Glob("../cases/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range files {
+		var expect []model.RedisObject
+		rdbFile, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewDecoder(rdbFile).Parse(func(object model.RedisObject) bool {
+			expect = append(expect, object)
+			return true
+		})
+		_ = rdbFile.Close()
+		if err != nil {
+			t.Errorf("parse %s failed: %v", filename, err)
+			continue
+		}
+
+		rdbFile, err = os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := 0
+		err = NewDecoder(rdbFile).WithMetadataOnly().Parse(func(object model.RedisObject) bool {
+			if i >= len(expect) {
+				t.Errorf("%s: unexpected key %s", filename, object.GetKey())
+				return false
+			}
+			origin := expect[i]
+			i++
+			if _, ok := object.(*model.MetadataObject); !ok {
+				t.Errorf("%s: %s is not a metadata object", filename, object.GetKey())
+			}
+			if origin.GetKey() != object.GetKey() || origin.GetType() != object.GetType() ||
+				origin.GetEncoding() != object.GetEncoding() {
+				t.Errorf("%s: expect %s(%s, %s), actual %s(%s, %s)", filename,
+					origin.GetKey(), origin.GetType(), origin.GetEncoding(),
+					object.GetKey(), object.GetType(), object.GetEncoding())
+			}
+			if origin.GetElemCount() != object.GetElemCount() {
+				t.Errorf("%s: %s expect elem count %d, actual %d", filename, origin.GetKey(), origin.GetElemCount(), object.GetElemCount())
+			}
+			switch {
+			case origin.GetType() == model.ListType && origin.GetEncoding() != model.ListEncoding &&
+				origin.GetEncoding() != model.QuickList2Encoding:
+				// ziplist is evaluated by raw length instead of values
+			default:
+				if origin.GetSize() != object.GetSize() {
+					t.Errorf("%s: %s expect size %d, actual %d", filename, origin.GetKey(), origin.GetSize(), object.GetSize())
+				}
+			}
+			return true
+		})
+		_ = rdbFile.Close()
+		if err != nil {
+			t.Errorf("parse metadata of %s failed: %v", filename, err)
+			continue
+		}
+		if i != len(expect) {
+			t.Errorf("%s: expect %d objects, actual %d", filename, len(expect), i)
+		}
+	}
+}
+
+func TestMetadataOnlyUnknownLength(t *testing.T) {
+	// ziplist and listpack store 65535 as number of entries if there are more entries
+	const count = 70000
+	values := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		values = append(values, []byte(strconv.Itoa(i)+"v"))
+	}
+	for _, compress := range []bool{false, true} {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoder(buf)
+		if compress {
+			enc.EnableCompress()
+		}
+		err := enc.WriteHeader()
+		if err == nil {
+			err = enc.WriteDBHeader(0, 2, 0)
+		}
+		if err == nil {
+			err = enc.WriteListObject("ziplist", values, WithEncoding(model.ZipListEncoding))
+		}
+		if err == nil {
+			err = enc.WriteSetObject("listpack", values, WithEncoding(model.ListPackEncoding))
+		}
+		if err == nil {
+			err = enc.WriteEnd()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		for _, metadataOnly := range []bool{false, true} {
+			dec := NewDecoder(bytes.NewReader(data))
+			if metadataOnly {
+				dec.WithMetadataOnly()
+			}
+			keys := 0
+			err = dec.Parse(func(object model.RedisObject) bool {
+				keys++
+				expectEncoding := model.ZipListEncoding
+				if object.GetKey() == "listpack" {
+					expectEncoding = model.ListPackEncoding
+				}
+				if object.GetEncoding() != expectEncoding {
+					t.Errorf("%s expect encoding %s, actual %s", object.GetKey(), expectEncoding, object.GetEncoding())
+				}
+				if object.GetElemCount() != count {
+					t.Errorf("metadata only %v, compress %v: %s expect %d elements, actual %d",
+						metadataOnly, compress, object.GetKey(), count, object.GetElemCount())
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys != 2 {
+				t.Errorf("expect 2 keys, actual %d", keys)
+			}
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package core
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
+
+	"github.com/hdt3213/rdb/model"
 )
 
 type Opcode uint8
@@ -28,6 +33,102 @@ type ModuleTypeHandler interface {
 	ReadLength() (uint64, bool, error)
 }
 
+// ModuleTypeWriter writes values in module serialization format, like RedisModule_SaveUnsigned and other
+// RedisModule_Save* functions. Values can be read by ModuleTypeHandler
+type ModuleTypeWriter interface {
+	WriteUInt(val uint64) error
+	WriteSInt(val int64) error
+	WriteFloat32(val float32) error
+	WriteDouble(val float64) error
+	WriteString(val []byte) error
+}
+
+type moduleTypeWriterImpl struct {
+	enc *Encoder
+}
+
+func (m moduleTypeWriterImpl) WriteUInt(val uint64) error {
+	err := m.enc.writeLength(uint64(ModuleOpcodeUInt))
+	if err != nil {
+		return err
+	}
+	return m.enc.writeLength(val)
+}
+
+func (m moduleTypeWriterImpl) WriteSInt(val int64) error {
+	err := m.enc.writeLength(uint64(ModuleOpcodeSInt))
+	if err != nil {
+		return err
+	}
+	return m.enc.writeLength(uint64(val))
+}
+
+func (m moduleTypeWriterImpl) WriteFloat32(val float32) error {
+	err := m.enc.writeLength(uint64(ModuleOpcodeFloat))
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(m.enc.buffer[:4], math.Float32bits(val))
+	return m.enc.write(m.enc.buffer[:4])
+}
+
+func (m moduleTypeWriterImpl) WriteDouble(val float64) error {
+	err := m.enc.writeLength(uint64(ModuleOpcodeDouble))
+	if err != nil {
+		return err
+	}
+	return m.enc.writeFloat64(val)
+}
+
+func (m moduleTypeWriterImpl) WriteString(val []byte) error {
+	err := m.enc.writeLength(uint64(ModuleOpcodeString))
+	if err != nil {
+		return err
+	}
+	return m.enc.writeString(unsafeBytes2Str(val))
+}
+
+// WriteModuleTypeObject writes a key of module type like rdb_save callback of the module, moduleType is name of the
+// module type in 9 characters, encVersion is encoding version of the module data. save writes the value,
+// the end mark is written by WriteModuleTypeObject. Module types require rdb version 8.
+func (enc *Encoder) WriteModuleTypeObject(key string, moduleType string, encVersion int,
+	save func(w ModuleTypeWriter) error, options ...interface{}) error {
+	err := enc.requireVersion(8, "module type")
+	if err != nil {
+		return err
+	}
+	moduleId, err := moduleTypeIDByName(moduleType, encVersion)
+	if err != nil {
+		return err
+	}
+	err = enc.beforeWriteObject(options...)
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{typeModule2})
+	if err != nil {
+		return err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(moduleId)
+	if err != nil {
+		return err
+	}
+	err = save(moduleTypeWriterImpl{enc: enc})
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(ModuleOpcodeEOF))
+	if err != nil {
+		return err
+	}
+	enc.state = writtenObjectState
+	return nil
+}
+
 type moduleTypeHandlerImpl struct {
 	dec *Decoder
 }
@@ -78,18 +179,137 @@ func (m moduleTypeHandlerImpl) ReadLength() (uint64, bool, error) {
 
 type ModuleTypeHandleFunc func(handler ModuleTypeHandler, encVersion int) (interface{}, error)
 
-func (dec *Decoder) readModuleType() (string, interface{}, error) {
+// ModuleValues is a list of values saved by RedisModule_Save* functions, elements are uint64, int64, float32,
+// float64 or []byte depending on their opcodes. It keeps module data which has no handler, and can be written back.
+type ModuleValues []interface{}
+
+// ReadModuleValues reads all values until module opcode EOF
+func ReadModuleValues(h ModuleTypeHandler) (ModuleValues, error) {
+	var values ModuleValues
+	for {
+		opcode, err := h.ReadOpcode()
+		if err != nil {
+			return nil, err
+		}
+		var val interface{}
+		switch opcode {
+		case ModuleOpcodeEOF:
+			return values, nil
+		case ModuleOpcodeSInt:
+			val, err = h.ReadSInt()
+		case ModuleOpcodeUInt:
+			val, err = h.ReadUInt()
+		case ModuleOpcodeFloat:
+			val, err = h.ReadFloat32()
+		case ModuleOpcodeDouble:
+			val, err = h.ReadDouble()
+		case ModuleOpcodeString:
+			val, err = h.ReadString()
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+}
+
+// readModuleValues is a ModuleTypeHandleFunc returns ModuleValues
+func readModuleValues(h ModuleTypeHandler, _ int) (interface{}, error) {
+	return ReadModuleValues(h)
+}
+
+// Save writes values with w, it can be passed to Encoder.WriteModuleAux and Encoder.WriteModuleTypeObject
+func (values ModuleValues) Save(w ModuleTypeWriter) error {
+	for i, val := range values {
+		var err error
+		switch v := val.(type) {
+		case uint64:
+			err = w.WriteUInt(v)
+		case int64:
+			err = w.WriteSInt(v)
+		case float32:
+			err = w.WriteFloat32(v)
+		case float64:
+			err = w.WriteDouble(v)
+		case []byte:
+			err = w.WriteString(v)
+		default:
+			err = fmt.Errorf("unsupported module value %T at %d", val, i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readModuleAux reads RDB_OPCODE_MODULE_AUX. It starts with module id and when (prefixed by opcode uint),
+// followed by data saved by aux_save of the module, which is decoded by handler registered with WithSpecialType.
+func (dec *Decoder) readModuleAux() (*model.ModuleAuxObject, error) {
 	moduleId, _, err := dec.readLength()
 	if err != nil {
-		return "", nil, err
+		return nil, err
+	}
+	whenOpcode, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if whenOpcode != uint64(ModuleOpcodeUInt) {
+		return nil, fmt.Errorf("invalid opcode of module aux when: %d", whenOpcode)
+	}
+	when, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	moduleType, val, err := dec.handleModuleType(moduleId)
+	if err != nil {
+		return nil, fmt.Errorf("read module aux %s failed: %v", moduleType, err)
+	}
+	obj := &model.ModuleAuxObject{
+		BaseObject: &model.BaseObject{},
+		ModuleType: moduleType,
+		When:       int(when),
+		EncVersion: int(moduleTypeEncVersionByID(moduleId)),
+		Value:      val,
+	}
+	obj.Key = moduleType
+	obj.Type = model.ModuleAuxType
+	return obj, nil
+}
+
+// readKeyMeta reads RDB_OPCODE_KEY_META of Redis 8+.
+// It starts with the number of metadata, each metadata is a class id (encoded like module id)
+// followed by a value serialized in module format. The value is decoded by handler registered with WithSpecialType.
+func (dec *Decoder) readKeyMeta() ([]*model.KeyMeta, error) {
+	count, _, err := dec.readSize()
+	if err != nil {
+		return nil, err
 	}
-	return dec.handleModuleType(moduleId)
+	metas := make([]*model.KeyMeta, 0, dec.capacity(count))
+	for i := uint64(0); i < count; i++ {
+		classId, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		class, val, err := dec.handleModuleType(classId)
+		if err != nil {
+			return nil, fmt.Errorf("read key meta %s failed: %v", class, err)
+		}
+		metas = append(metas, &model.KeyMeta{
+			Class:      class,
+			EncVersion: int(moduleTypeEncVersionByID(classId)),
+			Value:      val,
+		})
+	}
+	return metas, nil
 }
 
 func (dec *Decoder) handleModuleType(moduleId uint64) (string, interface{}, error) {
 	moduleType := moduleTypeNameByID(moduleId)
 	handler, found := dec.withSpecialTypes[moduleType]
-	if !found {
+	if !found && dec.withModuleValues {
+		handler = readModuleValues
+	} else if !found {
 		fmt.Printf("unknown module type: %s,will skip\n", moduleType)
 		handler = skipModuleAuxData
 	}
@@ -98,6 +318,25 @@ func (dec *Decoder) handleModuleType(moduleId uint64) (string, interface{}, erro
 	return moduleType, val, err
 }
 
+// moduleTypeIDByName is the reverse of moduleTypeNameByID and moduleTypeEncVersionByID
+func moduleTypeIDByName(moduleType string, encVersion int) (uint64, error) {
+	if len(moduleType) != 9 {
+		return 0, fmt.Errorf("illegal module type name %s: it should be 9 characters", moduleType)
+	}
+	if encVersion < 0 || encVersion > 1023 {
+		return 0, fmt.Errorf("illegal encoding version of module type %s: %d", moduleType, encVersion)
+	}
+	var moduleId uint64
+	for i := 0; i < len(moduleType); i++ {
+		index := strings.IndexByte(ModuleTypeNameCharSet, moduleType[i])
+		if index < 0 {
+			return 0, fmt.Errorf("illegal character %q in module type name %s", moduleType[i], moduleType)
+		}
+		moduleId = moduleId<<6 | uint64(index)
+	}
+	return moduleId<<10 | uint64(encVersion), nil
+}
+
 func moduleTypeNameByID(moduleId uint64) string {
 	cset := ModuleTypeNameCharSet
 	name := make([]byte, 9)
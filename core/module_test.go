@@ -3,8 +3,11 @@ package core
 import (
 	"bytes"
 	"fmt"
-	"github.com/hdt3213/rdb/model"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/hdt3213/rdb/model"
 )
 
 const testModuleType = "test-type"
@@ -229,3 +232,140 @@ func charCode(c uint8) uint8 {
 	}
 	panic(fmt.Errorf("unsupported char %c", c))
 }
+
+func TestKeyMeta(t *testing.T) {
+	for _, filename := range []string{"key_meta_12.rdb", "key_meta_13.rdb"} {
+		rdbFile, err := os.Open(filepath.Join("../cases", filename))
+		if err != nil {
+			t.Errorf("open rdb %s failed, %v", filename, err)
+			continue
+		}
+		expect := map[string]string{
+			"user:1":  "acme",
+			"user:2":  "",
+			"queue:1": "globex",
+		}
+		dec := NewDecoder(rdbFile).WithSpecialType("tenant-id",
+			func(h ModuleTypeHandler, encVersion int) (interface{}, error) {
+				if encVersion != 1 {
+					return nil, fmt.Errorf("invalid encoding version: %d", encVersion)
+				}
+				if _, err := h.ReadOpcode(); err != nil {
+					return nil, err
+				}
+				tenant, err := h.ReadString()
+				if err != nil {
+					return nil, err
+				}
+				if _, err := h.ReadOpcode(); err != nil {
+					return nil, err
+				}
+				return string(tenant), nil
+			})
+		count := 0
+		err = dec.Parse(func(o model.RedisObject) bool {
+			count++
+			metas := o.(model.KeyMetaInfo).GetMetadata()
+			expectTenant := expect[o.GetKey()]
+			if expectTenant == "" {
+				if len(metas) != 0 {
+					t.Errorf("%s: unexpected metadata of %s", filename, o.GetKey())
+				}
+				return true
+			}
+			if len(metas) != 1 || metas[0].Class != "tenant-id" || metas[0].Value != expectTenant {
+				t.Errorf("%s: wrong metadata of %s", filename, o.GetKey())
+			}
+			return true
+		})
+		_ = rdbFile.Close()
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+		}
+		if count != len(expect) {
+			t.Errorf("%s: expect %d objects, actual %d", filename, len(expect), count)
+		}
+	}
+}
+
+func TestModuleAux(t *testing.T) {
+	rdbFile, err := os.Open(filepath.Join("../cases", "module_aux.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	dec := NewDecoder(rdbFile).WithSpecialOpCode().WithSpecialType("ft-index0",
+		func(h ModuleTypeHandler, encVersion int) (interface{}, error) {
+			var values []uint64
+			for {
+				opcode, err := h.ReadOpcode()
+				if err != nil {
+					return nil, err
+				}
+				if opcode == ModuleOpcodeEOF {
+					return values, nil
+				}
+				if opcode != ModuleOpcodeUInt {
+					_, err = h.ReadString()
+					if err != nil {
+						return nil, err
+					}
+					continue
+				}
+				val, err := h.ReadUInt()
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, val)
+			}
+		})
+	var auxObjects []*model.ModuleAuxObject
+	var keys []string
+	err = dec.Parse(func(o model.RedisObject) bool {
+		switch obj := o.(type) {
+		case *model.ModuleAuxObject:
+			auxObjects = append(auxObjects, obj)
+		case *model.StringObject:
+			keys = append(keys, obj.Key)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "user:1" {
+		t.Errorf("wrong keys: %v", keys)
+	}
+	if len(auxObjects) != 2 {
+		t.Fatalf("expect 2 module aux objects, actual %d", len(auxObjects))
+	}
+	expectWhen := []int{model.ModuleAuxBeforeRDB, model.ModuleAuxAfterRDB}
+	expectValues := [][]uint64{{1}, {0}}
+	for i, obj := range auxObjects {
+		if obj.ModuleType != "ft-index0" || obj.GetType() != model.ModuleAuxType || obj.EncVersion != 2 {
+			t.Errorf("wrong module aux object: %+v", obj)
+		}
+		if obj.When != expectWhen[i] {
+			t.Errorf("expect when %d, actual %d", expectWhen[i], obj.When)
+		}
+		values, _ := obj.Value.([]uint64)
+		if len(values) != 1 || values[0] != expectValues[i][0] {
+			t.Errorf("wrong value of module aux: %v", obj.Value)
+		}
+	}
+
+	// module aux data is consumed but not returned without WithSpecialOpCode
+	_, _ = rdbFile.Seek(0, 0)
+	dec = NewDecoder(rdbFile)
+	err = dec.Parse(func(o model.RedisObject) bool {
+		if _, ok := o.(*model.ModuleAuxObject); ok {
+			t.Error("unexpected module aux object")
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
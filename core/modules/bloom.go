@@ -0,0 +1,309 @@
+package modules
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hdt3213/rdb/core"
+)
+
+const (
+	bloomMinOptionsEncVersion = 2 // since this version, options and n2 are saved
+	bloomMinGrowthEncVersion  = 4 // since this version, growth is saved
+	bloomDefaultGrowth        = 2
+
+	cuckooMinExpansionEncVersion = 4 // since this version, sub filters may have different number of buckets
+	cuckooDefaultBucketSize      = 2
+	cuckooDefaultMaxIterations   = 20
+	cuckooDefaultExpansion       = 1
+
+	topKHeapBucketSize = 24 // struct HeapBucket { uint32_t fp; uint32_t itemlen; char *item; uint32_t count; }
+	topKBucketSize     = 8  // struct Bucket { uint32_t fp; uint32_t count; }
+)
+
+// BloomFilter is a scalable bloom filter of RedisBloom, it is a chain of filters
+type BloomFilter struct {
+	Size    uint64       `json:"size"` // Size is number of items added
+	Options uint64       `json:"options"`
+	Growth  uint64       `json:"growth"`
+	Filters []*BloomLink `json:"filters"`
+}
+
+// BloomLink is a filter in the chain of BloomFilter
+type BloomLink struct {
+	Capacity     uint64  `json:"capacity"`
+	ErrorRate    float64 `json:"errorRate"`
+	Hashes       uint64  `json:"hashes"`
+	BitsPerEntry float64 `json:"bitsPerEntry"`
+	Bits         uint64  `json:"bits"`
+	N2           uint64  `json:"n2"`
+	Size         uint64  `json:"size"` // Size is number of items added into this filter
+	Data         []byte  `json:"-"`    // Data is the bit array
+}
+
+// MemorySize evaluates memory used by the filter
+func (bf *BloomFilter) MemorySize() int {
+	size := 32
+	for _, link := range bf.Filters {
+		size += 64 + len(link.Data)
+	}
+	return size
+}
+
+// ReadBloomFilter decodes MBbloom--
+func ReadBloomFilter(h core.ModuleTypeHandler, encVersion int) (interface{}, error) {
+	if err := checkEncVersion(BloomType, encVersion, 4); err != nil {
+		return nil, err
+	}
+	v, err := readValues(h)
+	if err != nil {
+		return nil, err
+	}
+	bf := &BloomFilter{
+		Growth: bloomDefaultGrowth,
+	}
+	if bf.Size, err = v.uint(); err != nil {
+		return nil, err
+	}
+	count, err := v.uint()
+	if err != nil {
+		return nil, err
+	}
+	if encVersion >= bloomMinOptionsEncVersion {
+		if bf.Options, err = v.uint(); err != nil {
+			return nil, err
+		}
+	}
+	if encVersion >= bloomMinGrowthEncVersion {
+		if bf.Growth, err = v.uint(); err != nil {
+			return nil, err
+		}
+	}
+	for i := uint64(0); i < count; i++ {
+		link := &BloomLink{}
+		if link.Capacity, err = v.uint(); err != nil {
+			return nil, err
+		}
+		if link.ErrorRate, err = v.double(); err != nil {
+			return nil, err
+		}
+		if link.Hashes, err = v.uint(); err != nil {
+			return nil, err
+		}
+		if link.BitsPerEntry, err = v.double(); err != nil {
+			return nil, err
+		}
+		if encVersion == 0 {
+			link.Bits = uint64(float64(link.Capacity) * link.BitsPerEntry)
+		} else if link.Bits, err = v.uint(); err != nil {
+			return nil, err
+		}
+		if encVersion >= bloomMinOptionsEncVersion {
+			if link.N2, err = v.uint(); err != nil {
+				return nil, err
+			}
+		}
+		if link.Data, err = v.bytes(); err != nil {
+			return nil, err
+		}
+		if link.Size, err = v.uint(); err != nil {
+			return nil, err
+		}
+		bf.Filters = append(bf.Filters, link)
+	}
+	return bf, v.end()
+}
+
+// CuckooFilter is a cuckoo filter of RedisBloom, it consists of sub filters
+type CuckooFilter struct {
+	NumBuckets    uint64       `json:"numBuckets"`
+	NumItems      uint64       `json:"numItems"`
+	NumDeletes    uint64       `json:"numDeletes"`
+	BucketSize    uint64       `json:"bucketSize"`
+	MaxIterations uint64       `json:"maxIterations"`
+	Expansion     uint64       `json:"expansion"`
+	Filters       []*SubCuckoo `json:"filters"`
+}
+
+// SubCuckoo is a sub filter of CuckooFilter
+type SubCuckoo struct {
+	NumBuckets uint64 `json:"numBuckets"`
+	Data       []byte `json:"-"` // Data is buckets of fingerprints
+}
+
+// MemorySize evaluates memory used by the filter
+func (cf *CuckooFilter) MemorySize() int {
+	size := 64
+	for _, filter := range cf.Filters {
+		size += 16 + len(filter.Data)
+	}
+	return size
+}
+
+// ReadCuckooFilter decodes MBbloomCF
+func ReadCuckooFilter(h core.ModuleTypeHandler, encVersion int) (interface{}, error) {
+	if err := checkEncVersion(CuckooType, encVersion, 4); err != nil {
+		return nil, err
+	}
+	v, err := readValues(h)
+	if err != nil {
+		return nil, err
+	}
+	cf := &CuckooFilter{
+		BucketSize:    cuckooDefaultBucketSize,
+		MaxIterations: cuckooDefaultMaxIterations,
+		Expansion:     cuckooDefaultExpansion,
+	}
+	count, err := v.uint()
+	if err != nil {
+		return nil, err
+	}
+	if cf.NumBuckets, err = v.uint(); err != nil {
+		return nil, err
+	}
+	if cf.NumItems, err = v.uint(); err != nil {
+		return nil, err
+	}
+	if encVersion >= cuckooMinExpansionEncVersion {
+		for _, field := range []*uint64{&cf.NumDeletes, &cf.BucketSize, &cf.MaxIterations, &cf.Expansion} {
+			if *field, err = v.uint(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := uint64(0); i < count; i++ {
+		filter := &SubCuckoo{
+			NumBuckets: cf.NumBuckets,
+		}
+		if encVersion >= cuckooMinExpansionEncVersion {
+			if filter.NumBuckets, err = v.uint(); err != nil {
+				return nil, err
+			}
+		}
+		if filter.Data, err = v.bytes(); err != nil {
+			return nil, err
+		}
+		cf.Filters = append(cf.Filters, filter)
+	}
+	return cf, v.end()
+}
+
+// CountMinSketch is a count-min sketch of RedisBloom
+type CountMinSketch struct {
+	Width    uint64     `json:"width"`
+	Depth    uint64     `json:"depth"`
+	Count    uint64     `json:"count"` // Count is the total count of items
+	Counters [][]uint32 `json:"-"`     // Counters has Depth rows, each row has Width counters
+}
+
+// MemorySize evaluates memory used by the sketch
+func (cms *CountMinSketch) MemorySize() int {
+	return 32 + int(cms.Width*cms.Depth)*4
+}
+
+// ReadCountMinSketch decodes CMSk-TYPE
+func ReadCountMinSketch(h core.ModuleTypeHandler, encVersion int) (interface{}, error) {
+	if err := checkEncVersion(CountMinSketchType, encVersion, 0); err != nil {
+		return nil, err
+	}
+	v, err := readValues(h)
+	if err != nil {
+		return nil, err
+	}
+	cms := &CountMinSketch{}
+	for _, field := range []*uint64{&cms.Width, &cms.Depth, &cms.Count} {
+		if *field, err = v.uint(); err != nil {
+			return nil, err
+		}
+	}
+	data, err := v.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != cms.Width*cms.Depth*4 {
+		return nil, fmt.Errorf("count-min sketch %dx%d has %d bytes of counters", cms.Width, cms.Depth, len(data))
+	}
+	for i := uint64(0); i < cms.Depth; i++ {
+		row := make([]uint32, cms.Width)
+		for j := range row {
+			row[j] = binary.LittleEndian.Uint32(data[(i*cms.Width+uint64(j))*4:])
+		}
+		cms.Counters = append(cms.Counters, row)
+	}
+	return cms, v.end()
+}
+
+// TopK is a top-k of RedisBloom
+type TopK struct {
+	K     uint64      `json:"k"`
+	Width uint64      `json:"width"`
+	Depth uint64      `json:"depth"`
+	Decay float64     `json:"decay"`
+	Items []*TopKItem `json:"items"` // Items are the heap of top k items, empty slots are omitted
+	Data  []byte      `json:"-"`     // Data is the buckets of sketch
+}
+
+// TopKItem is an item in TopK
+type TopKItem struct {
+	Item  string `json:"item"`
+	Count uint32 `json:"count"`
+}
+
+// MemorySize evaluates memory used by the top-k
+func (topK *TopK) MemorySize() int {
+	size := 48 + len(topK.Data) + int(topK.K)*topKHeapBucketSize
+	for _, item := range topK.Items {
+		size += len(item.Item)
+	}
+	return size
+}
+
+// ReadTopK decodes TopK-TYPE
+func ReadTopK(h core.ModuleTypeHandler, encVersion int) (interface{}, error) {
+	if err := checkEncVersion(TopKType, encVersion, 1); err != nil {
+		return nil, err
+	}
+	v, err := readValues(h)
+	if err != nil {
+		return nil, err
+	}
+	topK := &TopK{}
+	for _, field := range []*uint64{&topK.K, &topK.Width, &topK.Depth} {
+		if *field, err = v.uint(); err != nil {
+			return nil, err
+		}
+	}
+	if topK.Decay, err = v.double(); err != nil {
+		return nil, err
+	}
+	if topK.Data, err = v.bytes(); err != nil {
+		return nil, err
+	}
+	if uint64(len(topK.Data)) != topK.Width*topK.Depth*topKBucketSize {
+		return nil, fmt.Errorf("top-k %dx%d has %d bytes of buckets", topK.Width, topK.Depth, len(topK.Data))
+	}
+	heap, err := v.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(heap)) != topK.K*topKHeapBucketSize {
+		return nil, fmt.Errorf("top-k of %d items has %d bytes of heap", topK.K, len(heap))
+	}
+	topK.Items = make([]*TopKItem, 0)
+	for i := uint64(0); i < topK.K; i++ {
+		bucket := heap[i*topKHeapBucketSize:]
+		itemLen := binary.LittleEndian.Uint32(bucket[4:8])
+		if itemLen == 0 {
+			continue
+		}
+		item, err := v.string()
+		if err != nil {
+			return nil, err
+		}
+		topK.Items = append(topK.Items, &TopKItem{
+			Item:  item,
+			Count: binary.LittleEndian.Uint32(bucket[16:20]),
+		})
+	}
+	return topK, v.end()
+}
@@ -0,0 +1,169 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/hdt3213/rdb/core"
+)
+
+// JSON is a document of RedisJSON
+type JSON struct {
+	Raw string // Raw is the serialized document
+}
+
+// MarshalJSON returns the document itself
+func (j *JSON) MarshalJSON() ([]byte, error) {
+	return []byte(j.Raw), nil
+}
+
+// MemorySize evaluates memory used by the document in RedisJSON
+func (j *JSON) MemorySize() int {
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewBufferString(j.Raw))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return len(j.Raw)
+	}
+	return sizeOfJSONNode(doc)
+}
+
+func sizeOfJSONNode(node interface{}) int {
+	const pointerSize = 8
+	const headerSize = 16
+	switch n := node.(type) {
+	case string:
+		return headerSize + len(n)
+	case json.Number:
+		return headerSize
+	case []interface{}:
+		size := headerSize + pointerSize*len(n)
+		for _, child := range n {
+			size += sizeOfJSONNode(child)
+		}
+		return size
+	case map[string]interface{}:
+		size := headerSize
+		for key, child := range n {
+			size += headerSize + len(key) + pointerSize + sizeOfJSONNode(child)
+		}
+		return size
+	}
+	return pointerSize // null and bool
+}
+
+// node types of RedisJSON 1.x, used in encoding version 0
+const (
+	jsonNodeNull    = 1
+	jsonNodeString  = 2
+	jsonNodeNumber  = 4
+	jsonNodeInteger = 8
+	jsonNodeBoolean = 16
+	jsonNodeDict    = 32
+	jsonNodeArray   = 64
+	jsonNodeKeyVal  = 128
+)
+
+// ReadJSON decodes ReJSON-RL. Since encoding version 2 the document is saved as a json string,
+// while version 0 saves it as a tree of nodes.
+func ReadJSON(h core.ModuleTypeHandler, encVersion int) (interface{}, error) {
+	if err := checkEncVersion(JSONType, encVersion, 3); err != nil {
+		return nil, err
+	}
+	v, err := readValues(h)
+	if err != nil {
+		return nil, err
+	}
+	if encVersion == 0 {
+		node, err := readJSONNode(v)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(node)
+		if err != nil {
+			return nil, err
+		}
+		return &JSON{Raw: string(raw)}, v.end()
+	}
+	raw, err := v.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(raw) {
+		return nil, errors.New("invalid json document")
+	}
+	return &JSON{Raw: string(raw)}, v.end()
+}
+
+// readJSONNode reads a node of RedisJSON 1.x, objects are converted to json.Marshal friendly values
+func readJSONNode(v *values) (interface{}, error) {
+	nodeType, err := v.uint()
+	if err != nil {
+		return nil, err
+	}
+	switch nodeType {
+	case jsonNodeNull:
+		return nil, nil
+	case jsonNodeBoolean:
+		b, err := v.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return len(b) > 0 && b[0] == '1', nil
+	case jsonNodeInteger:
+		return v.sint()
+	case jsonNodeNumber:
+		f, err := v.double()
+		if err != nil {
+			return nil, err
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("illegal json number: %v", f)
+		}
+		return f, nil
+	case jsonNodeString:
+		return v.string()
+	case jsonNodeDict:
+		length, err := v.uint()
+		if err != nil {
+			return nil, err
+		}
+		dict := make(map[string]interface{})
+		for i := uint64(0); i < length; i++ {
+			keyValType, err := v.uint()
+			if err != nil {
+				return nil, err
+			}
+			if keyValType != jsonNodeKeyVal {
+				return nil, fmt.Errorf("expect json key-value node, actual %d", keyValType)
+			}
+			key, err := v.string()
+			if err != nil {
+				return nil, err
+			}
+			dict[key], err = readJSONNode(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	case jsonNodeArray:
+		length, err := v.uint()
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, 0)
+		for i := uint64(0); i < length; i++ {
+			node, err := readJSONNode(v)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, node)
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("unknown json node type: %d", nodeType)
+}
@@ -0,0 +1,135 @@
+// Package modules provides handlers decoding data types of common redis modules,
+// register them with Register or core.Decoder.WithSpecialType
+package modules
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hdt3213/rdb/core"
+)
+
+// Names of module types
+const (
+	JSONType           = "ReJSON-RL" // RedisJSON
+	BloomType          = "MBbloom--" // bloom filter of RedisBloom
+	CuckooType         = "MBbloomCF" // cuckoo filter of RedisBloom
+	CountMinSketchType = "CMSk-TYPE" // count-min sketch of RedisBloom
+	TopKType           = "TopK-TYPE" // top-k of RedisBloom
+	TimeSeriesType     = "TSDB-TYPE" // RedisTimeSeries
+)
+
+// Handlers maps module type names to their handlers
+var Handlers = map[string]core.ModuleTypeHandleFunc{
+	JSONType:           ReadJSON,
+	BloomType:          ReadBloomFilter,
+	CuckooType:         ReadCuckooFilter,
+	CountMinSketchType: ReadCountMinSketch,
+	TopKType:           ReadTopK,
+	TimeSeriesType:     ReadTimeSeries,
+}
+
+// Register registers all handlers of this package to dec
+func Register(dec *core.Decoder) *core.Decoder {
+	for moduleType, handler := range Handlers {
+		dec.WithSpecialType(moduleType, handler)
+	}
+	return dec
+}
+
+// values is a list of values saved by RedisModule_Save* functions, each value is prefixed by its opcode
+type values struct {
+	list []interface{} // elements are uint64, int64, float32, float64 or []byte
+	pos  int
+}
+
+// readValues reads all values until module opcode EOF
+func readValues(h core.ModuleTypeHandler) (*values, error) {
+	list, err := core.ReadModuleValues(h)
+	if err != nil {
+		return nil, err
+	}
+	return &values{list: list}, nil
+}
+
+func (v *values) remaining() int {
+	return len(v.list) - v.pos
+}
+
+func (v *values) next() (interface{}, error) {
+	if v.pos >= len(v.list) {
+		return nil, errors.New("unexpected end of module value")
+	}
+	val := v.list[v.pos]
+	v.pos++
+	return val, nil
+}
+
+func (v *values) uint() (uint64, error) {
+	val, err := v.next()
+	if err != nil {
+		return 0, err
+	}
+	u, ok := val.(uint64)
+	if !ok {
+		return 0, fmt.Errorf("expect unsigned int at %d, actual %T", v.pos-1, val)
+	}
+	return u, nil
+}
+
+func (v *values) sint() (int64, error) {
+	val, err := v.next()
+	if err != nil {
+		return 0, err
+	}
+	i, ok := val.(int64)
+	if !ok {
+		return 0, fmt.Errorf("expect signed int at %d, actual %T", v.pos-1, val)
+	}
+	return i, nil
+}
+
+func (v *values) double() (float64, error) {
+	val, err := v.next()
+	if err != nil {
+		return 0, err
+	}
+	f, ok := val.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expect double at %d, actual %T", v.pos-1, val)
+	}
+	return f, nil
+}
+
+func (v *values) bytes() ([]byte, error) {
+	val, err := v.next()
+	if err != nil {
+		return nil, err
+	}
+	b, ok := val.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("expect string at %d, actual %T", v.pos-1, val)
+	}
+	return b, nil
+}
+
+func (v *values) string() (string, error) {
+	b, err := v.bytes()
+	return string(b), err
+}
+
+// end returns error if there are values not consumed
+func (v *values) end() error {
+	if v.remaining() > 0 {
+		return fmt.Errorf("%d unexpected values at end of module value", v.remaining())
+	}
+	return nil
+}
+
+// checkEncVersion returns error if encVersion is newer than max
+func checkEncVersion(moduleType string, encVersion int, max int) error {
+	if encVersion > max {
+		return fmt.Errorf("unsupported encoding version of %s: %d", moduleType, encVersion)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package modules
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+func parseModuleObjects(t *testing.T, filename string) map[string]interface{} {
+	rdbFile, err := os.Open(filepath.Join("../../cases", filename))
+	if err != nil {
+		t.Fatalf("open %s failed: %v", filename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	result := make(map[string]interface{})
+	dec := Register(core.NewDecoder(rdbFile))
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if moduleObj, ok := object.(*model.ModuleTypeObject); ok {
+			result[moduleObj.Key] = moduleObj.Value
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("parse %s failed: %v", filename, err)
+	}
+	return result
+}
+
+func TestJSON(t *testing.T) {
+	objects := parseModuleObjects(t, "rejson.rdb")
+	expect := map[string]string{
+		"json:user":   `{"name":"alice","age":30,"tags":["admin","dev"],"address":{"city":"Paris","zip":"75001"},"active":true,"score":9.5,"nick":null}`,
+		"json:list":   `[1,2,3,"four",[5]]`,
+		"json:legacy": `{"a":1,"b":[true,"x",1.5,null]}`,
+	}
+	if len(objects) != len(expect) {
+		t.Errorf("expect %d documents, actual %d", len(expect), len(objects))
+	}
+	for key, raw := range expect {
+		doc, ok := objects[key].(*JSON)
+		if !ok {
+			t.Errorf("%s is not a json document: %T", key, objects[key])
+			continue
+		}
+		if doc.Raw != raw {
+			t.Errorf("wrong document of %s: %s", key, doc.Raw)
+		}
+		if doc.MemorySize() <= len(doc.Raw) {
+			t.Errorf("memory size of %s is too small: %d", key, doc.MemorySize())
+		}
+	}
+}
+
+func TestRedisBloom(t *testing.T) {
+	objects := parseModuleObjects(t, "redisbloom.rdb")
+
+	bf, ok := objects["bf:users"].(*BloomFilter)
+	if !ok {
+		t.Fatalf("bf:users is not a bloom filter: %T", objects["bf:users"])
+	}
+	if bf.Size != 3 || bf.Growth != 2 || len(bf.Filters) != 1 {
+		t.Errorf("wrong bloom filter: %+v", bf)
+	} else if link := bf.Filters[0]; link.Capacity != 100 || link.ErrorRate != 0.01 || link.Hashes != 7 ||
+		link.Bits != 958 || len(link.Data) != 120 {
+		t.Errorf("wrong bloom link: %+v", link)
+	}
+
+	cf, ok := objects["cf:items"].(*CuckooFilter)
+	if !ok {
+		t.Fatalf("cf:items is not a cuckoo filter: %T", objects["cf:items"])
+	}
+	if cf.NumBuckets != 64 || cf.NumItems != 2 || cf.BucketSize != 2 || len(cf.Filters) != 1 ||
+		len(cf.Filters[0].Data) != 128 {
+		t.Errorf("wrong cuckoo filter: %+v", cf)
+	}
+
+	cms, ok := objects["cms:clicks"].(*CountMinSketch)
+	if !ok {
+		t.Fatalf("cms:clicks is not a count-min sketch: %T", objects["cms:clicks"])
+	}
+	expectCounters := [][]uint32{{3, 0, 2, 0}, {0, 2, 3, 0}}
+	if cms.Width != 4 || cms.Depth != 2 || cms.Count != 5 || len(cms.Counters) != len(expectCounters) {
+		t.Fatalf("wrong count-min sketch: %+v", cms)
+	}
+	for i, row := range expectCounters {
+		for j, counter := range row {
+			if cms.Counters[i][j] != counter {
+				t.Errorf("wrong counter at [%d][%d]: %d", i, j, cms.Counters[i][j])
+			}
+		}
+	}
+
+	topK, ok := objects["topk:words"].(*TopK)
+	if !ok {
+		t.Fatalf("topk:words is not a top-k: %T", objects["topk:words"])
+	}
+	if topK.K != 3 || topK.Decay != 0.9 || len(topK.Items) != 2 {
+		t.Fatalf("wrong top-k: %+v", topK)
+	}
+	if topK.Items[0].Item != "foo" || topK.Items[0].Count != 2 || topK.Items[1].Item != "bar" || topK.Items[1].Count != 1 {
+		t.Errorf("wrong items of top-k: %+v %+v", topK.Items[0], topK.Items[1])
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	objects := parseModuleObjects(t, "timeseries.rdb")
+
+	ts, ok := objects["ts:temp"].(*TimeSeries)
+	if !ok {
+		t.Fatalf("ts:temp is not a time series: %T", objects["ts:temp"])
+	}
+	if ts.RetentionTime != 86400000 || ts.TotalSamples != 3 || ts.Labels["room"] != "kitchen" || ts.Labels["sensor"] != "1" {
+		t.Errorf("wrong time series: %+v", ts)
+	}
+	if len(ts.Rules) != 1 {
+		t.Fatalf("expect 1 rule, actual %d", len(ts.Rules))
+	}
+	if rule := ts.Rules[0]; rule.DestKey != "ts:temp:avg" || rule.Aggregation != "avg" || rule.BucketDuration != 60000 ||
+		len(rule.Context) != 3 {
+		t.Errorf("wrong rule: %+v", rule)
+	}
+	if len(ts.Chunks) != 1 || len(ts.Chunks[0].Samples) != 3 {
+		t.Fatalf("wrong chunks: %+v", ts.Chunks)
+	}
+	if sample := ts.Chunks[0].Samples[2]; sample.Timestamp != 1718000120000 || sample.Value != 22.5 {
+		t.Errorf("wrong sample: %+v", sample)
+	}
+
+	compacted, ok := objects["ts:temp:avg"].(*TimeSeries)
+	if !ok {
+		t.Fatalf("ts:temp:avg is not a time series: %T", objects["ts:temp:avg"])
+	}
+	if compacted.SrcKey != "ts:temp" || len(compacted.Chunks) != 1 || !compacted.Chunks[0].Compressed ||
+		compacted.Chunks[0].Count != 2 {
+		t.Errorf("wrong compacted time series: %+v", compacted)
+	}
+}
+
+func TestUnsupportedEncVersion(t *testing.T) {
+	data, err := os.ReadFile("../../cases/rejson.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// module id follows the key, it is a 64 bit length and its lowest 10 bits are encoding version
+	pos := bytes.Index(data, []byte("json:user")) + len("json:user") + 8
+	data[pos] |= 0x3f
+	dec := Register(core.NewDecoder(bytes.NewReader(data)))
+	err = dec.Parse(func(object model.RedisObject) bool {
+		return true
+	})
+	if err == nil || !strings.Contains(err.Error(), "unsupported encoding version of ReJSON-RL") {
+		t.Errorf("expect unsupported encoding version error, actual %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package modules
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/hdt3213/rdb/core"
+)
+
+const (
+	tsMinChunkEncVersion     = 2 // since this version, samples are saved in chunks
+	tsMinDuplicateEncVersion = 3 // since this version, duplicate policy is saved
+	tsMinAlignmentEncVersion = 6 // since this version, timestamp alignment of rules is saved
+	tsMinIgnoreEncVersion    = 7 // since this version, ignore max time diff and max value diff are saved
+	tsMaxEncVersion          = 7
+
+	tsOptionUncompressed = 0x1
+
+	tsSampleSize = 16 // struct Sample { uint64 timestamp; double value; }
+
+	tsUncompressedChunkValues = 4  // base timestamp, number of samples, size, samples
+	tsCompressedChunkValues   = 11 // size, count, idx, base value, base timestamp, prev timestamp, prev delta, ...
+)
+
+// tsAggregations are names of aggregation types, indexed by TS_AGG_*
+var tsAggregations = []string{"none", "min", "max", "sum", "avg", "count", "first", "last", "range",
+	"std.p", "std.s", "var.p", "var.s", "twa"}
+
+// TimeSeries is a time series of RedisTimeSeries
+type TimeSeries struct {
+	Key               string             `json:"key"`
+	RetentionTime     uint64             `json:"retentionTime"`
+	ChunkSize         uint64             `json:"chunkSize"`
+	Options           uint64             `json:"options"`
+	DuplicatePolicy   uint64             `json:"duplicatePolicy"`
+	IgnoreMaxTimeDiff uint64             `json:"ignoreMaxTimeDiff,omitempty"`
+	IgnoreMaxValDiff  float64            `json:"ignoreMaxValDiff,omitempty"`
+	SrcKey            string             `json:"srcKey,omitempty"` // SrcKey is the source of compaction rule
+	LastTimestamp     uint64             `json:"lastTimestamp"`
+	LastValue         float64            `json:"lastValue"`
+	TotalSamples      uint64             `json:"totalSamples"`
+	Labels            map[string]string  `json:"labels"`
+	Rules             []*CompactionRule  `json:"rules,omitempty"`
+	Chunks            []*TimeSeriesChunk `json:"chunks"`
+}
+
+// CompactionRule aggregates samples into DestKey
+type CompactionRule struct {
+	DestKey            string        `json:"destKey"`
+	BucketDuration     uint64        `json:"bucketDuration"`
+	TimestampAlignment uint64        `json:"timestampAlignment"`
+	Aggregation        string        `json:"aggregation"`
+	StartBucket        uint64        `json:"startBucket"`
+	Context            []interface{} `json:"-"` // Context is the state of aggregation
+}
+
+// TimeSeriesChunk is a chunk of samples
+type TimeSeriesChunk struct {
+	Compressed    bool      `json:"compressed"`
+	BaseTimestamp uint64    `json:"baseTimestamp"`
+	Count         uint64    `json:"count"`
+	Size          uint64    `json:"size"`              // Size is bytes allocated for the chunk
+	Samples       []*Sample `json:"samples,omitempty"` // Samples are only decoded from uncompressed chunks
+	Data          []byte    `json:"-"`
+}
+
+// Sample is a data point of time series
+type Sample struct {
+	Timestamp uint64  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// MemorySize evaluates memory used by the series
+func (ts *TimeSeries) MemorySize() int {
+	size := 128 + len(ts.Key) + len(ts.SrcKey)
+	for key, value := range ts.Labels {
+		size += 32 + len(key) + len(value)
+	}
+	for _, rule := range ts.Rules {
+		size += 64 + len(rule.DestKey)
+	}
+	for _, chunk := range ts.Chunks {
+		size += 64 + int(chunk.Size)
+	}
+	return size
+}
+
+// ReadTimeSeries decodes TSDB-TYPE.
+// Aggregation contexts of compaction rules depend on aggregation type, so they are located by counting chunks
+// from the end of value. Samples of compressed chunks are not decoded.
+// Encoding versions before 2, which do not save samples in chunks, are not supported.
+func ReadTimeSeries(h core.ModuleTypeHandler, encVersion int) (interface{}, error) {
+	if err := checkEncVersion(TimeSeriesType, encVersion, tsMaxEncVersion); err != nil {
+		return nil, err
+	}
+	if encVersion < tsMinChunkEncVersion {
+		return nil, fmt.Errorf("unsupported encoding version of %s: %d", TimeSeriesType, encVersion)
+	}
+	v, err := readValues(h)
+	if err != nil {
+		return nil, err
+	}
+	ts := &TimeSeries{
+		Labels: make(map[string]string),
+	}
+	if ts.Key, err = v.string(); err != nil {
+		return nil, err
+	}
+	for _, field := range []*uint64{&ts.RetentionTime, &ts.ChunkSize, &ts.Options} {
+		if *field, err = v.uint(); err != nil {
+			return nil, err
+		}
+	}
+	if encVersion >= tsMinDuplicateEncVersion {
+		if ts.DuplicatePolicy, err = v.uint(); err != nil {
+			return nil, err
+		}
+	}
+	if encVersion >= tsMinIgnoreEncVersion {
+		if ts.IgnoreMaxTimeDiff, err = v.uint(); err != nil {
+			return nil, err
+		}
+		if ts.IgnoreMaxValDiff, err = v.double(); err != nil {
+			return nil, err
+		}
+	}
+	hasSrcKey, err := v.uint()
+	if err != nil {
+		return nil, err
+	}
+	if hasSrcKey != 0 {
+		if ts.SrcKey, err = v.string(); err != nil {
+			return nil, err
+		}
+	}
+	if ts.LastTimestamp, err = v.uint(); err != nil {
+		return nil, err
+	}
+	if ts.LastValue, err = v.double(); err != nil {
+		return nil, err
+	}
+	if ts.TotalSamples, err = v.uint(); err != nil {
+		return nil, err
+	}
+	labelCount, err := v.uint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < labelCount; i++ {
+		key, err := v.string()
+		if err != nil {
+			return nil, err
+		}
+		if ts.Labels[key], err = v.string(); err != nil {
+			return nil, err
+		}
+	}
+	ruleCount, err := v.uint()
+	if err != nil {
+		return nil, err
+	}
+
+	compressed := ts.Options&tsOptionUncompressed == 0
+	chunkValues := tsUncompressedChunkValues
+	if compressed {
+		chunkValues = tsCompressedChunkValues
+	}
+	chunkBegin, chunkCount := -1, 0
+	for n := 0; len(v.list)-1-n*chunkValues >= v.pos; n++ {
+		if count, ok := v.list[len(v.list)-1-n*chunkValues].(uint64); ok && count == uint64(n) {
+			chunkBegin, chunkCount = len(v.list)-1-n*chunkValues, n
+			break
+		}
+	}
+	if chunkBegin < 0 {
+		return nil, fmt.Errorf("cannot find chunks of time series %s", ts.Key)
+	}
+	err = readTimeSeriesRules(v, ts, ruleCount, chunkBegin, encVersion)
+	if err != nil {
+		return nil, err
+	}
+	v.pos++ // number of chunks
+	for i := 0; i < chunkCount; i++ {
+		var chunk *TimeSeriesChunk
+		if compressed {
+			chunk, err = readCompressedChunk(v)
+		} else {
+			chunk, err = readUncompressedChunk(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ts.Chunks = append(ts.Chunks, chunk)
+	}
+	return ts, v.end()
+}
+
+// readTimeSeriesRules reads rules, aggregation context of the last rule ends before end
+func readTimeSeriesRules(v *values, ts *TimeSeries, count uint64, end int, encVersion int) error {
+	var err error
+	for i := uint64(0); i < count; i++ {
+		rule := &CompactionRule{}
+		if rule.DestKey, err = v.string(); err != nil {
+			return err
+		}
+		if rule.BucketDuration, err = v.uint(); err != nil {
+			return err
+		}
+		if encVersion >= tsMinAlignmentEncVersion {
+			if rule.TimestampAlignment, err = v.uint(); err != nil {
+				return err
+			}
+		}
+		aggType, err := v.uint()
+		if err != nil {
+			return err
+		}
+		if aggType < uint64(len(tsAggregations)) {
+			rule.Aggregation = tsAggregations[aggType]
+		} else {
+			rule.Aggregation = fmt.Sprintf("unknown(%d)", aggType)
+		}
+		if rule.StartBucket, err = v.uint(); err != nil {
+			return err
+		}
+		// context ends at dest key of next rule or the given end
+		for v.pos < end {
+			if _, isString := v.list[v.pos].([]byte); isString && i+1 < count {
+				break
+			}
+			rule.Context = append(rule.Context, v.list[v.pos])
+			v.pos++
+		}
+		ts.Rules = append(ts.Rules, rule)
+	}
+	if v.pos > end {
+		return fmt.Errorf("rules of time series %s overlap its chunks", ts.Key)
+	}
+	return nil
+}
+
+func readUncompressedChunk(v *values) (*TimeSeriesChunk, error) {
+	chunk := &TimeSeriesChunk{}
+	var err error
+	for _, field := range []*uint64{&chunk.BaseTimestamp, &chunk.Count, &chunk.Size} {
+		if *field, err = v.uint(); err != nil {
+			return nil, err
+		}
+	}
+	if chunk.Data, err = v.bytes(); err != nil {
+		return nil, err
+	}
+	if uint64(len(chunk.Data)) < chunk.Count*tsSampleSize {
+		return nil, fmt.Errorf("chunk of %d samples has %d bytes", chunk.Count, len(chunk.Data))
+	}
+	for i := uint64(0); i < chunk.Count; i++ {
+		buf := chunk.Data[i*tsSampleSize:]
+		chunk.Samples = append(chunk.Samples, &Sample{
+			Timestamp: binary.LittleEndian.Uint64(buf[0:8]),
+			Value:     math.Float64frombits(binary.LittleEndian.Uint64(buf[8:16])),
+		})
+	}
+	return chunk, nil
+}
+
+func readCompressedChunk(v *values) (*TimeSeriesChunk, error) {
+	chunk := &TimeSeriesChunk{
+		Compressed: true,
+	}
+	var err error
+	if chunk.Size, err = v.uint(); err != nil {
+		return nil, err
+	}
+	if chunk.Count, err = v.uint(); err != nil {
+		return nil, err
+	}
+	v.pos += 2 // idx, base value
+	if chunk.BaseTimestamp, err = v.uint(); err != nil {
+		return nil, err
+	}
+	v.pos += 5 // prev timestamp, prev timestamp delta, prev value, prev leading, prev trailing
+	if chunk.Data, err = v.bytes(); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
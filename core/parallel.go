@@ -0,0 +1,395 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+const (
+	// minRangeSize is the min bytes of a range decoded by one goroutine
+	minRangeSize = 4 << 20
+	// rangesPerWorker is the expected number of ranges for each goroutine, more ranges for better balance
+	rangesPerWorker = 16
+	// rangeBufferSize is the number of decoded objects buffered for each range
+	rangeBufferSize = 256
+)
+
+// WithParallel enables decoding rdb with concurrency goroutines, it requires the reader of NewDecoder to be an
+// io.ReaderAt (like *os.File) positioned at the beginning of rdb, otherwise Parse decodes in single goroutine.
+// Decoder scans offsets of keys first, then goroutines decode disjoint ranges of rdb file.
+// If ordered is true, objects are passed to callback in original order, otherwise in the order they are decoded,
+// except that objects of special opcodes (like aux, functions and module aux) are always passed before keys.
+// Callback is always called in the goroutine calling Parse.
+// Handlers registered by WithSpecialType must be safe for concurrent use.
+func (dec *Decoder) WithParallel(concurrency int, ordered bool) *Decoder {
+	dec.concurrency = concurrency
+	dec.ordered = ordered
+	return dec
+}
+
+// parallelReader returns reader for parallel workers, returns nil if parallel decoding is disabled or not supported
+func (dec *Decoder) parallelReader() io.ReaderAt {
+	if dec.concurrency <= 1 || dec.metadataOnly {
+		return nil
+	}
+	readerAt, _ := dec.reader.(io.ReaderAt)
+	return readerAt
+}
+
+// parseRange is a range of rdb decoded by one goroutine
+type parseRange struct {
+	begin int64             // begin is offset of the type byte of the first key
+	end   int64             // end is offset of the first opcode out of range
+	state *model.BaseObject // state has db, expiration, lru, lfu and metadata of the first key
+}
+
+// parallelTask is a range to decode, or objects of special opcodes which have been decoded by scanner
+type parallelTask struct {
+	rng     *parseRange
+	objects []model.RedisObject
+	out     chan model.RedisObject // out is used in ordered mode
+	err     error
+}
+
+type parallelState struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	err      error
+	read     int64 // read is the number of bytes decoded by scanner and workers
+	tasks    []*parallelTask
+	closed   bool       // closed is true if no more tasks will be pushed
+	ready    *sync.Cond // ready is signaled when tasks are pushed or closed
+}
+
+func (s *parallelState) close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.closeTasks()
+}
+
+// pushTask never blocks, so that scanner won't wait for workers
+func (s *parallelState) pushTask(task *parallelTask) {
+	s.mu.Lock()
+	s.tasks = append(s.tasks, task)
+	s.mu.Unlock()
+	s.ready.Signal()
+}
+
+func (s *parallelState) closeTasks() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.ready.Broadcast()
+}
+
+// popTask blocks until a task is available, returns nil if tasks are closed and consumed
+func (s *parallelState) popTask() *parallelTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.tasks) == 0 && !s.closed {
+		s.ready.Wait()
+	}
+	if len(s.tasks) == 0 {
+		return nil
+	}
+	task := s.tasks[0]
+	s.tasks[0] = nil
+	s.tasks = s.tasks[1:]
+	return task
+}
+
+func (s *parallelState) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.close()
+}
+
+func (s *parallelState) getErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// fork creates a decoder with the same settings, it does not compute checksum
+func (dec *Decoder) fork() *Decoder {
+	return &Decoder{
+		buffer:            make([]byte, 8),
+		withSpecialOpCode: dec.withSpecialOpCode,
+		withoutChecksum:   dec.withoutChecksum,
+		withSpecialTypes:  dec.withSpecialTypes,
+		withModuleValues:  dec.withModuleValues,
+		withRawValue:      dec.withRawValue,
+		valkey:            dec.valkey,
+		rdbVersion:        dec.rdbVersion,
+	}
+}
+
+func (dec *Decoder) getRangeSize() int64 {
+	if dec.rangeSize > 0 {
+		return dec.rangeSize
+	}
+	size := dec.totalSize / int64(dec.concurrency*rangesPerWorker)
+	if size < minRangeSize {
+		return minRangeSize
+	}
+	return size
+}
+
+// scanRanges reads rest of rdb in metadata only mode and splits it into ranges.
+// Bytes out of ranges, like special opcodes and checksum, are added to read.
+func (dec *Decoder) scanRanges(read *int64, cb func(task *parallelTask) bool) error {
+	scanner := dec.fork()
+	scanner.input = dec.input
+	scanner.crc = dec.crc
+	scanner.readCount = dec.readCount
+	scanner.metadataOnly = true
+	scanner.withRawValue = false
+	defer func() {
+		dec.readCount = scanner.readCount
+	}()
+	rangeSize := dec.getRangeSize()
+	var current *parseRange
+	counted := int64(scanner.readCount) // bytes before counted have been added to read or belong to a range
+	stopped := false
+	err := scanner.parse(func(object model.RedisObject) bool {
+		offset := scanner.opOffset
+		meta, isKey := object.(*model.MetadataObject)
+		if current != nil && (!isKey || offset-current.begin >= rangeSize) {
+			current.end = offset
+			counted = offset
+			if !cb(&parallelTask{rng: current}) {
+				stopped = true
+				return false
+			}
+			current = nil
+		}
+		if !isKey {
+			// objects of special opcodes, like aux and dbsize
+			if !cb(&parallelTask{objects: []model.RedisObject{object}}) {
+				stopped = true
+				return false
+			}
+			return true
+		}
+		if current == nil {
+			current = &parseRange{
+				begin: offset,
+				state: meta.BaseObject,
+			}
+			atomic.AddInt64(read, offset-counted)
+		}
+		return true
+	})
+	if err != nil || stopped {
+		return err
+	}
+	if current != nil {
+		// the last range ends at EOF opcode
+		current.end = scanner.opOffset
+		counted = current.end
+		cb(&parallelTask{rng: current})
+	}
+	// EOF opcode and checksum
+	atomic.AddInt64(read, int64(scanner.readCount)-counted)
+	return nil
+}
+
+// decodeRange decodes objects in rng, it is called in worker goroutines
+func (dec *Decoder) decodeRange(readerAt io.ReaderAt, rng *parseRange, state *parallelState,
+	cb func(object model.RedisObject) bool) (err error) {
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			err = fmt.Errorf("panic: %v", err2)
+		}
+	}()
+	sub := dec.fork()
+	sub.input = bufio.NewReader(io.NewSectionReader(readerAt, rng.begin, rng.end-rng.begin))
+	sub.readCount = int(rng.begin)
+	sub.withSpecialOpCode = false // objects of special opcodes have been emitted by scanner
+	sub.withoutChecksum = true
+	sub.rng = rng
+	last := rng.begin
+	err = sub.parse(func(object model.RedisObject) bool {
+		atomic.AddInt64(&state.read, int64(sub.readCount)-last)
+		last = int64(sub.readCount)
+		return cb(object)
+	})
+	atomic.AddInt64(&state.read, int64(sub.readCount)-last)
+	return err
+}
+
+func (dec *Decoder) parseParallel(readerAt io.ReaderAt, cb func(object model.RedisObject) bool) error {
+	state := &parallelState{
+		stop: make(chan struct{}),
+		read: int64(dec.readCount),
+	}
+	state.ready = sync.NewCond(&state.mu)
+	dec.parallelRead = &state.read
+	defer func() {
+		dec.parallelRead = nil
+	}()
+	var ordered chan *parallelTask // tasks in original order, used in ordered mode
+	var specials chan model.RedisObject
+	var results chan model.RedisObject
+	if dec.ordered {
+		ordered = make(chan *parallelTask, dec.concurrency*2)
+	} else {
+		// objects of special opcodes are consumed before results of workers
+		specials = make(chan model.RedisObject, rangeBufferSize)
+		results = make(chan model.RedisObject, rangeBufferSize*dec.concurrency)
+	}
+	send := func(ch chan model.RedisObject, object model.RedisObject) bool {
+		select {
+		case ch <- object:
+			return true
+		case <-state.stop:
+			return false
+		}
+	}
+
+	// scanner goroutine
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer state.closeTasks()
+		if ordered != nil {
+			defer close(ordered)
+		} else {
+			defer close(specials)
+		}
+		defer func() {
+			if err := recover(); err != nil {
+				state.fail(fmt.Errorf("panic: %v", err))
+			}
+		}()
+		err := dec.scanRanges(&state.read, func(task *parallelTask) bool {
+			if task.rng == nil && !dec.ordered {
+				return send(specials, task.objects[0])
+			}
+			if task.rng != nil {
+				if dec.ordered {
+					task.out = make(chan model.RedisObject, rangeBufferSize)
+				}
+				state.pushTask(task)
+			}
+			if dec.ordered {
+				select {
+				case ordered <- task:
+				case <-state.stop:
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			state.fail(err)
+		}
+	}()
+
+	// worker goroutines
+	for i := 0; i < dec.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := state.popTask(); task != nil; task = state.popTask() {
+				out := results
+				if dec.ordered {
+					out = task.out
+				}
+				var err error
+				select {
+				case <-state.stop: // out of ordered task is closed below, so that consumer won't wait for it
+				default:
+					err = dec.decodeRange(readerAt, task.rng, state, func(object model.RedisObject) bool {
+						return send(out, object)
+					})
+				}
+				if err != nil {
+					task.err = err
+					if !dec.ordered {
+						state.fail(err)
+					}
+				}
+				if dec.ordered {
+					close(task.out)
+				}
+			}
+		}()
+	}
+
+	var err error
+	emit := func(object model.RedisObject) bool {
+		if err = dec.cancelled(); err != nil {
+			return false
+		}
+		tbc := cb(object)
+		switch object.(type) {
+		case *model.AuxObject, *model.ModuleAuxObject, *model.SlotImportObject: // global metadata does not belong to a db
+		default:
+			dec.currentDB = object.GetDBIndex()
+		}
+		dec.emitted()
+		return tbc
+	}
+	if dec.ordered {
+	consume:
+		for task := range ordered {
+			for _, object := range task.objects {
+				if !emit(object) {
+					break consume
+				}
+			}
+			if task.out == nil {
+				continue
+			}
+			for object := range task.out {
+				if !emit(object) {
+					break consume
+				}
+			}
+			if task.err != nil {
+				err = task.err
+				break
+			}
+		}
+	} else {
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+		stopped := false
+		for object := range specials {
+			if !emit(object) {
+				stopped = true
+				break
+			}
+		}
+		for object := range results {
+			if stopped || !emit(object) {
+				break
+			}
+		}
+	}
+	state.close()
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if err = state.getErr(); err != nil {
+		return err
+	}
+	// the final progress is counted by scanner and workers
+	dec.reportProgress()
+	return nil
+}
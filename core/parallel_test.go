@@ -0,0 +1,173 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func marshalObjects(t *testing.T, objects []model.RedisObject) []string {
+	result := make([]string, 0, len(objects))
+	for _, object := range objects {
+		data, err := json.Marshal(object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, string(data))
+	}
+	return result
+}
+
+func TestParseParallel(t *testing.T) {
+	files, err := filepath.Glob("../cases/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expect []model.RedisObject
+		err = NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+			expect = append(expect, object)
+			return true
+		})
+		if err != nil {
+			t.Errorf("parse %s failed: %v", filename, err)
+			continue
+		}
+		expectJSON := marshalObjects(t, expect)
+
+		for _, ordered := range []bool{true, false} {
+			var actual []model.RedisObject
+			var lastProgress Progress
+			dec := NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().WithParallel(3, ordered)
+			dec.rangeSize = 1 // every key in its own range
+			dec.WithProgress(func(progress Progress) {
+				lastProgress = progress
+			})
+			keySeen := false
+			err = dec.Parse(func(object model.RedisObject) bool {
+				switch object.GetType() {
+				case model.AuxType, model.DBSizeType, model.FunctionsType, model.ModuleAuxType,
+					model.SlotInfoType, model.SlotImportType:
+					if keySeen && !ordered {
+						t.Errorf("%s: %s object is passed after keys", filename, object.GetType())
+					}
+				default:
+					keySeen = true
+				}
+				actual = append(actual, object)
+				return true
+			})
+			if err != nil {
+				t.Errorf("parse %s in parallel failed: %v", filename, err)
+				continue
+			}
+			actualJSON := marshalObjects(t, actual)
+			if !ordered {
+				sort.Strings(actualJSON)
+				sort.Strings(expectJSON)
+			}
+			if len(actualJSON) != len(expectJSON) {
+				t.Errorf("%s: expect %d objects, actual %d", filename, len(expectJSON), len(actualJSON))
+				continue
+			}
+			for i := range expectJSON {
+				if expectJSON[i] != actualJSON[i] {
+					t.Errorf("%s: expect %s, actual %s", filename, expectJSON[i], actualJSON[i])
+				}
+			}
+			if progress := dec.GetProgress(); progress.ReadBytes != int64(len(data)) || progress.Objects != len(expect) {
+				t.Errorf("%s: wrong progress %+v", filename, progress)
+			}
+			if lastProgress.ReadBytes != int64(len(data)) {
+				t.Errorf("%s: progress should end at %d, actual %d", filename, len(data), lastProgress.ReadBytes)
+			}
+		}
+	}
+}
+
+func TestParseParallelStop(t *testing.T) {
+	data, err := os.ReadFile("../cases/memory.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ordered := range []bool{true, false} {
+		count := 0
+		dec := NewDecoder(bytes.NewReader(data)).WithParallel(2, ordered)
+		dec.rangeSize = 1
+		err = dec.Parse(func(object model.RedisObject) bool {
+			count++
+			return count < 2
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if count != 2 {
+			t.Errorf("expect stop after 2 objects, actual %d", count)
+		}
+	}
+
+	// corrupted rdb reports error
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	dec := NewDecoder(bytes.NewReader(corrupted)).WithParallel(2, true)
+	dec.rangeSize = 1
+	if err = parseBytes(dec); err == nil {
+		t.Error("expect checksum error")
+	}
+}
+
+func TestParseParallelSpecialFirst(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for db := 0; db < 3; db++ {
+		err = enc.WriteDBHeader(uint(db), 1000, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			err = enc.WriteStringObject(strconv.Itoa(i), []byte(strconv.Itoa(db)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	dec := NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().WithParallel(4, false)
+	dec.rangeSize = 1
+	keys, dbSizes := 0, 0
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if object.GetType() == model.DBSizeType {
+			dbSizes++
+			if keys > 0 {
+				t.Errorf("db size of db %d is passed after %d keys", object.GetDBIndex(), keys)
+			}
+		} else {
+			keys++
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != 3000 || dbSizes != 3 {
+		t.Errorf("expect 3000 keys and 3 db sizes, actual %d and %d", keys, dbSizes)
+	}
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// Progress describes how far a parsing has gone
+type Progress struct {
+	ReadBytes  int64 // ReadBytes is the number of bytes consumed
+	TotalBytes int64 // TotalBytes is size of the rdb file, 0 if unknown
+	Objects    int   // Objects is the number of objects emitted to callback
+	DB         int   // DB is the index of current db
+}
+
+// ProgressHookFunc is called after every object emitted and at the end of parsing
+type ProgressHookFunc func(progress Progress)
+
+// totalSizeOf returns size of reader if it is a regular file or in-memory reader, otherwise returns 0
+func totalSizeOf(reader interface{}) int64 {
+	switch r := reader.(type) {
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0
+		}
+		return info.Size()
+	case interface{ Size() int64 }: // bytes.Reader, strings.Reader, io.SectionReader
+		return r.Size()
+	}
+	return 0
+}
+
+// WithProgress sets a hook to report progress, it is called after every object emitted and at the end of parsing.
+// The hook runs in parsing goroutine, so it should return quickly.
+func (dec *Decoder) WithProgress(hook ProgressHookFunc) *Decoder {
+	dec.progressHook = hook
+	return dec
+}
+
+// WithTotalSize sets size of rdb file, in case it cannot be detected from reader
+func (dec *Decoder) WithTotalSize(size int64) *Decoder {
+	dec.totalSize = size
+	return dec
+}
+
+// GetProgress returns current progress
+func (dec *Decoder) GetProgress() Progress {
+	var readBytes int64
+	if dec.parallelRead != nil {
+		readBytes = atomic.LoadInt64(dec.parallelRead)
+	} else {
+		readBytes = int64(dec.readCount)
+	}
+	return Progress{
+		ReadBytes:  readBytes,
+		TotalBytes: dec.totalSize,
+		Objects:    dec.objectCount,
+		DB:         dec.currentDB,
+	}
+}
+
+func (dec *Decoder) reportProgress() {
+	if dec.progressHook != nil {
+		dec.progressHook(dec.GetProgress())
+	}
+}
+
+// emitted counts an object emitted to callback
+func (dec *Decoder) emitted() {
+	dec.objectCount++
+	dec.reportProgress()
+}
+
+// cancelled returns error of context set by ParseContext if it is done
+func (dec *Decoder) cancelled() error {
+	if dec.done == nil {
+		return nil
+	}
+	select {
+	case <-dec.done:
+		return dec.ctx.Err()
+	default:
+		return nil
+	}
+}
+
+// ParseContext is the same as Parse, but stops at next object boundary and returns ctx.Err() when ctx is done
+func (dec *Decoder) ParseContext(ctx context.Context, cb func(object model.RedisObject) bool) error {
+	dec.ctx = ctx
+	dec.done = ctx.Done()
+	defer func() {
+		dec.ctx = nil
+		dec.done = nil
+	}()
+	return dec.Parse(cb)
+}
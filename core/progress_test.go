@@ -0,0 +1,90 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func TestProgress(t *testing.T) {
+	data, err := os.ReadFile("../cases/multiple_databases.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last Progress
+	hooks := 0
+	count := 0
+	dec := NewDecoder(bytes.NewReader(data)).WithProgress(func(progress Progress) {
+		if progress.ReadBytes < last.ReadBytes || progress.Objects < last.Objects {
+			t.Errorf("progress goes back: %+v -> %+v", last, progress)
+		}
+		last = progress
+		hooks++
+	})
+	err = dec.Parse(func(object model.RedisObject) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooks != count+1 {
+		t.Errorf("expect %d hooks, actual %d", count+1, hooks)
+	}
+	if last.ReadBytes != int64(len(data)) || last.TotalBytes != int64(len(data)) {
+		t.Errorf("expect read %d bytes, actual %d/%d", len(data), last.ReadBytes, last.TotalBytes)
+	}
+	if last.Objects != count {
+		t.Errorf("expect %d objects, actual %d", count, last.Objects)
+	}
+	if last.DB != 2 {
+		t.Errorf("expect db 2, actual %d", last.DB)
+	}
+
+	rdbFile, err := os.Open("../cases/multiple_databases.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	if total := NewDecoder(rdbFile).GetProgress().TotalBytes; total != int64(len(data)) {
+		t.Errorf("expect total %d bytes of file, actual %d", len(data), total)
+	}
+	if total := NewDecoder(bytes.NewBuffer(data)).GetProgress().TotalBytes; total != 0 {
+		t.Errorf("expect unknown total size, actual %d", total)
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	data, err := os.ReadFile("../cases/memory.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err = NewDecoder(bytes.NewReader(data)).ParseContext(ctx, func(object model.RedisObject) bool {
+		count++
+		if count == 2 {
+			cancel()
+		}
+		return true
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expect context canceled, actual %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expect stop after 2 objects, actual %d", count)
+	}
+
+	err = NewDecoder(bytes.NewReader(data)).ParseContext(context.Background(), func(object model.RedisObject) bool {
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
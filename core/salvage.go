@@ -0,0 +1,203 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+const (
+	// salvageProbeKeys is the number of keys which must be decoded from a candidate offset before resuming there
+	salvageProbeKeys = 2
+	// lzfMaxRatio is the max ratio of decompressed length to compressed length of lzf, the actual max is 88
+	lzfMaxRatio = 128
+	// salvageMaxPrealloc is the max number of elements preallocated in salvage mode
+	salvageMaxPrealloc = 1024
+)
+
+// DamagedRegion is a range of rdb file skipped in salvage mode
+type DamagedRegion struct {
+	Offset  int64  `json:"offset"`  // Offset of the opcode which failed to decode
+	Length  int64  `json:"length"`  // Length is number of bytes skipped
+	LastKey string `json:"lastKey"` // LastKey is the last key decoded before this region, empty if there is none
+	Err     error  `json:"-"`       // Err is the error which occurred at Offset
+}
+
+// UnplacedKey is a recovered key which cannot be written into its db
+type UnplacedKey struct {
+	DB  int    `json:"db"`
+	Key string `json:"key"`
+}
+
+// SalvageReport describes result of Salvage
+type SalvageReport struct {
+	Recovered    int              `json:"recovered"`    // Recovered is the number of keys passed to callback
+	SkippedBytes int64            `json:"skippedBytes"` // SkippedBytes is the total length of damaged regions
+	Damaged      []*DamagedRegion `json:"damaged"`
+	// Unplaced is filled by invokers writing recovered keys, like helper.Salvage, Decoder.Salvage never sets it
+	Unplaced []*UnplacedKey `json:"unplaced,omitempty"`
+}
+
+// Salvage parses a damaged rdb file and callback, it requires the reader of NewDecoder to be an io.ReaderAt with
+// known size, like *os.File.
+// When a record cannot be decoded, Salvage records the damaged region and scans forward for the next offset
+// where at least salvageProbeKeys keys (or the end of file) can be decoded, then resumes decoding from there.
+// Keys in damaged regions are lost, and the checksum is not verified.
+// cb returns true to continue, returns false to stop the iteration.
+func (dec *Decoder) Salvage(cb func(object model.RedisObject) bool) (*SalvageReport, error) {
+	readerAt, ok := dec.reader.(io.ReaderAt)
+	if !ok || dec.totalSize <= 0 {
+		return nil, errors.New("salvage requires an io.ReaderAt with known size")
+	}
+	err := dec.checkHeader()
+	if err != nil {
+		return nil, err
+	}
+	report := &SalvageReport{}
+	offset := int64(dec.readCount)
+	lastKey := ""
+	for offset < dec.totalSize {
+		if err = dec.cancelled(); err != nil {
+			return report, err
+		}
+		sub := dec.salvageDecoder(readerAt, offset, dec.currentDB)
+		stopped := false
+		err = sub.parseSafely(func(object model.RedisObject) bool {
+			if !isSpecialObject(object) {
+				lastKey = object.GetKey()
+				report.Recovered++
+			}
+			dec.readCount = sub.readCount
+			dec.currentDB = sub.currentDB
+			tbc := cb(object)
+			dec.emitted()
+			stopped = !tbc
+			return tbc
+		})
+		dec.currentDB = sub.currentDB
+		dec.readCount = sub.readCount
+		if stopped {
+			return report, nil
+		}
+		if err == nil && int64(sub.readCount) >= dec.totalSize {
+			break
+		}
+		if err == nil {
+			err = errors.New("unexpected EOF opcode")
+		}
+		begin := sub.opOffset
+		next, err2 := dec.resync(readerAt, begin+1)
+		if err2 != nil {
+			return report, err2
+		}
+		region := &DamagedRegion{
+			Offset:  begin,
+			Length:  next - begin,
+			LastKey: lastKey,
+			Err:     err,
+		}
+		report.Damaged = append(report.Damaged, region)
+		report.SkippedBytes += region.Length
+		offset = next
+		dec.readCount = int(next)
+	}
+	dec.reportProgress()
+	return report, nil
+}
+
+// salvageDecoder creates a decoder which decodes rest of file from offset
+func (dec *Decoder) salvageDecoder(readerAt io.ReaderAt, offset int64, db int) *Decoder {
+	sub := dec.fork()
+	sub.input = bufio.NewReader(io.NewSectionReader(readerAt, offset, dec.totalSize-offset))
+	sub.readCount = int(offset)
+	sub.withoutChecksum = true
+	sub.maxLength = uint64(dec.totalSize - offset)
+	sub.currentDB = db
+	sub.rng = &parseRange{
+		begin: offset,
+		end:   dec.totalSize,
+		state: &model.BaseObject{DB: db},
+	}
+	return sub
+}
+
+// capacity returns capacity to preallocate for n elements.
+// In salvage mode n may be read from garbage, preallocating it may run out of memory which cannot be recovered,
+// so that the capacity is limited and slices grow by append.
+func (dec *Decoder) capacity(n uint64) int {
+	if dec.maxLength > 0 && n > salvageMaxPrealloc {
+		return salvageMaxPrealloc
+	}
+	return int(n)
+}
+
+// parseSafely is the same as parse, but returns error instead of panic on garbage
+func (dec *Decoder) parseSafely(cb func(object model.RedisObject) bool) (err error) {
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			err = fmt.Errorf("panic: %v", err2)
+		}
+	}()
+	return dec.parse(cb)
+}
+
+// isSpecialObject returns whether object is decoded from special opcodes rather than a key
+func isSpecialObject(object model.RedisObject) bool {
+	switch object.(type) {
+	case *model.AuxObject, *model.DBSizeObject, *model.FunctionsObject, *model.ModuleAuxObject,
+		*model.SlotInfoObject, *model.SlotImportObject:
+		return true
+	}
+	return false
+}
+
+// isSalvageCandidate returns whether b may be the first byte of a record
+func isSalvageCandidate(b byte) bool {
+	if b >= opCodeKeyMeta {
+		return true
+	}
+	_, isType := encodingMap[int(b)]
+	return isType || b == typeModule || b == typeModule2
+}
+
+// resync returns the first offset not before from where decoding can be resumed, returns size of file if not found
+func (dec *Decoder) resync(readerAt io.ReaderAt, from int64) (int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(readerAt, from, dec.totalSize-from))
+	for offset := from; offset < dec.totalSize; offset++ {
+		if err := dec.cancelled(); err != nil {
+			return 0, err
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if isSalvageCandidate(b) && dec.probe(readerAt, offset) {
+			return offset, nil
+		}
+	}
+	return dec.totalSize, nil
+}
+
+// probe tries decoding from offset, returns true if salvageProbeKeys keys or the end of file can be decoded.
+// Empty keys are rare in practice but common in garbage, so they are considered as failure.
+func (dec *Decoder) probe(readerAt io.ReaderAt, offset int64) bool {
+	sub := dec.salvageDecoder(readerAt, offset, dec.currentDB)
+	sub.withSpecialOpCode = false
+	keys := 0
+	emptyKey := false
+	err := sub.parseSafely(func(object model.RedisObject) bool {
+		if object.GetKey() == "" {
+			emptyKey = true
+			return false
+		}
+		keys++
+		return keys < salvageProbeKeys
+	})
+	if err != nil || emptyKey {
+		return false
+	}
+	return keys >= salvageProbeKeys || int64(sub.readCount) >= dec.totalSize
+}
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func makeSalvageRDB(t *testing.T, n int) []byte {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteDBHeader(0, uint64(n), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		err = enc.WriteStringObject(fmt.Sprintf("key:%d", i), []byte(fmt.Sprintf("value:%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func salvage(t *testing.T, data []byte) (map[string]string, *SalvageReport) {
+	recovered := make(map[string]string)
+	report, err := NewDecoder(bytes.NewReader(data)).Salvage(func(object model.RedisObject) bool {
+		if str, ok := object.(*model.StringObject); ok {
+			recovered[str.Key] = string(str.Value)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recovered, report
+}
+
+func TestSalvage(t *testing.T) {
+	const n = 100
+	data := makeSalvageRDB(t, n)
+
+	// intact file
+	recovered, report := salvage(t, data)
+	if report.Recovered != n || len(report.Damaged) != 0 || len(recovered) != n {
+		t.Errorf("wrong report of intact file: %+v", report)
+	}
+
+	// damaged type byte and value of key:50
+	damaged := append([]byte{}, data...)
+	pos := bytes.Index(damaged, []byte("key:50"))
+	damaged[pos-2] = 8 // unknown type
+	copy(damaged[pos+6:], bytes.Repeat([]byte{0xee}, 4))
+	recovered, report = salvage(t, damaged)
+	if len(report.Damaged) == 0 {
+		t.Fatal("expect damaged region")
+	}
+	region := report.Damaged[0]
+	if region.Offset != int64(pos-2) || region.LastKey != "key:49" || region.Err == nil {
+		t.Errorf("wrong damaged region: %+v", region)
+	}
+	if report.SkippedBytes <= 0 {
+		t.Errorf("wrong skipped bytes: %d", report.SkippedBytes)
+	}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		if i == 50 {
+			if _, ok := recovered[key]; ok {
+				t.Error("damaged key should not be recovered")
+			}
+			continue
+		}
+		if recovered[key] != fmt.Sprintf("value:%d", i) {
+			t.Errorf("%s is not recovered", key)
+		}
+	}
+
+	// truncated file
+	truncated := data[:bytes.Index(data, []byte("key:80"))+3]
+	recovered, report = salvage(t, truncated)
+	if len(recovered) != 80 || len(report.Damaged) != 1 {
+		t.Errorf("wrong report of truncated file: %+v", report)
+	}
+	if end := report.Damaged[0].Offset + report.Damaged[0].Length; end != int64(len(truncated)) {
+		t.Errorf("damaged region should end at end of file, actual %d", end)
+	}
+}
+
+func TestSalvageIntactCases(t *testing.T) {
+	files, err := filepath.Glob("../cases/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expect, actual []string
+		err = NewDecoder(bytes.NewReader(data)).WithModuleValues().Parse(func(object model.RedisObject) bool {
+			if !isSpecialObject(object) {
+				expect = append(expect, fmt.Sprintf("%d %s", object.GetDBIndex(), object.GetKey()))
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		dec := NewDecoder(bytes.NewReader(data)).WithModuleValues()
+		report, err := dec.Salvage(func(object model.RedisObject) bool {
+			if !isSpecialObject(object) {
+				actual = append(actual, fmt.Sprintf("%d %s", object.GetDBIndex(), object.GetKey()))
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		for _, region := range report.Damaged {
+			t.Errorf("%s: intact file is reported damaged at %d: %v", file, region.Offset, region.Err)
+		}
+		if !reflect.DeepEqual(expect, actual) {
+			t.Errorf("%s: expect keys %q, actual %q", file, expect, actual)
+		}
+	}
+}
+
+func TestSalvageStop(t *testing.T) {
+	data := makeSalvageRDB(t, 10)
+	_, err := NewDecoder(bytes.NewBuffer(data)).Salvage(func(object model.RedisObject) bool {
+		return true
+	})
+	if err == nil {
+		t.Error("expect error for reader without ReadAt")
+	}
+	count := 0
+	report, err := NewDecoder(bytes.NewReader(data)).Salvage(func(object model.RedisObject) bool {
+		count++
+		return count < 3
+	})
+	if err != nil || count != 3 || report.Recovered != 3 {
+		t.Errorf("stop failed: %v, %d", err, count)
+	}
+}
+
+func TestSalvageHugeLength(t *testing.T) {
+	// garbage claims 2^32-1 elements, preallocating them would run out of memory
+	data := []byte{len32Bit, 0xff, 0xff, 0xff, 0xff, 0x01, 'a'}
+	readers := map[string]func(dec *Decoder) error{
+		"list": func(dec *Decoder) error {
+			_, err := dec.readList()
+			return err
+		},
+		"set": func(dec *Decoder) error {
+			_, err := dec.readSet()
+			return err
+		},
+		"zset": func(dec *Decoder) error {
+			_, err := dec.readZSet(true)
+			return err
+		},
+		"key meta": func(dec *Decoder) error {
+			_, err := dec.readKeyMeta()
+			return err
+		},
+	}
+	for name, read := range readers {
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.maxLength = 1 << 40
+		if err := read(dec); err == nil {
+			t.Errorf("%s: expect error for truncated data", name)
+		}
+	}
+	dec := NewDecoder(bytes.NewReader(data))
+	if dec.capacity(1<<32) != 1<<32 {
+		t.Error("capacity should not be limited out of salvage mode")
+	}
+	dec.maxLength = 1 << 40
+	if dec.capacity(1<<32) != salvageMaxPrealloc || dec.capacity(10) != 10 {
+		t.Error("capacity should be limited in salvage mode")
+	}
+}
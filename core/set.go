@@ -11,12 +11,12 @@ import (
 )
 
 func (dec *Decoder) readSet() ([][]byte, error) {
-	size64, _, err := dec.readLength()
+	size64, _, err := dec.readSize()
 	if err != nil {
 		return nil, err
 	}
 	size := int(size64)
-	values := make([][]byte, 0, size)
+	values := make([][]byte, 0, dec.capacity(size64))
 	for i := 0; i < size; i++ {
 		val, err := dec.readString()
 		if err != nil {
@@ -41,7 +41,7 @@ func (dec *Decoder) readIntSet() (result [][]byte, detail *model.IntsetDetail, e
 	lenBytes := buf[4:8]
 	cardinality := binary.LittleEndian.Uint32(lenBytes)
 	cursor := 8
-	result = make([][]byte, 0, cardinality)
+	result = make([][]byte, 0, dec.capacity(uint64(cardinality)))
 	for i := uint32(0); i < cardinality; i++ {
 		var intBytes []byte
 		intBytes, err = readBytes(buf, &cursor, intSize)
@@ -91,7 +91,16 @@ func (enc *Encoder) WriteSetObject(key string, values [][]byte, options ...inter
 	if err != nil {
 		return err
 	}
-	ok, err := enc.tryWriteIntSetEncoding(key, values)
+	ok := false
+	if compact, hinted := encodingHint(options...); compact || !hinted {
+		// keep listpack of integers, which is written by redis if there are more than set-max-intset-entries members
+		if hintedEncoding(options...) != model.ListPackEncoding {
+			ok, err = enc.tryWriteIntSetEncoding(key, values)
+		}
+		if err == nil && !ok && enc.atLeastVersion(11) {
+			ok, err = enc.tryWriteListPackSet(key, values, compact)
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -186,3 +195,36 @@ func (enc *Encoder) tryWriteIntSetEncoding(key string, values [][]byte) (bool, e
 	}
 	return true, nil
 }
+
+// tryWriteListPackSet writes set in listpack, which is supported since rdb version 11 (redis 7.2).
+// Thresholds of SetSetListPackOpt are ignored if compact is true
+func (enc *Encoder) tryWriteListPackSet(key string, values [][]byte, compact bool) (bool, error) {
+	if !compact {
+		if len(values) > enc.setListPackOpt.getMaxEntries() {
+			return false, nil
+		}
+		maxValue := enc.setListPackOpt.getMaxValue()
+		for _, v := range values {
+			if len(v) > maxValue {
+				return false, nil
+			}
+		}
+	}
+	err := enc.write([]byte{typeSetListPack})
+	if err != nil {
+		return true, err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return true, err
+	}
+	lp := newListPackBuilder()
+	for _, v := range values {
+		lp.appendString(unsafeBytes2Str(v))
+	}
+	err = enc.writeNanString(unsafeBytes2Str(lp.bytes()))
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
@@ -64,3 +64,58 @@ func TestWithSpecialObject(t *testing.T) {
 		t.Error("wrong db size object count")
 	}
 }
+
+func TestValkeySlotObjects(t *testing.T) {
+	rdbFilename := filepath.Join("../cases", "valkey_slots.rdb")
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		t.Fatalf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	expectSlots := []model.SlotInfoObject{
+		{Slot: 5061, KeyCount: 1, ExpiresCount: 1},
+		{Slot: 5474, KeyCount: 3, ExpiresCount: 0},
+		{Slot: 12182, KeyCount: 1, ExpiresCount: 0},
+	}
+	var slots []*model.SlotInfoObject
+	var imports []*model.SlotImportObject
+	keyCount := 0
+	dec := NewDecoder(rdbFile).WithSpecialOpCode()
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.SlotInfoObject:
+			slots = append(slots, o)
+		case *model.SlotImportObject:
+			imports = append(imports, o)
+		case *model.StringObject:
+			keyCount++
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyCount != 4 {
+		t.Errorf("expect 4 keys, actual %d", keyCount)
+	}
+	if len(slots) != len(expectSlots) {
+		t.Fatalf("expect %d slot info, actual %d", len(expectSlots), len(slots))
+	}
+	for i, expect := range expectSlots {
+		actual := slots[i]
+		if actual.GetType() != model.SlotInfoType || actual.Slot != expect.Slot ||
+			actual.KeyCount != expect.KeyCount || actual.ExpiresCount != expect.ExpiresCount {
+			t.Errorf("expect slot info %+v, actual %+v", expect, actual)
+		}
+	}
+	if len(imports) != 1 {
+		t.Fatalf("expect 1 slot import, actual %d", len(imports))
+	}
+	if imports[0].GetType() != model.SlotImportType || imports[0].JobName != "import-job-1" || len(imports[0].Ranges) != 2 ||
+		imports[0].Ranges[0] != (model.SlotRange{From: 100, To: 200}) ||
+		imports[0].Ranges[1] != (model.SlotRange{From: 5000, To: 5000}) {
+		t.Errorf("wrong slot import: %+v", imports[0])
+	}
+}
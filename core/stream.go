@@ -3,7 +3,7 @@ package core
 import (
 	"encoding/binary"
 	"fmt"
-	"strconv"
+	"sort"
 
 	"github.com/hdt3213/rdb/model"
 )
@@ -22,44 +22,53 @@ func (dec *Decoder) readStreamListPacks(version uint) (*model.StreamObject, erro
 	if err != nil {
 		return nil, err
 	}
-	steamLen, _, err := dec.readLength()
+	stream := &model.StreamObject{
+		Entries: entries,
+	}
+	err = dec.readStreamMeta(stream, version)
 	if err != nil {
 		return nil, err
 	}
-	lastId, err := dec.readStreamId()
+	return stream, nil
+}
+
+// readStreamMeta reads length, ids and groups of stream after entries
+func (dec *Decoder) readStreamMeta(stream *model.StreamObject, version uint) error {
+	steamLen, _, err := dec.readLength()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	stream := &model.StreamObject{
-		Entries: entries,
-		Length:  steamLen,
-		LastId:  lastId,
+	lastId, err := dec.readStreamId()
+	if err != nil {
+		return err
 	}
+	stream.Length = steamLen
+	stream.LastId = lastId
 
 	if version >= 2 {
 		firstId, err := dec.readStreamId()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		stream.FirstId = firstId
 		maxDeletedId, err := dec.readStreamId()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		stream.MaxDeletedId = maxDeletedId
 		addedCount, _, err := dec.readLength()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		stream.AddedEntriesCount = addedCount
 	}
 	groups, err := dec.readStreamGroups(version)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	stream.Groups = groups
 	stream.Version = version
-	return stream, nil
+	return nil
 }
 
 func (dec *Decoder) readStreamId() (*model.StreamId, error) {
@@ -79,49 +88,58 @@ func (dec *Decoder) readStreamId() (*model.StreamId, error) {
 
 // readStreamEntries read entries
 func (dec *Decoder) readStreamEntries() ([]*model.StreamEntry, error) {
-	length, _, err := dec.readLength()
+	length, _, err := dec.readSize()
 	if err != nil {
 		return nil, err
 	}
 	var result []*model.StreamEntry
 	for i := uint64(0); i < length; i++ {
-		header, err := dec.readString()
-		if err != nil {
-			return nil, err
-		}
-		cursor := 0
-		msBin, err := readBytes(header, &cursor, 8)
-		if err != nil {
-			return nil, err
-		}
-		ms := binary.BigEndian.Uint64(msBin)
-		seqBin, err := readBytes(header, &cursor, 8)
-		if err != nil {
-			return nil, err
-		}
-		seq := binary.BigEndian.Uint64(seqBin)
-		firstId := &model.StreamId{
-			Ms:       ms,
-			Sequence: seq,
-		}
-
-		buf, err := dec.readString()
+		entry, err := dec.readStreamEntry()
 		if err != nil {
 			return nil, err
 		}
-		cursor = 0
-		// skip 4Byte total-bytes + 2Byte num-elements
-		_, _ = readBytes(buf, &cursor, 6)
-		entry, err := dec.readStreamEntryContent(buf, &cursor, firstId)
-		if err != nil {
-			return nil, err
-		}
-		entry.FirstMsgId = firstId
 		result = append(result, entry)
 	}
 	return result, nil
 }
 
+// readStreamEntry read a node of stream radix tree
+func (dec *Decoder) readStreamEntry() (*model.StreamEntry, error) {
+	header, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	cursor := 0
+	msBin, err := readBytes(header, &cursor, 8)
+	if err != nil {
+		return nil, err
+	}
+	ms := binary.BigEndian.Uint64(msBin)
+	seqBin, err := readBytes(header, &cursor, 8)
+	if err != nil {
+		return nil, err
+	}
+	seq := binary.BigEndian.Uint64(seqBin)
+	firstId := &model.StreamId{
+		Ms:       ms,
+		Sequence: seq,
+	}
+
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	cursor = 0
+	// skip 4Byte total-bytes + 2Byte num-elements
+	_, _ = readBytes(buf, &cursor, 6)
+	entry, err := dec.readStreamEntryContent(buf, &cursor, firstId)
+	if err != nil {
+		return nil, err
+	}
+	entry.FirstMsgId = firstId
+	return entry, nil
+}
+
 // readStreamEntryContent read messages in a stream entry
 func (dec *Decoder) readStreamEntryContent(buf []byte, cursor *int, firstId *model.StreamId) (*model.StreamEntry, error) {
 	// read count
@@ -140,13 +158,13 @@ func (dec *Decoder) readStreamEntryContent(buf []byte, cursor *int, firstId *mod
 		return nil, fmt.Errorf("read stream field number failed: %v", err)
 	}
 	masterFieldNum := int(fieldNum0)
-	masterFieldNames := make([]string, masterFieldNum)
+	masterFieldNames := make([]string, 0, dec.capacity(uint64(masterFieldNum)))
 	for i := 0; i < masterFieldNum; i++ {
 		name, err := dec.readListPackEntryAsString(buf, cursor)
 		if err != nil {
 			return nil, fmt.Errorf("read field name of stream entry failed: %v", err)
 		}
-		masterFieldNames[i] = string(name)
+		masterFieldNames = append(masterFieldNames, string(name))
 	}
 	// read lp count of master entry
 	if _, err = dec.readListPackEntryAsString(buf, cursor); err != nil {
@@ -154,7 +172,7 @@ func (dec *Decoder) readStreamEntryContent(buf []byte, cursor *int, firstId *mod
 	}
 
 	total := count + deleted
-	msgs := make([]*model.StreamMessage, 0, total)
+	msgs := make([]*model.StreamMessage, 0, dec.capacity(uint64(total)))
 	for i := int64(0); i < total; i++ {
 		flag, err := dec.readListPackEntryAsInt(buf, cursor)
 		if err != nil {
@@ -183,7 +201,7 @@ func (dec *Decoder) readStreamEntryContent(buf []byte, cursor *int, firstId *mod
 		}
 		msg := &model.StreamMessage{
 			Id:      msgId,
-			Fields:  make(map[string]string, masterFieldNum),
+			Fields:  make(map[string]string, dec.capacity(uint64(masterFieldNum))),
 			Deleted: flag&StreamItemFlagDeleted > 0,
 		}
 
@@ -217,13 +235,13 @@ func (dec *Decoder) readStreamEntryContent(buf []byte, cursor *int, firstId *mod
 }
 
 func (dec *Decoder) readStreamGroups(version uint) ([]*model.StreamGroup, error) {
-	groupCount, _, err := dec.readLength()
+	groupCount, _, err := dec.readSize()
 	if err != nil {
 		return nil, err
 	}
-	groups := make([]*model.StreamGroup, 0, int(groupCount))
+	groups := make([]*model.StreamGroup, 0, dec.capacity(groupCount))
 	for i := uint64(0); i < groupCount; i++ {
-		name, _ := dec.readString()
+		name, err := dec.readString()
 		if err != nil {
 			return nil, err
 		}
@@ -241,11 +259,11 @@ func (dec *Decoder) readStreamGroups(version uint) ([]*model.StreamGroup, error)
 		}
 
 		// read pending list
-		pendingCount, _, err := dec.readLength()
+		pendingCount, _, err := dec.readSize()
 		if err != nil {
 			return nil, err
 		}
-		pending := make([]*model.StreamNAck, 0, int(pendingCount))
+		pending := make([]*model.StreamNAck, 0, dec.capacity(pendingCount))
 		for j := uint64(0); j < pendingCount; j++ {
 			if err := dec.readFull(dec.buffer); err != nil {
 				return nil, err
@@ -275,11 +293,11 @@ func (dec *Decoder) readStreamGroups(version uint) ([]*model.StreamGroup, error)
 		}
 
 		// read consumers
-		consumerCount, _, err := dec.readLength()
+		consumerCount, _, err := dec.readSize()
 		if err != nil {
 			return nil, err
 		}
-		consumers := make([]*model.StreamConsumer, 0, int(consumerCount))
+		consumers := make([]*model.StreamConsumer, 0, dec.capacity(consumerCount))
 		for j := uint64(0); j < consumerCount; j++ {
 			consumerName, err := dec.readString()
 			if err != nil {
@@ -296,11 +314,11 @@ func (dec *Decoder) readStreamGroups(version uint) ([]*model.StreamGroup, error)
 				}
 				activeTime = binary.LittleEndian.Uint64(dec.buffer)
 			}
-			consumerPendingCount, _, err := dec.readLength()
+			consumerPendingCount, _, err := dec.readSize()
 			if err != nil {
 				return nil, err
 			}
-			consumerPending := make([]*model.StreamId, 0, int(consumerPendingCount))
+			consumerPending := make([]*model.StreamId, 0, dec.capacity(consumerPendingCount))
 			for k := uint64(0); k < consumerPendingCount; k++ {
 				if err := dec.readFull(dec.buffer); err != nil {
 					return nil, err
@@ -333,16 +351,59 @@ func (dec *Decoder) readStreamGroups(version uint) ([]*model.StreamGroup, error)
 	return groups, nil
 }
 
-// WriteStreamObject writes a stream object to RDB file
+// StreamDowngradeOption allows WriteStreamObject to write a stream in an older format supported by rdb version
+type StreamDowngradeOption bool
+
+// WithStreamDowngrade allows WriteStreamObject to write a stream in the latest format supported by rdb version of
+// Encoder, metadata added by later formats (like entries read of consumer groups) is dropped.
+// WriteStreamObject returns an error for streams of later formats by default.
+func WithStreamDowngrade() StreamDowngradeOption {
+	return true
+}
+
+// streamVersion returns format of stream written by encoder
+func (enc *Encoder) streamVersion(stream *model.StreamObject, options ...interface{}) (uint, error) {
+	version := stream.Version
+	maxVersion := uint(1)
+	if enc.atLeastVersion(11) {
+		maxVersion = 3
+	} else if enc.atLeastVersion(10) {
+		maxVersion = 2
+	}
+	if version <= maxVersion {
+		return version, nil
+	}
+	for _, opt := range options {
+		if _, ok := opt.(StreamDowngradeOption); ok {
+			return maxVersion, nil
+		}
+	}
+	return 0, fmt.Errorf("stream version %d requires rdb version %d or later, but target version is %d",
+		version, version+8, enc.version)
+}
+
+// WriteStreamObject writes a stream object to RDB file in the format of stream.Version, nodes of stream.Entries are
+// written like redis does, including deleted messages, so decoding the stream gets the same object.
+// Use SetStreamNodeOpt to repack messages into nodes within stream-node-max-bytes and stream-node-max-entries.
+// It returns an error if stream.Version is not supported by rdb version of Encoder, unless StreamDowngradeOption
+// is given.
 func (enc *Encoder) WriteStreamObject(key string, stream *model.StreamObject, options ...interface{}) error {
-	err := enc.beforeWriteObject(options...)
+	err := enc.requireVersion(9, "stream")
+	if err != nil {
+		return err
+	}
+	version, err := enc.streamVersion(stream, options...)
+	if err != nil {
+		return err
+	}
+	err = enc.beforeWriteObject(options...)
 	if err != nil {
 		return err
 	}
 
 	// Write stream type based on version
 	var streamType byte
-	switch stream.Version {
+	switch version {
 	case 1:
 		streamType = typeStreamListPacks
 	case 2:
@@ -382,7 +443,7 @@ func (enc *Encoder) WriteStreamObject(key string, stream *model.StreamObject, op
 	}
 
 	// Write version 2+ fields if available
-	if stream.Version >= 2 {
+	if version >= 2 {
 		if stream.FirstId != nil {
 			err = enc.writeStreamId(stream.FirstId)
 			if err != nil {
@@ -416,7 +477,7 @@ func (enc *Encoder) WriteStreamObject(key string, stream *model.StreamObject, op
 	}
 
 	// Write stream groups
-	err = enc.writeStreamGroups(stream.Groups, stream.Version)
+	err = enc.writeStreamGroups(stream.Groups, version)
 	if err != nil {
 		return err
 	}
@@ -434,227 +495,183 @@ func (enc *Encoder) writeStreamId(id *model.StreamId) error {
 	return enc.writeLength(id.Sequence)
 }
 
-// writeStreamEntries writes stream entries
+// writeStreamEntries writes nodes of stream, messages are repacked if SetStreamNodeOpt is called
 func (enc *Encoder) writeStreamEntries(entries []*model.StreamEntry) error {
+	if enc.streamNodeOpt != nil {
+		entries = enc.streamNodeOpt.pack(entries)
+	}
 	err := enc.writeLength(uint64(len(entries)))
 	if err != nil {
 		return err
 	}
-
+	header := make([]byte, 16)
 	for _, entry := range entries {
-		// Write entry header (first message ID)
-		header := make([]byte, 16)
-		binary.BigEndian.PutUint64(header[0:8], entry.FirstMsgId.Ms)
-		binary.BigEndian.PutUint64(header[8:16], entry.FirstMsgId.Sequence)
+		// the key of node in radix tree is its master id
+		masterId := streamMasterId(entry)
+		binary.BigEndian.PutUint64(header[0:8], masterId.Ms)
+		binary.BigEndian.PutUint64(header[8:16], masterId.Sequence)
 		err = enc.writeString(unsafeBytes2Str(header))
 		if err != nil {
 			return err
 		}
-
-		// Write entry content as listpack
-		err = enc.writeStreamEntryContent(entry)
+		lp := newListPackBuilder()
+		appendStreamMaster(lp, entry)
+		for _, msg := range entry.Msgs {
+			appendStreamMessage(lp, entry, msg)
+		}
+		err = enc.writeNanString(unsafeBytes2Str(lp.bytes()))
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-// writeStreamEntryContent writes a stream entry content as listpack
-func (enc *Encoder) writeStreamEntryContent(entry *model.StreamEntry) error {
-	// Calculate total messages (including deleted ones)
-	totalMsgs := len(entry.Msgs)
-	deletedCount := 0
-	for _, msg := range entry.Msgs {
-		if msg.Deleted {
-			deletedCount++
-		}
+// streamMasterId returns FirstMsgId of entry, or id of its first message if FirstMsgId is missing
+func streamMasterId(entry *model.StreamEntry) *model.StreamId {
+	if entry.FirstMsgId != nil {
+		return entry.FirstMsgId
 	}
-	validCount := totalMsgs - deletedCount
-
-	// Build listpack with proper backlen values
-	var entries []listpackEntry
-
-	// Add count and deleted count
-	entries = append(entries, listpackEntry{intVal: int64(validCount)})
-	entries = append(entries, listpackEntry{intVal: int64(deletedCount)})
-
-	// Add master field names
-	entries = append(entries, listpackEntry{intVal: int64(len(entry.Fields))})
-	for _, field := range entry.Fields {
-		entries = append(entries, listpackEntry{strVal: field})
+	if len(entry.Msgs) > 0 {
+		return entry.Msgs[0].Id
 	}
-	// Add field count for master entry (this is what the decoder reads as "end flag")
-	entries = append(entries, listpackEntry{strVal: strconv.Itoa(len(entry.Fields))})
+	return &model.StreamId{}
+}
 
-	// Add messages
+// appendStreamMaster appends the master entry of a node: count, deleted, num-fields, fields and a 0 terminator
+func appendStreamMaster(lp *listPackBuilder, entry *model.StreamEntry) {
+	deleted := 0
 	for _, msg := range entry.Msgs {
-		// Calculate flag
-		flag := StreamItemFlagNone
 		if msg.Deleted {
-			flag |= StreamItemFlagDeleted
+			deleted++
 		}
-
-		// Check if message uses same fields as master
-		if len(msg.Fields) == len(entry.Fields) {
-			sameFields := true
-			for _, field := range entry.Fields {
-				if _, exists := msg.Fields[field]; !exists {
-					sameFields = false
-					break
-				}
-			}
-			if sameFields {
-				flag |= StreamItemFlagSameFields
-			}
-		}
-
-		// Add flag
-		entries = append(entries, listpackEntry{intVal: int64(flag)})
-
-		// Add message ID (relative to first message ID)
-		msDiff := int64(msg.Id.Ms) - int64(entry.FirstMsgId.Ms)
-		seqDiff := int64(msg.Id.Sequence) - int64(entry.FirstMsgId.Sequence)
-		entries = append(entries, listpackEntry{intVal: msDiff})
-		entries = append(entries, listpackEntry{intVal: seqDiff})
-
-		// Add field count if not same fields
-		if flag&StreamItemFlagSameFields == 0 {
-			entries = append(entries, listpackEntry{intVal: int64(len(msg.Fields))})
-		}
-
-		// Add fields
-		if flag&StreamItemFlagSameFields > 0 {
-			// Use master field names order
-			for _, field := range entry.Fields {
-				value := msg.Fields[field]
-				entries = append(entries, listpackEntry{strVal: value})
-			}
-		} else {
-			// Add field names and values
-			for fieldName, fieldValue := range msg.Fields {
-				entries = append(entries, listpackEntry{strVal: fieldName})
-				entries = append(entries, listpackEntry{strVal: fieldValue})
-			}
-		}
-
-		// Add field count for this message (this is what the decoder reads as "end flag")
-		entries = append(entries, listpackEntry{strVal: strconv.Itoa(len(msg.Fields))})
 	}
-
-	// Build listpack with proper backlen values
-	listpackData := enc.buildListpackWithBacklen(entries)
-
-	// Write the complete listpack
-	err := enc.writeString(unsafeBytes2Str(listpackData))
-	if err != nil {
-		return err
+	lp.appendInt(int64(len(entry.Msgs) - deleted))
+	lp.appendInt(int64(deleted))
+	lp.appendInt(int64(len(entry.Fields)))
+	for _, field := range entry.Fields {
+		lp.appendString(field)
 	}
-
-	return nil
+	lp.appendInt(0)
 }
 
-type listpackEntry struct {
-	intVal int64
-	strVal string
+// appendStreamMessage appends a message to the node of entry like streamAppendItem of redis. Field names are
+// omitted if they are the same as the master entry, otherwise they are written in sorted order.
+// The message ends with lp-count, the number of listpack elements of the message except itself.
+func appendStreamMessage(lp *listPackBuilder, entry *model.StreamEntry, msg *model.StreamMessage) {
+	flag := StreamItemFlagNone
+	if msg.Deleted {
+		flag |= StreamItemFlagDeleted
+	}
+	if sameStreamFields(entry.Fields, msg.Fields) {
+		flag |= StreamItemFlagSameFields
+	}
+	masterId := streamMasterId(entry)
+	lp.appendInt(int64(flag))
+	lp.appendInt(int64(msg.Id.Ms - masterId.Ms))
+	lp.appendInt(int64(msg.Id.Sequence - masterId.Sequence))
+	if flag&StreamItemFlagSameFields > 0 {
+		for _, field := range entry.Fields {
+			lp.appendString(msg.Fields[field])
+		}
+		lp.appendInt(int64(len(entry.Fields) + 3))
+		return
+	}
+	fields := make([]string, 0, len(msg.Fields))
+	for field := range msg.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	lp.appendInt(int64(len(fields)))
+	for _, field := range fields {
+		lp.appendString(field)
+		lp.appendString(msg.Fields[field])
+	}
+	lp.appendInt(int64(len(fields)*2 + 4))
 }
 
-// buildListpackWithBacklen builds a proper listpack with backlen values
-func (enc *Encoder) buildListpackWithBacklen(entries []listpackEntry) []byte {
-	var listpackData []byte
-	var entrySizes []uint32
-
-	// First pass: encode entries and calculate sizes
-	for _, entry := range entries {
-		var encoded []byte
-		if entry.strVal != "" {
-			encoded = enc.encodeListPackString(entry.strVal)
-		} else {
-			encoded = enc.encodeListPackInt(entry.intVal)
-		}
-		listpackData = append(listpackData, encoded...)
-		entrySizes = append(entrySizes, uint32(len(encoded)))
+// sameStreamFields returns whether fields of message are the master fields. Master fields may be duplicated
+// like XADD s * k v k v, values of duplicated fields are the same since they are read into a map.
+func sameStreamFields(master []string, fields map[string]string) bool {
+	if len(master) < len(fields) {
+		return false
 	}
-
-	// Second pass: add backlen values
-	var finalListpack []byte
-	for i := len(entries) - 1; i >= 0; i-- {
-		// Add backlen
-		backlen := enc.encodeBacklen(entrySizes[i])
-		finalListpack = append(backlen, finalListpack...)
-		// Add entry
-		entryStart := 0
-		for j := 0; j < i; j++ {
-			entryStart += int(entrySizes[j])
+	seen := make(map[string]struct{}, len(master))
+	for _, field := range master {
+		if _, ok := fields[field]; !ok {
+			return false
 		}
-		entryEnd := entryStart + int(entrySizes[i])
-		finalListpack = append(listpackData[entryStart:entryEnd], finalListpack...)
+		seen[field] = struct{}{}
 	}
+	return len(seen) == len(fields)
+}
 
-	// Add header
-	totalBytes := len(finalListpack) + 6 // 6 bytes for header
-	header := make([]byte, 6)
-	binary.LittleEndian.PutUint32(header[0:4], uint32(totalBytes))
-	binary.LittleEndian.PutUint16(header[4:6], uint16(len(entries)))
+// streamListPackMaxSize is STREAM_LISTPACK_MAX_SIZE of redis, size of a node is limited to it if
+// stream-node-max-bytes is 0
+const streamListPackMaxSize = 1 << 30
 
-	return append(header, finalListpack...)
+// streamNodeOpt is like stream-node-max-bytes and stream-node-max-entries of redis
+type streamNodeOpt struct {
+	maxBytes   int // a new node is created if a message makes size of the node reach maxBytes
+	maxEntries int // a new node is created if the node has maxEntries messages (including deleted), 0 means no limit
 }
 
-// encodeBacklen encodes a backlen value
-func (enc *Encoder) encodeBacklen(elementLen uint32) []byte {
-	if elementLen <= 127 {
-		return []byte{byte(elementLen)}
-	} else if elementLen < (1<<14)-1 {
-		return []byte{
-			byte(0x80 | (elementLen >> 8)),
-			byte(elementLen & 0xFF),
-		}
-	} else if elementLen < (1<<21)-1 {
-		return []byte{
-			byte(0xC0 | (elementLen >> 16)),
-			byte((elementLen >> 8) & 0xFF),
-			byte(elementLen & 0xFF),
-		}
-	} else if elementLen < (1<<28)-1 {
-		return []byte{
-			byte(0xE0 | (elementLen >> 24)),
-			byte((elementLen >> 16) & 0xFF),
-			byte((elementLen >> 8) & 0xFF),
-			byte(elementLen & 0xFF),
-		}
-	} else {
-		return []byte{
-			0xF0,
-			byte((elementLen >> 24) & 0xFF),
-			byte((elementLen >> 16) & 0xFF),
-			byte((elementLen >> 8) & 0xFF),
-			byte(elementLen & 0xFF),
+// pack repacks messages of entries into nodes within limits like XADD does. The master entry of a new node has
+// fields of its first message in sorted order.
+func (opt *streamNodeOpt) pack(entries []*model.StreamEntry) []*model.StreamEntry {
+	maxBytes := opt.maxBytes
+	if maxBytes <= 0 || maxBytes > streamListPackMaxSize {
+		maxBytes = streamListPackMaxSize
+	}
+	var result []*model.StreamEntry
+	var node *model.StreamEntry
+	var lp *listPackBuilder // lp measures size of node
+	for _, entry := range entries {
+		for _, msg := range entry.Msgs {
+			elesLen := 0
+			for field, value := range msg.Fields {
+				elesLen += len(field) + len(value)
+			}
+			if node != nil && (len(lp.buf)+elesLen >= maxBytes ||
+				opt.maxEntries > 0 && len(node.Msgs) >= opt.maxEntries) {
+				node = nil
+			}
+			if node == nil {
+				fields := make([]string, 0, len(msg.Fields))
+				for field := range msg.Fields {
+					fields = append(fields, field)
+				}
+				sort.Strings(fields)
+				node = &model.StreamEntry{
+					FirstMsgId: msg.Id,
+					Fields:     fields,
+				}
+				result = append(result, node)
+				lp = newListPackBuilder()
+				appendStreamMaster(lp, node)
+			}
+			node.Msgs = append(node.Msgs, msg)
+			appendStreamMessage(lp, node, msg)
 		}
 	}
+	return result
 }
 
-// writeStreamGroups writes stream groups
+// writeStreamGroups writes consumer groups, each pending id of consumers should be in pending list of its group
 func (enc *Encoder) writeStreamGroups(groups []*model.StreamGroup, version uint) error {
 	err := enc.writeLength(uint64(len(groups)))
 	if err != nil {
 		return err
 	}
-
 	for _, group := range groups {
-		// Write group name
 		err = enc.writeString(group.Name)
 		if err != nil {
 			return err
 		}
-
-		// Write last ID
 		err = enc.writeStreamId(group.LastId)
 		if err != nil {
 			return err
 		}
-
-		// Write entries read (version 2+)
 		if version >= 2 {
 			err = enc.writeLength(group.EntriesRead)
 			if err != nil {
@@ -662,175 +679,80 @@ func (enc *Encoder) writeStreamGroups(groups []*model.StreamGroup, version uint)
 			}
 		}
 
-		// Write pending list
+		// pending list of group with delivery info, it is usually large, so ids are not allocated
 		err = enc.writeLength(uint64(len(group.Pending)))
 		if err != nil {
 			return err
 		}
-
-		for _, pending := range group.Pending {
-			// Write message ID
-			msBytes := make([]byte, 8)
-			binary.BigEndian.PutUint64(msBytes, pending.Id.Ms)
-			err = enc.write(msBytes)
+		pending := make(map[model.StreamId]struct{}, len(group.Pending))
+		for _, nack := range group.Pending {
+			pending[*nack.Id] = struct{}{}
+			err = enc.writeStreamRawId(nack.Id)
 			if err != nil {
 				return err
 			}
-
-			seqBytes := make([]byte, 8)
-			binary.BigEndian.PutUint64(seqBytes, pending.Id.Sequence)
-			err = enc.write(seqBytes)
+			err = enc.writeMillisecondTime(nack.DeliveryTime)
 			if err != nil {
 				return err
 			}
-
-			// Write delivery time
-			deliveryTimeBytes := make([]byte, 8)
-			binary.LittleEndian.PutUint64(deliveryTimeBytes, pending.DeliveryTime)
-			err = enc.write(deliveryTimeBytes)
-			if err != nil {
-				return err
-			}
-
-			// Write delivery count
-			err = enc.writeLength(pending.DeliveryCount)
+			err = enc.writeLength(nack.DeliveryCount)
 			if err != nil {
 				return err
 			}
 		}
 
-		// Write consumers
 		err = enc.writeLength(uint64(len(group.Consumers)))
 		if err != nil {
 			return err
 		}
-
 		for _, consumer := range group.Consumers {
-			// Write consumer name
 			err = enc.writeString(consumer.Name)
 			if err != nil {
 				return err
 			}
-
-			// Write seen time
-			seenTimeBytes := make([]byte, 8)
-			binary.LittleEndian.PutUint64(seenTimeBytes, consumer.SeenTime)
-			err = enc.write(seenTimeBytes)
+			err = enc.writeMillisecondTime(consumer.SeenTime)
 			if err != nil {
 				return err
 			}
-
-			// Write active time (version 3+)
 			if version >= 3 {
-				activeTimeBytes := make([]byte, 8)
-				binary.LittleEndian.PutUint64(activeTimeBytes, consumer.ActiveTime)
-				err = enc.write(activeTimeBytes)
+				err = enc.writeMillisecondTime(consumer.ActiveTime)
 				if err != nil {
 					return err
 				}
 			}
-
-			// Write consumer pending list
+			// redis refuses to load a consumer pending id which is not in pending list of group
 			err = enc.writeLength(uint64(len(consumer.Pending)))
 			if err != nil {
 				return err
 			}
-
-			for _, pendingId := range consumer.Pending {
-				// Write message ID
-				msBytes := make([]byte, 8)
-				binary.BigEndian.PutUint64(msBytes, pendingId.Ms)
-				err = enc.write(msBytes)
-				if err != nil {
-					return err
+			for _, id := range consumer.Pending {
+				if _, ok := pending[*id]; !ok {
+					return fmt.Errorf("pending id %d-%d of consumer %s is not in pending list of group %s",
+						id.Ms, id.Sequence, consumer.Name, group.Name)
 				}
-
-				seqBytes := make([]byte, 8)
-				binary.BigEndian.PutUint64(seqBytes, pendingId.Sequence)
-				err = enc.write(seqBytes)
+				err = enc.writeStreamRawId(id)
 				if err != nil {
 					return err
 				}
 			}
 		}
 	}
-
 	return nil
 }
 
-// encodeListPackInt encodes an integer for listpack
-func (enc *Encoder) encodeListPackInt(val int64) []byte {
-	if val >= -127 && val <= 127 {
-		// 0xxxxxxx, uint7
-		return []byte{byte(val)}
-	} else if val >= -8191 && val <= 8191 {
-		// 110xxxxx yyyyyyyy, int13
-		uval := uint16(val)
-		if val < 0 {
-			uval = uint16(8191 + val + 1)
-		}
-		return []byte{
-			byte(0xC0 | (uval >> 8)),
-			byte(uval & 0xFF),
-		}
-	} else if val >= -32767 && val <= 32767 {
-		// 11110001 aaaaaaaa bbbbbbbb, int16
-		uval := uint16(val)
-		return []byte{
-			0xF1,
-			byte(uval & 0xFF),
-			byte(uval >> 8),
-		}
-	} else if val >= -8388607 && val <= 8388607 {
-		// 11110010 aaaaaaaa bbbbbbbb cccccccc, int24
-		uval := uint32(val)
-		return []byte{
-			0xF2,
-			byte(uval & 0xFF),
-			byte((uval >> 8) & 0xFF),
-			byte((uval >> 16) & 0xFF),
-		}
-	} else if val >= -2147483647 && val <= 2147483647 {
-		// 11110011 aaaaaaaa bbbbbbbb cccccccc dddddddd, int32
-		uval := uint32(val)
-		return []byte{
-			0xF3,
-			byte(uval & 0xFF),
-			byte((uval >> 8) & 0xFF),
-			byte((uval >> 16) & 0xFF),
-			byte((uval >> 24) & 0xFF),
-		}
-	} else {
-		// 11110100 8Byte -> int64
-		uval := uint64(val)
-		result := []byte{0xF4}
-		for i := 0; i < 8; i++ {
-			result = append(result, byte(uval&0xFF))
-			uval >>= 8
-		}
-		return result
+// writeStreamRawId writes stream id in 16 bytes big endian, which is used by pending lists
+func (enc *Encoder) writeStreamRawId(id *model.StreamId) error {
+	binary.BigEndian.PutUint64(enc.buffer, id.Ms)
+	err := enc.write(enc.buffer)
+	if err != nil {
+		return err
 	}
+	binary.BigEndian.PutUint64(enc.buffer, id.Sequence)
+	return enc.write(enc.buffer)
 }
 
-// encodeListPackString encodes a string for listpack
-func (enc *Encoder) encodeListPackString(s string) []byte {
-	bytes := []byte(s)
-	length := len(bytes)
-
-	if length <= 63 {
-		// 10xxxxxx + content, string(len<=63)
-		return append([]byte{byte(0x80 | length)}, bytes...)
-	} else if length < 4096 {
-		// 1110xxxx yyyyyyyy + content, string(len < 1<<12)
-		header := make([]byte, 2)
-		header[0] = byte(0xE0 | (length >> 8))
-		header[1] = byte(length & 0xFF)
-		return append(header, bytes...)
-	} else {
-		// 11110000 aaaaaaaa bbbbbbbb cccccccc dddddddd + content, string(len < 1<<32)
-		header := make([]byte, 5)
-		header[0] = 0xF0
-		binary.LittleEndian.PutUint32(header[1:5], uint32(length))
-		return append(header, bytes...)
-	}
+// writeMillisecondTime writes unix time in milliseconds in 8 bytes little endian like rdbSaveMillisecondTime
+func (enc *Encoder) writeMillisecondTime(t uint64) error {
+	binary.LittleEndian.PutUint64(enc.buffer, t)
+	return enc.write(enc.buffer)
 }
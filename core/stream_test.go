@@ -2,8 +2,12 @@ package core
 
 import (
 	"bytes"
+	"math"
+	"math/rand"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/hdt3213/rdb/model"
 )
@@ -375,3 +379,250 @@ func decodeStreamObject(t *testing.T, buf *bytes.Buffer, stream *model.StreamObj
 		}
 	}
 }
+
+// randStreamValue returns random field or value, including strings stored as integers in listpack
+func randStreamValue(r *rand.Rand) string {
+	switch r.Intn(4) {
+	case 0:
+		return strconv.FormatInt(r.Int63n(1<<40)-1<<39, 10)
+	case 1:
+		return ""
+	case 2:
+		return "0" + strconv.Itoa(r.Intn(100))
+	}
+	b := make([]byte, r.Intn(80))
+	for i := range b {
+		b[i] = byte(r.Intn(256))
+	}
+	return string(b)
+}
+
+func randStreamId(r *rand.Rand, prev *model.StreamId) *model.StreamId {
+	if r.Intn(2) == 0 {
+		return &model.StreamId{Ms: prev.Ms, Sequence: prev.Sequence + 1 + uint64(r.Intn(5))}
+	}
+	return &model.StreamId{Ms: prev.Ms + 1 + uint64(r.Int63n(1<<20)), Sequence: uint64(r.Intn(3))}
+}
+
+// randStreamObject generates a stream of version which can be read back without loss
+func randStreamObject(r *rand.Rand, version uint) *model.StreamObject {
+	stream := &model.StreamObject{
+		Version: version,
+		LastId:  &model.StreamId{Ms: uint64(r.Int63n(1 << 41))},
+	}
+	var ids []*model.StreamId
+	for i := r.Intn(5); i > 0; i-- {
+		masterId := randStreamId(r, stream.LastId)
+		entry := &model.StreamEntry{
+			FirstMsgId: masterId,
+			Fields:     make([]string, 0),
+		}
+		for j := r.Intn(5); j > 0; j-- {
+			entry.Fields = append(entry.Fields, "f"+strconv.Itoa(len(entry.Fields))+randStreamValue(r))
+		}
+		prev := masterId
+		for j := 1 + r.Intn(150); j > 0; j-- {
+			msg := &model.StreamMessage{
+				Id:      prev,
+				Fields:  make(map[string]string),
+				Deleted: r.Intn(4) == 0,
+			}
+			if r.Intn(2) == 0 {
+				for _, field := range entry.Fields {
+					msg.Fields[field] = randStreamValue(r)
+				}
+			} else {
+				for k := r.Intn(6); k > 0; k-- {
+					msg.Fields[randStreamValue(r)] = randStreamValue(r)
+				}
+			}
+			if !msg.Deleted {
+				stream.Length++
+				ids = append(ids, msg.Id)
+			}
+			entry.Msgs = append(entry.Msgs, msg)
+			prev = randStreamId(r, prev)
+		}
+		stream.Entries = append(stream.Entries, entry)
+		stream.LastId = entry.Msgs[len(entry.Msgs)-1].Id
+	}
+	if version >= 2 {
+		stream.FirstId = &model.StreamId{}
+		if len(ids) > 0 {
+			stream.FirstId = ids[0]
+		}
+		stream.MaxDeletedId = &model.StreamId{Ms: uint64(r.Intn(1000))}
+		stream.AddedEntriesCount = stream.Length + uint64(r.Intn(1000))
+	}
+	stream.Groups = make([]*model.StreamGroup, 0)
+	for i := r.Intn(4); i > 0; i-- {
+		group := &model.StreamGroup{
+			Name:      "g" + randStreamValue(r),
+			LastId:    stream.LastId,
+			Pending:   make([]*model.StreamNAck, 0),
+			Consumers: make([]*model.StreamConsumer, 0),
+		}
+		if version >= 2 {
+			group.EntriesRead = uint64(r.Int63())
+			if r.Intn(3) == 0 {
+				group.EntriesRead = math.MaxUint64 // SCG_INVALID_ENTRIES_READ
+			}
+		}
+		for j := r.Intn(4); j > 0; j-- {
+			consumer := &model.StreamConsumer{
+				Name:     randStreamValue(r),
+				SeenTime: uint64(r.Int63n(1 << 42)),
+				Pending:  make([]*model.StreamId, 0),
+			}
+			consumer.ActiveTime = consumer.SeenTime
+			if version >= 3 {
+				consumer.ActiveTime = uint64(r.Int63n(1 << 42))
+			}
+			group.Consumers = append(group.Consumers, consumer)
+		}
+		// pending ids are not required to be in stream, since messages may be deleted
+		pel := ids
+		if r.Intn(2) == 0 {
+			pel = nil
+			id := &model.StreamId{}
+			for j := r.Intn(5000); j > 0; j-- {
+				id = randStreamId(r, id)
+				pel = append(pel, id)
+			}
+		}
+		for _, id := range pel {
+			if r.Intn(3) == 0 {
+				continue
+			}
+			group.Pending = append(group.Pending, &model.StreamNAck{
+				Id:            id,
+				DeliveryTime:  uint64(r.Int63n(1 << 42)),
+				DeliveryCount: uint64(r.Int63n(1 << 33)),
+			})
+			if len(group.Consumers) > 0 {
+				consumer := group.Consumers[r.Intn(len(group.Consumers))]
+				consumer.Pending = append(consumer.Pending, id)
+			}
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream
+}
+
+// encodeStreams writes streams and reads them back
+func encodeStreams(t *testing.T, enc *Encoder, buf *bytes.Buffer, streams []*model.StreamObject) []*model.StreamObject {
+	err := enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteDBHeader(0, uint64(len(streams)), 0)
+	}
+	for i := 0; i < len(streams) && err == nil; i++ {
+		err = enc.WriteStreamObject("s"+strconv.Itoa(i), streams[i])
+	}
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []*model.StreamObject
+	err = NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+		stream := object.(*model.StreamObject)
+		stream.BaseObject = nil
+		result = append(result, stream)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	for version := uint(1); version <= 3; version++ {
+		var streams []*model.StreamObject
+		for i := 0; i < 50; i++ {
+			streams = append(streams, randStreamObject(r, version))
+		}
+		buf := bytes.NewBuffer(nil)
+		actual := encodeStreams(t, NewEncoder(buf).EnableCompress(), buf, streams)
+		for i, stream := range streams {
+			if !reflect.DeepEqual(stream, actual[i]) {
+				t.Fatalf("seed %d: stream of version %d is not kept, expect %+v, actual %+v",
+					seed, version, stream, actual[i])
+			}
+		}
+	}
+}
+
+func TestStreamNodeOpt(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	var streams []*model.StreamObject
+	for i := 0; i < 50; i++ {
+		streams = append(streams, randStreamObject(r, 3))
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf).SetStreamNodeOpt(4096, 100)
+	actual := encodeStreams(t, enc, buf, streams)
+	for i, stream := range streams {
+		var expectMsgs, actualMsgs []*model.StreamMessage
+		for _, entry := range stream.Entries {
+			expectMsgs = append(expectMsgs, entry.Msgs...)
+		}
+		for j, entry := range actual[i].Entries {
+			if len(entry.Msgs) > 100 {
+				t.Errorf("seed %d: node has %d messages", seed, len(entry.Msgs))
+			}
+			if j < len(actual[i].Entries)-1 && len(entry.Msgs) < 100 {
+				// node is split only if the next message makes it reach stream-node-max-bytes
+				lp := newListPackBuilder()
+				appendStreamMaster(lp, entry)
+				for _, msg := range entry.Msgs {
+					appendStreamMessage(lp, entry, msg)
+				}
+				size := len(lp.buf)
+				for field, value := range actual[i].Entries[j+1].Msgs[0].Fields {
+					size += len(field) + len(value)
+				}
+				if size < 4096 {
+					t.Errorf("seed %d: node of %d bytes is split", seed, size)
+				}
+			}
+			if !reflect.DeepEqual(entry.FirstMsgId, entry.Msgs[0].Id) {
+				t.Errorf("seed %d: master id of node is not id of its first message", seed)
+			}
+			actualMsgs = append(actualMsgs, entry.Msgs...)
+		}
+		if !reflect.DeepEqual(expectMsgs, actualMsgs) {
+			t.Fatalf("seed %d: messages are not kept", seed)
+		}
+		actual[i].Entries = stream.Entries
+		if !reflect.DeepEqual(stream, actual[i]) {
+			t.Fatalf("seed %d: stream is not kept, expect %+v, actual %+v", seed, stream, actual[i])
+		}
+	}
+
+	enc = NewEncoder(bytes.NewBuffer(nil))
+	err := enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteDBHeader(0, 0, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := &model.StreamObject{
+		Version: 3,
+		LastId:  &model.StreamId{},
+		Groups: []*model.StreamGroup{{
+			Name:      "g",
+			LastId:    &model.StreamId{},
+			Consumers: []*model.StreamConsumer{{Name: "c", Pending: []*model.StreamId{{Ms: 1}}}},
+		}},
+	}
+	err = enc.WriteStreamObject("s", stream)
+	if err == nil {
+		t.Error("expect error for consumer pending id not in pending list of group")
+	}
+}
@@ -78,8 +78,22 @@ func (dec *Decoder) readLength() (uint64, bool, error) {
 	return length, special, nil
 }
 
-func (dec *Decoder) readString() ([]byte, error) {
+// readSize reads length of a string or number of elements of a collection.
+// In salvage mode, it returns an error if the length exceeds rest of file, since every byte or element takes at least
+// one byte. Other numbers encoded like length, such as ttl and ids, should be read by readLength.
+func (dec *Decoder) readSize() (uint64, bool, error) {
 	length, special, err := dec.readLength()
+	if err != nil {
+		return 0, false, err
+	}
+	if !special && dec.maxLength > 0 && length > dec.maxLength {
+		return 0, false, fmt.Errorf("length %d exceeds rest of file", length)
+	}
+	return length, special, nil
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, special, err := dec.readSize()
 	if err != nil {
 		return nil, err
 	}
@@ -180,14 +194,17 @@ func (dec *Decoder) readFloat32() (f float32, err error) {
 }
 
 func (dec *Decoder) readLZF() ([]byte, error) {
-	inLen, _, err := dec.readLength()
+	inLen, _, err := dec.readSize()
 	if err != nil {
 		return nil, err
 	}
-	outLen, _, err := dec.readLength()
+	outLen, _, err := dec.readLength() // decompressed length may exceed rest of file
 	if err != nil {
 		return nil, err
 	}
+	if dec.maxLength > 0 && outLen > inLen*lzfMaxRatio {
+		return nil, fmt.Errorf("illegal lzf length: %d compressed to %d", outLen, inLen)
+	}
 	val := make([]byte, inLen)
 	err = dec.readFull(val)
 	if err != nil {
@@ -254,26 +271,28 @@ func (enc *Encoder) tryWriteIntString(s string) (bool, error) {
 	return true, nil
 }
 
-func (enc *Encoder) writeLZFString(s string) error {
+// tryWriteLZFString writes s in LZF compressed format like rdbSaveLzfStringObject of redis.
+// It writes nothing and returns false if compression saves no more than 4 bytes
+func (enc *Encoder) tryWriteLZFString(s string) (bool, error) {
 	out, err := lzf.Compress([]byte(s))
-	if err != nil {
-		return err
+	if err != nil || len(out) > len(s)-4 { // lzf fails if output is longer than input
+		return false, nil
 	}
 	err = enc.write([]byte{encodeLZFPrefix})
 	if err != nil {
-		return err
+		return true, err
 	}
 	// write compressed length
 	err = enc.writeLength(uint64(len(out)))
 	if err != nil {
-		return err
+		return true, err
 	}
 	// write uncompressed length
 	err = enc.writeLength(uint64(len(s)))
 	if err != nil {
-		return err
+		return true, err
 	}
-	return enc.write(out)
+	return true, enc.write(out)
 }
 
 func (enc *Encoder) writeString(s string) error {
@@ -284,23 +303,17 @@ func (enc *Encoder) writeString(s string) error {
 	if isInt {
 		return nil
 	}
-	// Try LZF compression - under 20 bytes it's unable to compress even so skip it
-	// see rdbSaveRawString at [rdb.c](https://github.com/redis/redis/blob/unstable/src/rdb.c#L449)
-	if enc.compress && len(s) > 20 {
-		err = enc.writeLZFString(s)
-		if err == nil { // lzf may failed, while out > in
-			return nil
-		}
-	}
-	return enc.writeSimpleString(s)
+	return enc.writeNanString(s)
 }
 
-// write string without try int string. for tryWriteIntSetEncoding, writeZipList
+// write string without try int string. for blobs like ziplist, listpack and intset
 func (enc *Encoder) writeNanString(s string) error {
+	// Try LZF compression - under 20 bytes it's unable to compress even so skip it
+	// see rdbSaveRawString at [rdb.c](https://github.com/redis/redis/blob/unstable/src/rdb.c#L449)
 	if enc.compress && len(s) > 20 {
-		err := enc.writeLZFString(s)
-		if err == nil { // lzf may failed, while out > in
-			return nil
+		compressed, err := enc.tryWriteLZFString(s)
+		if compressed || err != nil {
+			return err
 		}
 	}
 	return enc.writeSimpleString(s)
@@ -327,6 +340,20 @@ func (enc *Encoder) WriteStringObject(key string, value []byte, options ...inter
 	return nil
 }
 
+// writeLiteralFloat writes float in string prefixed by its length, special values use special lengths
+func (enc *Encoder) writeLiteralFloat(f float64) error {
+	switch {
+	case math.IsNaN(f):
+		return enc.write([]byte{0xfd})
+	case math.IsInf(f, 1):
+		return enc.write([]byte{0xfe})
+	case math.IsInf(f, -1):
+		return enc.write([]byte{0xff})
+	}
+	s := strconv.FormatFloat(f, 'g', 17, 64)
+	return enc.write(append([]byte{byte(len(s))}, s...))
+}
+
 func (enc *Encoder) writeFloat64(f float64) error {
 	bin := math.Float64bits(f)
 	binary.LittleEndian.PutUint64(enc.buffer, bin)
@@ -144,3 +144,39 @@ func TestLZFString(t *testing.T) {
 		}
 	}
 }
+
+func TestLZFStringEncoding(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf).EnableCompress()
+	strList := []string{
+		strings.Repeat("a", 21),
+		strings.Repeat("abc", 1000),
+		strings.Repeat(RandString(100), 100),
+		RandString(20000),
+		strings.Repeat("a", 20),
+	}
+	compressed := []bool{true, true, true, false, false}
+	for i, str := range strList {
+		size := buf.Len()
+		err := enc.writeString(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isLZF := buf.Bytes()[size] == encodeLZFPrefix; isLZF != compressed[i] {
+			t.Errorf("string %d: expect compressed %v, actual %v", i, compressed[i], isLZF)
+		}
+		if compressed[i] && buf.Len()-size >= len(str) {
+			t.Errorf("string %d: compressed %d bytes into %d bytes", i, len(str), buf.Len()-size)
+		}
+	}
+	dec := NewDecoder(buf)
+	for i, expect := range strList {
+		actual, err := dec.readString()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != expect {
+			t.Errorf("string %d: wrong value", i)
+		}
+	}
+}
@@ -33,21 +33,79 @@ func readByte(buf []byte, cursor *int) (byte, error) {
 	return b, nil
 }
 
+// zipListUnknownLength is stored in zllen if there are 65535 or more entries
+const zipListUnknownLength = 65535
+
 func readZipListLength(buf []byte, cursor *int) int {
 	start := *cursor + 8
 	end := start + 2
 	// zip list buf: [0, 4] -> zlbytes, [4:8] -> zltail, [8:10] -> zllen
 	size := int(binary.LittleEndian.Uint16(buf[start:end]))
 	*cursor += 10
+	if size == zipListUnknownLength {
+		size = countZipListEntries(buf, *cursor)
+	}
 	return size
 }
 
+// countZipListEntries walks entries from cursor until the end mark, like ziplistLen when zllen is unknown.
+// It stops at the first broken entry, which will be reported when the entry is read.
+func countZipListEntries(buf []byte, cursor int) int {
+	count := 0
+	for cursor < len(buf) && buf[cursor] != 0xff {
+		if buf[cursor] == zipBigPrevLen {
+			cursor += 5
+		} else {
+			cursor++
+		}
+		if cursor >= len(buf) {
+			break
+		}
+		header := buf[cursor]
+		switch {
+		case header>>6 == zipStr06B:
+			cursor += 1 + int(header&0x3f)
+		case header>>6 == zipStr14B:
+			if cursor+1 >= len(buf) {
+				return count
+			}
+			cursor += 2 + (int(header&0x3f)<<8 | int(buf[cursor+1]))
+		case header>>6 == zipStr32B:
+			if cursor+5 > len(buf) {
+				return count
+			}
+			cursor += 5 + int(binary.BigEndian.Uint32(buf[cursor+1:cursor+5]))
+		case header == zipInt08B:
+			cursor += 2
+		case header == zipInt16B:
+			cursor += 3
+		case header == zipInt24B:
+			cursor += 4
+		case header == zipInt32B:
+			cursor += 5
+		case header == zipInt64B:
+			cursor += 9
+		default: // 1111xxxx, int04
+			cursor++
+		}
+		count++
+	}
+	return count
+}
+
 func (dec *Decoder) readByte() (byte, error) {
 	b, err := dec.input.ReadByte()
 	if err != nil {
 		return 0, err
 	}
 	dec.readCount++
+	if dec.capturing {
+		dec.rawValue = append(dec.rawValue, b)
+	}
+	if dec.crc != nil {
+		dec.crcBuffer[0] = b
+		_, _ = dec.crc.Write(dec.crcBuffer[:])
+	}
 	return b, nil
 }
 
@@ -57,6 +115,12 @@ func (dec *Decoder) readFull(buf []byte) error {
 		return err
 	}
 	dec.readCount += n
+	if dec.capturing {
+		dec.rawValue = append(dec.rawValue, buf...)
+	}
+	if dec.crc != nil {
+		_, _ = dec.crc.Write(buf)
+	}
 	return nil
 }
 
@@ -151,8 +151,8 @@ func encodeZipListEntry(prevLen uint32, val string) []byte {
 	} else if len(val) <= maxUint14 {
 		buf.Write([]byte{byte(len(val)>>8) | len14BitMask, byte(len(val))})
 	} else if len(val) <= math.MaxUint32 {
-		buffer := make([]byte, 8)
-		binary.LittleEndian.PutUint32(buffer, uint32(len(val)))
+		buffer := make([]byte, 4)
+		binary.BigEndian.PutUint32(buffer, uint32(len(val)))
 		buf.Write([]byte{0x80})
 		buf.Write(buffer)
 	} else {
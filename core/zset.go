@@ -1,17 +1,18 @@
 package core
 
 import (
+	"sort"
 	"strconv"
 
 	"github.com/hdt3213/rdb/model"
 )
 
 func (dec *Decoder) readZSet(zset2 bool) ([]*model.ZSetEntry, error) {
-	length, _, err := dec.readLength()
+	length, _, err := dec.readSize()
 	if err != nil {
 		return nil, err
 	}
-	entries := make([]*model.ZSetEntry, 0, int(length))
+	entries := make([]*model.ZSetEntry, 0, dec.capacity(length))
 	for i := uint64(0); i < length; i++ {
 		member, err := dec.readString()
 		if err != nil {
@@ -103,12 +104,21 @@ func (enc *Encoder) WriteZSetObject(key string, entries []*model.ZSetEntry, opti
 	if err != nil {
 		return err
 	}
-	ok, err := enc.tryWriteZipListZSet(key, entries)
+	var ok bool
+	if enc.preferListPack(options...) {
+		ok, err = enc.tryWriteListPackZSet(key, entries, options...)
+	} else {
+		ok, err = enc.tryWriteZipListZSet(key, entries, options...)
+	}
 	if err != nil {
 		return err
 	}
 	if !ok {
-		err = enc.writeZSet2Encoding(key, entries)
+		if enc.atLeastVersion(8) && hintedEncoding(options...) != model.ZSetEncoding {
+			err = enc.writeZSet2Encoding(key, entries)
+		} else {
+			err = enc.writeZSetEncoding(key, entries)
+		}
 		if err != nil {
 			return err
 		}
@@ -117,6 +127,33 @@ func (enc *Encoder) WriteZSetObject(key string, entries []*model.ZSetEntry, opti
 	return nil
 }
 
+// writeZSetEncoding writes zset with scores in string, which is used before rdb version 8
+func (enc *Encoder) writeZSetEncoding(key string, entries []*model.ZSetEntry) error {
+	err := enc.write([]byte{typeZset})
+	if err != nil {
+		return err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(entries)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = enc.writeString(entry.Member)
+		if err != nil {
+			return err
+		}
+		err = enc.writeLiteralFloat(entry.Score)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (enc *Encoder) writeZSet2Encoding(key string, entries []*model.ZSetEntry) error {
 	err := enc.write([]byte{typeZset2})
 	if err != nil {
@@ -143,15 +180,21 @@ func (enc *Encoder) writeZSet2Encoding(key string, entries []*model.ZSetEntry) e
 	return nil
 }
 
-func (enc *Encoder) tryWriteZipListZSet(key string, entries []*model.ZSetEntry) (bool, error) {
-	if len(entries) > enc.zsetZipListOpt.getMaxEntries() {
+func (enc *Encoder) tryWriteZipListZSet(key string, entries []*model.ZSetEntry, options ...interface{}) (bool, error) {
+	compact, hinted := encodingHint(options...)
+	if hinted && !compact {
 		return false, nil
 	}
-	maxValue := enc.zsetZipListOpt.getMaxValue()
-	for _, entry := range entries {
-		if len(entry.Member) > maxValue {
+	if !compact {
+		if len(entries) > enc.zsetZipListOpt.getMaxEntries() {
 			return false, nil
 		}
+		maxValue := enc.zsetZipListOpt.getMaxValue()
+		for _, entry := range entries {
+			if len(entry.Member) > maxValue {
+				return false, nil
+			}
+		}
 	}
 	err := enc.write([]byte{typeZsetZipList})
 	if err != nil {
@@ -172,3 +215,48 @@ func (enc *Encoder) tryWriteZipListZSet(key string, entries []*model.ZSetEntry)
 	}
 	return true, nil
 }
+
+// tryWriteListPackZSet writes members and scores ordered by score then member, like zset in listpack of redis
+func (enc *Encoder) tryWriteListPackZSet(key string, entries []*model.ZSetEntry, options ...interface{}) (bool, error) {
+	compact, hinted := encodingHint(options...)
+	if hinted && !compact {
+		return false, nil
+	}
+	if !compact {
+		if len(entries) > enc.zsetListPackOpt.getMaxEntries() {
+			return false, nil
+		}
+		maxValue := enc.zsetListPackOpt.getMaxValue()
+		for _, entry := range entries {
+			if len(entry.Member) > maxValue {
+				return false, nil
+			}
+		}
+	}
+	err := enc.write([]byte{typeZsetListPack})
+	if err != nil {
+		return true, err
+	}
+	err = enc.writeString(key)
+	if err != nil {
+		return true, err
+	}
+	sorted := make([]*model.ZSetEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score < sorted[j].Score
+		}
+		return sorted[i].Member < sorted[j].Member
+	})
+	lp := newListPackBuilder()
+	for _, entry := range sorted {
+		lp.appendString(entry.Member)
+		lp.appendString(strconv.FormatFloat(entry.Score, 'f', -1, 64))
+	}
+	err = enc.writeNanString(unsafeBytes2Str(lp.bytes()))
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
@@ -0,0 +1,51 @@
+// Package crc16 implements the 16-bit cyclic redundancy check used by Redis Cluster to map keys to hash slots.
+//
+// Specification of this CRC16 variant follows:
+// - Name: XMODEM (also known as ZMODEM or CRC-16/ACORN)
+// - Width: 16 bit
+// - Poly: 1021 (That is actually x^16 + x^12 + x^5 + 1)
+// - Initialization: 0000
+// - Reflect Input byte: False
+// - Reflect Output CRC: False
+// - Xor constant to output CRC: 0000
+// - Output for "123456789": 31C3
+package crc16
+
+// Poly is the polynomial of XMODEM
+const Poly = 0x1021
+
+var table = makeTable(Poly)
+
+func makeTable(poly uint16) *[256]uint16 {
+	t := new([256]uint16)
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// Checksum returns the CRC16 checksum of data
+func Checksum(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// ChecksumString returns the CRC16 checksum of s without copying it
+func ChecksumString(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
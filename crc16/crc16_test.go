@@ -0,0 +1,13 @@
+package crc16
+
+import "testing"
+
+func TestGolden(t *testing.T) {
+	in := "123456789"
+	if out := uint16(0x31c3); Checksum([]byte(in)) != out {
+		t.Fatalf("crc16(%s) = 0x%x want 0x%x", in, Checksum([]byte(in)), out)
+	}
+	if ChecksumString(in) != Checksum([]byte(in)) {
+		t.Fatalf("ChecksumString(%s) = 0x%x want 0x%x", in, ChecksumString(in), Checksum([]byte(in)))
+	}
+}
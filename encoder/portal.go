@@ -10,3 +10,12 @@ var NewEncoder = core.NewEncoder
 
 // WithTTL specific expiration timestamp for object
 var WithTTL = core.WithTTL
+
+// WithIdleTime specific LRU idle time in seconds for object
+var WithIdleTime = core.WithIdleTime
+
+// WithFreq specific LFU frequency for object
+var WithFreq = core.WithFreq
+
+// WithKeepEncoding makes Encoder.WriteObject keep encoding of object read by decoder
+var WithKeepEncoding = core.WithKeepEncoding
@@ -33,8 +33,11 @@ func FindBiggestKeys(rdbFilename string, topN int, output *os.File, options ...i
 		return err
 	}
 	top := newToplist(topN)
-	err = dec.Parse(func(object model.RedisObject) bool {
-		top.add(object)
+	err = parseObjectEnds(dec, func(event *core.ElementEvent) bool {
+		top.add(&elemCountObject{
+			RedisObject: event.Object,
+			elemCount:   event.ElemCount,
+		})
 		return true
 	})
 	if err != nil {
@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"encoding/csv"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -82,6 +83,37 @@ func TestFindLargestKeys(t *testing.T) {
 		return
 	}
 
+	// metadata only mode, size of ziplist is evaluated by its raw length, so only compare keys and element counts
+	output, err = os.Create(outputFilePath)
+	if err != nil {
+		t.Errorf("create output file failed: %v", err)
+		return
+	}
+	err = FindBiggestKeys(srcRdb, 5, output, WithMetadataOnly())
+	if err != nil {
+		t.Errorf("FindLargestKeys failed: %v", err)
+	}
+	_ = output.Close()
+	actual, err := readCsv(outputFilePath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect, err := readCsv(expectFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(actual) != len(expect) {
+		t.Errorf("expect %d lines, actual %d", len(expect), len(actual))
+		return
+	}
+	for i := range expect {
+		if actual[i][1] != expect[i][1] || actual[i][2] != expect[i][2] || actual[i][5] != expect[i][5] {
+			t.Errorf("expect %v, actual %v", expect[i], actual[i])
+		}
+	}
+
 	err = FindBiggestKeys("", 5, os.Stdout)
 	if err == nil || err.Error() != "src file path is required" {
 		t.Error("failed when empty output")
@@ -131,3 +163,14 @@ func TestFindBiggestKeyWithRegex(t *testing.T) {
 		t.Error("expect error")
 	}
 }
+
+func readCsv(filename string) ([][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return csv.NewReader(file).ReadAll()
+}
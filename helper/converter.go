@@ -9,6 +9,8 @@ import (
 )
 
 // ToAOF read rdb file and convert to aof file (Redis Serialization )
+// Elements of keys are written in batches, unless SizeOption is given, since size of a key is known after all its
+// elements are read, then each key is loaded entirely before it is written.
 func ToAOF(rdbFilename string, aofFilename string, options ...interface{}) error {
 	if rdbFilename == "" {
 		return errors.New("src file path is required")
@@ -16,6 +18,15 @@ func ToAOF(rdbFilename string, aofFilename string, options ...interface{}) error
 	if aofFilename == "" {
 		return errors.New("output file path is required")
 	}
+	sized := false
+	for _, opt := range options {
+		switch opt.(type) {
+		case MetadataOnlyOption:
+			return errors.New("aof requires values, metadata only mode is not supported")
+		case SizeOption:
+			sized = true
+		}
+	}
 	rdbFile, err := os.Open(rdbFilename)
 	if err != nil {
 		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
@@ -35,8 +46,10 @@ func ToAOF(rdbFilename string, aofFilename string, options ...interface{}) error
 	if dec, err = wrapDecoder(dec, options...); err != nil {
 		return err
 	}
-	return dec.Parse(func(object model.RedisObject) bool {
-		cmdLines := ObjectToCmd(object, options...)
+	write := func(cmdLines []CmdLine) bool {
+		if len(cmdLines) == 0 {
+			return true
+		}
 		data := CmdLinesToResp(cmdLines)
 		_, err = aofFile.Write(data)
 		if err != nil {
@@ -44,5 +57,20 @@ func ToAOF(rdbFilename string, aofFilename string, options ...interface{}) error
 			return true
 		}
 		return true
+	}
+	if sized {
+		return dec.Parse(func(object model.RedisObject) bool {
+			return write(ObjectToCmd(object, options...))
+		})
+	}
+	// write elements in batches, so a huge key won't be loaded into memory entirely
+	return parseElements(dec, func(event *core.ElementEvent) bool {
+		switch event.Type {
+		case core.ObjectElementsEvent:
+			return write(valueToCmd(event.Object, options...))
+		case core.ObjectEndEvent:
+			return write(objectMetaToCmd(event.Object))
+		}
+		return true
 	})
 }
@@ -103,6 +103,11 @@ func TestToJson(t *testing.T) {
 		"zipmap_that_doesnt_compress",
 		"zipmap_with_big_values",
 		"zipmap_big_len",
+		"key_meta_12",
+		"key_meta_13",
+		"rejson",
+		"redisbloom",
+		"timeseries",
 	}
 	for _, filename := range testCases {
 		srcRdb := filepath.Join("../cases", filename+".rdb")
@@ -153,6 +158,8 @@ func TestToJsonWithGlobalMeta(t *testing.T) {
 	}()
 	testCases := []string{
 		"function",
+		"module_aux",
+		"valkey_slots",
 	}
 	for _, filename := range testCases {
 		srcRdb := filepath.Join("../cases", filename+".rdb")
@@ -248,6 +255,18 @@ func TestToAof(t *testing.T) {
 	if err == nil || err.Error() != "output file path is required" {
 		t.Error("failed when empty output")
 	}
+	err = ToAOF(srcRdb, actualFile, WithSizeOption("0~inf"), lexOrder{})
+	if err != nil {
+		t.Errorf("error occurs during parse %s with size filter, err: %v", srcRdb, err)
+	}
+	equals, err = compareFileByLine(t, actualFile, expectFile)
+	if err != nil || !equals {
+		t.Errorf("result with size filter is not equal of %s, err: %v", srcRdb, err)
+	}
+	err = ToAOF(srcRdb, actualFile, WithMetadataOnly())
+	if err == nil {
+		t.Error("expect error for metadata only mode")
+	}
 	err = ToAOF("", "tmp/err.rdb")
 	if err == nil || err.Error() != "src file path is required" {
 		t.Error("failed when empty output")
@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+// elementDecoder is a decoder supporting element-level streaming, see core.Decoder.ParseElements
+type elementDecoder interface {
+	ParseElements(cb func(event *core.ElementEvent) bool) error
+}
+
+// parseElements parses in element-level streaming mode if dec supports it,
+// otherwise each whole object is emitted as begin, elements and end events
+func parseElements(dec decoder, cb func(event *core.ElementEvent) bool) error {
+	if ed, ok := dec.(elementDecoder); ok {
+		return ed.ParseElements(cb)
+	}
+	return dec.Parse(func(object model.RedisObject) bool {
+		if !cb(&core.ElementEvent{Type: core.ObjectBeginEvent, Object: object}) {
+			return false
+		}
+		if !cb(&core.ElementEvent{Type: core.ObjectElementsEvent, Object: object}) {
+			return false
+		}
+		return cb(&core.ElementEvent{Type: core.ObjectEndEvent, Object: object, ElemCount: object.GetElemCount()})
+	})
+}
+
+// objectEndDecoder is a decoder which filters objects by their ObjectEndEvent, like sizeDecoder,
+// since the size of an object is final only after all its elements are read
+type objectEndDecoder interface {
+	parseObjectEnds(cb func(event *core.ElementEvent) bool) error
+}
+
+// parseObjectEnds calls cb with the ObjectEndEvent of each object, elements are streamed and dropped,
+// so the memory usage is bounded even if dec filters objects by size
+func parseObjectEnds(dec decoder, cb func(event *core.ElementEvent) bool) error {
+	if ed, ok := dec.(objectEndDecoder); ok {
+		return ed.parseObjectEnds(cb)
+	}
+	return parseElements(dec, func(event *core.ElementEvent) bool {
+		if event.Type != core.ObjectEndEvent {
+			return true
+		}
+		return cb(event)
+	})
+}
+
+// filterElements passes all events of objects accepted by filter, filter is called on core.ObjectBeginEvent
+func filterElements(dec decoder, filter func(object model.RedisObject) bool, cb func(event *core.ElementEvent) bool) error {
+	accepted := false
+	return parseElements(dec, func(event *core.ElementEvent) bool {
+		if event.Type == core.ObjectBeginEvent {
+			accepted = filter(event.Object)
+		}
+		if !accepted {
+			return true
+		}
+		return cb(event)
+	})
+}
+
+// elemCountObject is an object from core.ObjectEndEvent which has no elements but knows its element count
+type elemCountObject struct {
+	model.RedisObject
+	elemCount int
+}
+
+// GetElemCount returns number of elements in list/set/hash/zset
+func (o *elemCountObject) GetElemCount() int {
+	return o.elemCount
+}
@@ -0,0 +1,120 @@
+package helper
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+func TestParseElements(t *testing.T) {
+	expect := make(map[string]int)
+	rdbFile, err := os.Open("../cases/memory.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = core.NewDecoder(rdbFile).Parse(func(object model.RedisObject) bool {
+		expect[object.GetKey()] = object.GetElemCount()
+		return true
+	})
+	_ = rdbFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range [][]interface{}{
+		{WithRegexOption("^l.*")},
+		{WithRegexOption("^l.*"), WithNoExpiredOption()},
+	} {
+		rdbFile, err := os.Open("../cases/memory.rdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dec decoder = core.NewDecoder(rdbFile)
+		dec, err = wrapDecoder(dec, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var key string
+		count := 0
+		err = parseElements(dec, func(event *core.ElementEvent) bool {
+			switch event.Type {
+			case core.ObjectBeginEvent:
+				key = event.Object.GetKey()
+				if key[0] != 'l' {
+					t.Errorf("%s should be filtered", key)
+				}
+			case core.ObjectElementsEvent:
+				if event.Object.GetKey() != key {
+					t.Errorf("expect elements of %s, actual %s", key, event.Object.GetKey())
+				}
+			case core.ObjectEndEvent:
+				count++
+				if event.ElemCount != expect[key] {
+					t.Errorf("%s expect elem count %d, actual %d", key, expect[key], event.ElemCount)
+				}
+			}
+			return true
+		})
+		_ = rdbFile.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		if count == 0 {
+			t.Error("no object matched")
+		}
+	}
+}
+
+func TestParseObjectEnds(t *testing.T) {
+	expect := make(map[string]int)
+	rdbFile, err := os.Open("../cases/memory.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = core.NewDecoder(rdbFile).Parse(func(object model.RedisObject) bool {
+		if object.GetSize() >= 1024 {
+			expect[object.GetKey()] = object.GetElemCount()
+		}
+		return true
+	})
+	_ = rdbFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rdbFile, err = os.Open("../cases/memory.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	var dec decoder = core.NewDecoder(rdbFile)
+	dec, err = wrapDecoder(dec, WithSizeOption("1KB~inf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = parseElements(dec, func(event *core.ElementEvent) bool {
+		return true
+	})
+	if err == nil {
+		t.Error("expect error for size filter in element-level streaming mode")
+	}
+	actual := make(map[string]int)
+	err = parseObjectEnds(dec, func(event *core.ElementEvent) bool {
+		if event.Type != core.ObjectEndEvent {
+			t.Errorf("unexpected event %d", event.Type)
+		}
+		actual[event.Object.GetKey()] = event.ElemCount
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expect) == 0 || !reflect.DeepEqual(expect, actual) {
+		t.Errorf("expect %v, actual %v", expect, actual)
+	}
+}
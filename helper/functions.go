@@ -0,0 +1,108 @@
+package helper
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+var functionLoadReplaceCmd = [][]byte{[]byte("FUNCTION"), []byte("LOAD"), []byte("REPLACE")}
+
+// ListFunctions reads function libraries in rdb file and writes them to output.
+// Supported formats are json (libraries and their functions), csv (a row per function)
+// and resp (FUNCTION LOAD REPLACE commands to restore libraries).
+// Only ProgressOption is supported in options. The invoker owns output, ListFunctions won't close it.
+func ListFunctions(rdbFilename string, format string, output io.Writer, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "resp" {
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	dec := core.NewDecoder(rdbFile).WithSpecialOpCode()
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case ProgressOption:
+			dec.WithProgress(core.ProgressHookFunc(o))
+		}
+	}
+	var functions []*model.FunctionsObject
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.FunctionsObject:
+			functions = append(functions, o)
+		case *model.AuxObject, *model.ModuleAuxObject, *model.SlotImportObject: // global metadata before functions
+		default:
+			return false // functions are saved before keys
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "csv":
+		return writeFunctionsCSV(output, functions)
+	case "resp":
+		for _, obj := range functions {
+			cmdLine := append(append(CmdLine{}, functionLoadReplaceCmd...), []byte(obj.FunctionsLua))
+			if _, err = output.Write(makeMultiBulkResp(cmdLine)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	libraries := make([]model.FunctionLibrary, 0, len(functions))
+	for _, obj := range functions {
+		libraries = append(libraries, obj.Libraries...)
+	}
+	data, err := jsonEncoder.Marshal(libraries)
+	if err != nil {
+		return fmt.Errorf("json marshal failed: %v", err)
+	}
+	data = append(data, '\n')
+	_, err = output.Write(data)
+	return err
+}
+
+func writeFunctionsCSV(output io.Writer, functions []*model.FunctionsObject) error {
+	_, err := io.WriteString(output, "library,engine,function,flags,description\n")
+	if err != nil {
+		return fmt.Errorf("write header failed: %v", err)
+	}
+	csvWriter := csv.NewWriter(output)
+	for _, obj := range functions {
+		for _, lib := range obj.Libraries {
+			for _, fn := range lib.Functions {
+				err = csvWriter.Write([]string{
+					lib.Name,
+					lib.Engine,
+					fn.Name,
+					strings.Join(fn.Flags, " "),
+					fn.Description,
+				})
+				if err != nil {
+					return fmt.Errorf("csv write failed: %v", err)
+				}
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
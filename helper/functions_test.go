@@ -0,0 +1,58 @@
+package helper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestListFunctions(t *testing.T) {
+	code := "#!lua name=mylib\nredis.register_function('myfunc', function(keys, args) return 'hello' end)"
+	buf := bytes.NewBuffer(nil)
+	err := ListFunctions("../cases/function.rdb", "", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `[{"engine":"lua","name":"mylib","functions":[{"name":"myfunc"}]}]` + "\n"
+	if buf.String() != expect {
+		t.Errorf("wrong json output: %s", buf.String())
+	}
+
+	buf.Reset()
+	err = ListFunctions("../cases/function.rdb", "csv", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = "library,engine,function,flags,description\nmylib,lua,myfunc,,\n"
+	if buf.String() != expect {
+		t.Errorf("wrong csv output: %s", buf.String())
+	}
+
+	buf.Reset()
+	err = ListFunctions("../cases/function.rdb", "resp", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = string(makeMultiBulkResp([][]byte{[]byte("FUNCTION"), []byte("LOAD"), []byte("REPLACE"), []byte(code)}))
+	if buf.String() != expect {
+		t.Errorf("wrong resp output: %q", buf.String())
+	}
+
+	buf.Reset()
+	err = ListFunctions("../cases/memory.rdb", "json", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("expect no libraries, actual %s", buf.String())
+	}
+
+	err = ListFunctions("../cases/function.rdb", "xml", buf)
+	if err == nil || !strings.Contains(err.Error(), "unknown format") {
+		t.Errorf("expect unknown format error, actual %v", err)
+	}
+	err = ListFunctions("", "json", buf)
+	if err == nil || err.Error() != "src file path is required" {
+		t.Error("failed when empty src")
+	}
+}
@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/core/modules"
+)
+
+// BuildIndex builds index file of rdb for GetKey, the index is saved as rdbFilename + ".idx"
+// Only ProgressOption is supported in options.
+func BuildIndex(rdbFilename string, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	return core.BuildIndex(rdbFilename, rdbFilename+core.IndexSuffix, indexOptions(options)...)
+}
+
+// indexOptions converts ProgressOption in options to options of core.BuildIndex
+func indexOptions(options []interface{}) []interface{} {
+	var result []interface{}
+	for _, opt := range options {
+		if o, ok := opt.(ProgressOption); ok {
+			result = append(result, core.ProgressHookFunc(o))
+		}
+	}
+	return result
+}
+
+// GetKey looks up a key by index file of rdb and writes it to output in json or resp format.
+// The index file is built at first lookup and rebuilt if rdb file has changed.
+// The invoker owns output, GetKey won't close it.
+// Only ProgressOption is supported in options, it reports progress of building index.
+func GetKey(rdbFilename string, db int, key string, format string, output io.Writer, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	if key == "" {
+		return errors.New("key is required")
+	}
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "resp" {
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	idx, err := core.OpenIndexed(rdbFilename, indexOptions(options)...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = idx.Close()
+	}()
+	for moduleType, handler := range modules.Handlers {
+		idx.WithSpecialType(moduleType, handler)
+	}
+	obj, err := idx.Get(db, key)
+	if err != nil {
+		return fmt.Errorf("get key failed: %v", err)
+	}
+	if obj == nil {
+		return fmt.Errorf("key %s not found in db %d", key, db)
+	}
+	if format == "resp" {
+		return WriteObjectToResp(output, obj)
+	}
+	data, err := jsonEncoder.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("json marshal failed: %v", err)
+	}
+	data = append(data, '\n')
+	_, err = output.Write(data)
+	return err
+}
@@ -53,14 +53,15 @@ func MemoryProfile(rdbFilename string, csvFilename string, options ...interface{
 		}
 		return expiration.Format(time.RFC3339)
 	}
-	return dec.Parse(func(object model.RedisObject) bool {
+	return parseObjectEnds(dec, func(event *core.ElementEvent) bool {
+		object := event.Object
 		err = csvWriter.Write([]string{
 			strconv.Itoa(object.GetDBIndex()),
 			object.GetKey(),
 			object.GetType(),
 			strconv.Itoa(object.GetSize()),
 			bytefmt.FormatSize(uint64(object.GetSize())),
-			strconv.Itoa(object.GetElemCount()),
+			strconv.Itoa(event.ElemCount),
 			object.GetEncoding(),
 			formatExpiration(object),
 		})
@@ -23,6 +23,9 @@ func TestMemoryProfile(t *testing.T) {
 		"stream_listpacks_2",
 		"set_listpack",
 		"listpack",
+		"rejson",
+		"redisbloom",
+		"timeseries",
 	}
 	for _, name := range testCases {
 		srcRdb := filepath.Join("../cases", name+".rdb")
@@ -42,6 +45,21 @@ func TestMemoryProfile(t *testing.T) {
 			t.Errorf("result is not equal of %s", srcRdb)
 			return
 		}
+
+		err = MemoryProfile(srcRdb, actualFile, WithParallel(4, true))
+		if err != nil {
+			t.Errorf("error occurs during parse %s in parallel, err: %v", srcRdb, err)
+			return
+		}
+		equals, err = compareFileByLine(t, actualFile, expectFile)
+		if err != nil {
+			t.Errorf("error occurs during compare %s, err: %v", srcRdb, err)
+			return
+		}
+		if !equals {
+			t.Errorf("result of parallel mode is not equal of %s", srcRdb)
+			return
+		}
 	}
 	err = MemoryProfile("../cases/memory.rdb", "")
 	if err == nil || err.Error() != "output file path is required" {
@@ -0,0 +1,526 @@
+package helper
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+// MergePolicy decides which one is kept when the same key exists in multiple inputs of MergeRDB
+type MergePolicy string
+
+const (
+	// MergeFirstWins keeps the key in the first input
+	MergeFirstWins MergePolicy = "first-wins"
+	// MergeLastWins keeps the key in the last input
+	MergeLastWins MergePolicy = "last-wins"
+	// MergeNewestExpireWins keeps the key expires latest, keys without expiration are considered the latest.
+	// If expirations are the same, the key in the later input is kept.
+	MergeNewestExpireWins MergePolicy = "newest-expire-wins"
+	// MergeError stops merging and returns an error
+	MergeError MergePolicy = "error"
+)
+
+// DBRemapOption moves keys of a database in an input into another database of output.
+// The expr is rules separated by ',' like "1:0=3,2:0=4", "1:0=3" means keys in db 0 of inputs[1] are moved into db 3.
+type DBRemapOption string
+
+// WithDBRemap moves keys of a database in an input into another database of output, see DBRemapOption
+func WithDBRemap(expr string) DBRemapOption {
+	return DBRemapOption(expr)
+}
+
+// dbRemapKey is a database in an input
+type dbRemapKey struct {
+	input int
+	db    int
+}
+
+func parseDBRemap(expr string, inputCount int) (map[dbRemapKey]int, error) {
+	result := make(map[dbRemapKey]int)
+	for _, rule := range strings.Split(expr, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		var input, from, to int
+		srcExpr, dstExpr, ok := strings.Cut(rule, "=")
+		inputExpr, fromExpr, ok2 := strings.Cut(srcExpr, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("illegal db remap rule: %s", rule)
+		}
+		var err error
+		if input, err = strconv.Atoi(strings.TrimSpace(inputExpr)); err != nil || input < 0 || input >= inputCount {
+			return nil, fmt.Errorf("illegal input index in db remap rule: %s", rule)
+		}
+		if from, err = strconv.Atoi(strings.TrimSpace(fromExpr)); err != nil || from < 0 {
+			return nil, fmt.Errorf("illegal db in db remap rule: %s", rule)
+		}
+		if to, err = strconv.Atoi(strings.TrimSpace(dstExpr)); err != nil || to < 0 {
+			return nil, fmt.Errorf("illegal db in db remap rule: %s", rule)
+		}
+		result[dbRemapKey{input: input, db: from}] = to
+	}
+	return result, nil
+}
+
+// mergeRunSize is the max estimated size of objects sorted in memory by MergeRDB, objects are spilled into a
+// sorted run file when it is exceeded.
+var mergeRunSize int64 = 256 * 1024 * 1024
+
+// mergeEntry is an object to be written into db of run file
+type mergeEntry struct {
+	db     int
+	object model.RedisObject
+}
+
+// MergeRDB merges rdb files into one rdb file, policy decides which one is kept when the same key exists in
+// the same database of multiple inputs (or of one input when databases are moved by DBRemapOption).
+// Aux fields come from the first input, functions come from all inputs and a library is written once even if
+// multiple inputs have it. Module aux data of a module type is written once too, the first input having it wins.
+// Keys are sorted externally: they are spilled into sorted run files in a temporary directory, and then run files
+// are merged, so memory usage is bounded. Keys are ordered by name in each database of output.
+// Values of module types are written as core.ModuleValues. It returns an error if a key is of a type Encoder cannot
+// write.
+// Only DBRemapOption and ProgressOption are supported in options, progress of all inputs is reported as a whole.
+func MergeRDB(inputs []string, output string, policy MergePolicy, options ...interface{}) error {
+	if len(inputs) == 0 {
+		return errors.New("src file path is required")
+	}
+	if output == "" {
+		return errors.New("output file path is required")
+	}
+	if policy == "" {
+		policy = MergeError
+	}
+	switch policy {
+	case MergeFirstWins, MergeLastWins, MergeNewestExpireWins, MergeError:
+	default:
+		return fmt.Errorf("unknown merge policy: %s", policy)
+	}
+	remap := make(map[dbRemapKey]int)
+	var progress core.ProgressHookFunc
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case DBRemapOption:
+			var err error
+			remap, err = parseDBRemap(string(o), len(inputs))
+			if err != nil {
+				return err
+			}
+		case ProgressOption:
+			progress = core.ProgressHookFunc(o)
+		}
+	}
+	outputFile, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("create output %s failed, %v", output, err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	tmpDir, err := os.MkdirTemp("", "rdb-merge-")
+	if err != nil {
+		return fmt.Errorf("create temp directory failed, %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	writer := bufio.NewWriter(outputFile)
+	enc := core.NewEncoder(writer).EnableCompress()
+	err = enc.WriteHeader()
+	if err != nil {
+		return err
+	}
+
+	// spill sorted runs
+	var runs []string
+	var buffer []*mergeEntry
+	var bufferSize int64
+	flush := func() error {
+		if len(buffer) == 0 {
+			return nil
+		}
+		// stable sort keeps order of inputs for the same key
+		sort.SliceStable(buffer, func(i, j int) bool {
+			if buffer[i].db != buffer[j].db {
+				return buffer[i].db < buffer[j].db
+			}
+			return buffer[i].object.GetKey() < buffer[j].object.GetKey()
+		})
+		filename := filepath.Join(tmpDir, strconv.Itoa(len(runs))+".rdb")
+		if err := writeMergeRun(filename, buffer); err != nil {
+			return fmt.Errorf("write run file %s failed: %v", filename, err)
+		}
+		runs = append(runs, filename)
+		buffer = nil
+		bufferSize = 0
+		return nil
+	}
+	libraries := make(map[string]struct{}) // a library is loaded once, the first input having it wins
+	var functions []string
+	// module aux data is written once for each module type and when, the first input having it wins
+	moduleAux := make(map[string]struct{})
+	var beforeAux, afterAux []*model.ModuleAuxObject
+	var inputProgress func(index int) core.ProgressHookFunc
+	if progress != nil {
+		inputProgress = mergeProgress(inputs, progress)
+	}
+	for i, input := range inputs {
+		var hook core.ProgressHookFunc
+		if inputProgress != nil {
+			hook = inputProgress(i)
+		}
+		err = spillMergeInput(i, input, remap, hook, func(object model.RedisObject, db int) error {
+			switch o := object.(type) {
+			case *model.AuxObject:
+				if i == 0 {
+					return enc.WriteAux(o.Key, o.Value)
+				}
+				return nil
+			case *model.FunctionsObject:
+				name := o.FunctionsLua
+				if len(o.Libraries) > 0 {
+					name = o.Libraries[0].Name
+				}
+				if _, ok := libraries[name]; ok {
+					return nil
+				}
+				libraries[name] = struct{}{}
+				functions = append(functions, o.FunctionsLua)
+				return nil
+			case *model.ModuleAuxObject:
+				name := fmt.Sprintf("%s %d", o.ModuleType, o.When)
+				if _, ok := moduleAux[name]; ok {
+					return nil
+				}
+				moduleAux[name] = struct{}{}
+				if o.When == model.ModuleAuxAfterRDB {
+					afterAux = append(afterAux, o)
+				} else {
+					beforeAux = append(beforeAux, o)
+				}
+				return nil
+			}
+			buffer = append(buffer, &mergeEntry{db: db, object: object})
+			bufferSize += int64(object.GetSize())
+			if bufferSize >= mergeRunSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = flush()
+	if err != nil {
+		return err
+	}
+	// module aux data and functions of all inputs are written after aux fields and before keys
+	for _, aux := range beforeAux {
+		if err = enc.WriteObject(aux); err != nil {
+			return err
+		}
+	}
+	for _, lua := range functions {
+		if err = enc.WriteFunctions(lua); err != nil {
+			return err
+		}
+	}
+
+	// count keys of each database for db size hints, then write them
+	keyCounts := make(map[int]uint64)
+	ttlCounts := make(map[int]uint64)
+	err = mergeRuns(runs, policy, func(object model.RedisObject) error {
+		keyCounts[object.GetDBIndex()]++
+		if object.GetExpiration() != nil {
+			ttlCounts[object.GetDBIndex()]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	currentDB := -1
+	err = mergeRuns(runs, policy, func(object model.RedisObject) error {
+		if db := object.GetDBIndex(); db != currentDB {
+			currentDB = db
+			if err := enc.WriteDBHeader(uint(db), keyCounts[db], ttlCounts[db]); err != nil {
+				return err
+			}
+		}
+		return enc.WriteObject(object, core.WithKeepEncoding())
+	})
+	if err != nil {
+		return err
+	}
+	for _, aux := range afterAux {
+		if err = enc.WriteObject(aux); err != nil {
+			return err
+		}
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return err
+	}
+	err = writer.Flush()
+	if err != nil {
+		return fmt.Errorf("write rdb failed: %v", err)
+	}
+	return nil
+}
+
+// mergeProgress returns a function making progress hook of the index-th input,
+// which reports bytes and objects of inputs before it as well
+func mergeProgress(inputs []string, progress core.ProgressHookFunc) func(index int) core.ProgressHookFunc {
+	sizes := make([]int64, len(inputs))
+	var total int64
+	for i, input := range inputs {
+		if info, err := os.Stat(input); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	var readBytes int64
+	var objects int
+	return func(index int) core.ProgressHookFunc {
+		if index > 0 {
+			readBytes += sizes[index-1]
+		}
+		baseBytes, baseObjects := readBytes, objects
+		return func(p core.Progress) {
+			objects = baseObjects + p.Objects
+			p.ReadBytes += baseBytes
+			p.Objects = objects
+			p.TotalBytes = total
+			progress(p)
+		}
+	}
+}
+
+// spillMergeInput reads aux fields, functions, module aux data and keys of input, and passes them to cb with the db in output.
+// progress may be nil.
+func spillMergeInput(index int, input string, remap map[dbRemapKey]int, progress core.ProgressHookFunc,
+	cb func(object model.RedisObject, db int) error) error {
+	rdbFile, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", input, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	dec := core.NewDecoder(rdbFile).WithSpecialOpCode().WithModuleValues()
+	if progress != nil {
+		dec.WithProgress(progress)
+	}
+	var cbErr error
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch object.(type) {
+		case *model.AuxObject, *model.FunctionsObject, *model.ModuleAuxObject:
+			cbErr = cb(object, 0)
+			return cbErr == nil
+		}
+		if !isKeyObject(object) {
+			return true
+		}
+		if !isWritable(object) {
+			cbErr = fmt.Errorf("cannot write %s object %s in %s", object.GetType(), object.GetKey(), input)
+			return false
+		}
+		db := object.GetDBIndex()
+		if to, ok := remap[dbRemapKey{input: index, db: db}]; ok {
+			db = to
+		}
+		cbErr = cb(object, db)
+		return cbErr == nil
+	})
+	if err != nil {
+		return fmt.Errorf("parse %s failed: %v", input, err)
+	}
+	return cbErr
+}
+
+// writeMergeRun writes sorted entries into a run file, which is an rdb file
+func writeMergeRun(filename string, entries []*mergeEntry) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	writer := bufio.NewWriter(file)
+	enc := core.NewEncoder(writer)
+	err = enc.WriteHeader()
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if i == 0 || entry.db != entries[i-1].db {
+			err = enc.WriteDBHeader(uint(entry.db), 0, 0)
+			if err != nil {
+				return err
+			}
+		}
+		err = enc.WriteObject(entry.object, core.WithKeepEncoding(), core.WithDB(entry.db))
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// mergeRunCursor reads objects of a run file in order
+type mergeRunCursor struct {
+	index   int
+	objects chan model.RedisObject
+	err     error // err is set before objects is closed
+	current model.RedisObject
+}
+
+func openMergeRun(ctx context.Context, index int, filename string) (*mergeRunCursor, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &mergeRunCursor{
+		index:   index,
+		objects: make(chan model.RedisObject, 64),
+	}
+	go func() {
+		defer func() {
+			_ = file.Close()
+		}()
+		cursor.err = core.NewDecoder(file).WithModuleValues().ParseContext(ctx, func(object model.RedisObject) bool {
+			select {
+			case cursor.objects <- object:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		close(cursor.objects)
+	}()
+	return cursor, nil
+}
+
+// next reads next object into current, returns false at the end of run
+func (c *mergeRunCursor) next() (bool, error) {
+	object, ok := <-c.objects
+	if !ok {
+		return false, c.err
+	}
+	c.current = object
+	return true, nil
+}
+
+// mergeRunHeap orders cursors by db and key of current object, and then the order of runs
+type mergeRunHeap []*mergeRunCursor
+
+func (h mergeRunHeap) Len() int { return len(h) }
+
+func (h mergeRunHeap) Less(i, j int) bool {
+	a, b := h[i].current, h[j].current
+	if a.GetDBIndex() != b.GetDBIndex() {
+		return a.GetDBIndex() < b.GetDBIndex()
+	}
+	if a.GetKey() != b.GetKey() {
+		return a.GetKey() < b.GetKey()
+	}
+	return h[i].index < h[j].index
+}
+
+func (h mergeRunHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeRunHeap) Push(x interface{}) { *h = append(*h, x.(*mergeRunCursor)) }
+
+func (h *mergeRunHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// mergeRuns merges run files and passes kept objects to cb in order of db and key
+func mergeRuns(runs []string, policy MergePolicy, cb func(object model.RedisObject) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := make(mergeRunHeap, 0, len(runs))
+	for i, run := range runs {
+		cursor, err := openMergeRun(ctx, i, run)
+		if err != nil {
+			return err
+		}
+		ok, err := cursor.next()
+		if err != nil {
+			return fmt.Errorf("read run file %s failed: %v", run, err)
+		}
+		if ok {
+			h = append(h, cursor)
+		}
+	}
+	heap.Init(&h)
+	var kept model.RedisObject
+	for h.Len() > 0 {
+		cursor := h[0]
+		object := cursor.current
+		if kept != nil && (kept.GetDBIndex() != object.GetDBIndex() || kept.GetKey() != object.GetKey()) {
+			if err := cb(kept); err != nil {
+				return err
+			}
+			kept = nil
+		}
+		if kept == nil {
+			kept = object
+		} else {
+			switch policy {
+			case MergeLastWins:
+				kept = object
+			case MergeNewestExpireWins:
+				if expireAt(object) >= expireAt(kept) {
+					kept = object
+				}
+			case MergeError:
+				return fmt.Errorf("duplicate key %s in db %d", object.GetKey(), object.GetDBIndex())
+			}
+		}
+		ok, err := cursor.next()
+		if err != nil {
+			return fmt.Errorf("read run file %s failed: %v", runs[cursor.index], err)
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	if kept != nil {
+		return cb(kept)
+	}
+	return nil
+}
+
+// expireAt returns expiration of object in unix milliseconds, math.MaxInt64 if it never expires
+func expireAt(object model.RedisObject) int64 {
+	if expiration := object.GetExpiration(); expiration != nil {
+		return expiration.UnixNano() / 1e6
+	}
+	return math.MaxInt64
+}
@@ -0,0 +1,197 @@
+package helper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+type mergeTestDB struct {
+	index   uint
+	strings [][3]string // key, value and expiration in milliseconds
+}
+
+func writeMergeTestRDB(t *testing.T, filename string, version string, dbs []mergeTestDB) {
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	enc := core.NewEncoder(file)
+	if err = enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err = enc.WriteAux("redis-ver", version); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range dbs {
+		if err = enc.WriteDBHeader(db.index, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range db.strings {
+			var options []interface{}
+			if kv[2] != "" {
+				var expiration uint64
+				_, _ = fmt.Sscan(kv[2], &expiration)
+				options = append(options, core.WithTTL(expiration))
+			}
+			if err = enc.WriteStringObject(kv[0], []byte(kv[1]), options...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readMergeResult returns "db key" -> value, aux fields and db size hints
+func readMergeResult(t *testing.T, filename string) (map[string]string, map[string]string, map[int][2]uint64) {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	values := make(map[string]string)
+	aux := make(map[string]string)
+	sizes := make(map[int][2]uint64)
+	err = core.NewDecoder(file).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.AuxObject:
+			aux[o.Key] = o.Value
+		case *model.DBSizeObject:
+			sizes[o.DB] = [2]uint64{o.KeyCount, o.TTLCount}
+		case *model.StringObject:
+			value := string(o.Value)
+			if o.Expiration != nil {
+				value += fmt.Sprintf("@%d", o.Expiration.UnixNano()/1e6)
+			}
+			values[fmt.Sprintf("%d %s", o.DB, o.Key)] = value
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values, aux, sizes
+}
+
+func TestMergeRDB(t *testing.T) {
+	dir := t.TempDir()
+	src1 := filepath.Join(dir, "src1.rdb")
+	src2 := filepath.Join(dir, "src2.rdb")
+	writeMergeTestRDB(t, src1, "7.0.0", []mergeTestDB{
+		{index: 0, strings: [][3]string{{"a", "1", "4102444800000"}, {"b", "1", ""}}},
+		{index: 1, strings: [][3]string{{"c", "1", ""}}},
+	})
+	writeMergeTestRDB(t, src2, "6.0.0", []mergeTestDB{
+		{index: 0, strings: [][3]string{{"d", "2", ""}, {"a", "2", "4102444700000"}}},
+		{index: 1, strings: [][3]string{{"b", "2", "4102444800000"}}},
+	})
+	inputs := []string{src1, src2}
+	remap := WithDBRemap("1:1=0")
+	expectValues := map[MergePolicy]map[string]string{
+		MergeFirstWins: {
+			"0 a": "1@4102444800000", "0 b": "1", "0 d": "2", "1 c": "1",
+		},
+		MergeLastWins: {
+			"0 a": "2@4102444700000", "0 b": "2@4102444800000", "0 d": "2", "1 c": "1",
+		},
+		MergeNewestExpireWins: {
+			"0 a": "1@4102444800000", "0 b": "1", "0 d": "2", "1 c": "1",
+		},
+	}
+	defer func(size int64) {
+		mergeRunSize = size
+	}(mergeRunSize)
+	for _, runSize := range []int64{mergeRunSize, 1} {
+		mergeRunSize = runSize
+		for policy, expect := range expectValues {
+			output := filepath.Join(dir, string(policy)+".rdb")
+			var last core.Progress
+			err := MergeRDB(inputs, output, policy, remap, WithProgress(func(progress core.Progress) {
+				last = progress
+			}))
+			if err != nil {
+				t.Fatalf("%s: %v", policy, err)
+			}
+			if last.TotalBytes == 0 || last.ReadBytes != last.TotalBytes || last.Objects < 5 {
+				t.Errorf("%s: progress should end at all inputs: %+v", policy, last)
+			}
+			values, aux, sizes := readMergeResult(t, output)
+			if !reflect.DeepEqual(values, expect) {
+				t.Errorf("%s: expect %v, actual %v", policy, expect, values)
+			}
+			if aux["redis-ver"] != "7.0.0" {
+				t.Errorf("%s: aux fields should come from the first input: %v", policy, aux)
+			}
+			ttlCount := uint64(1)
+			if policy == MergeLastWins {
+				ttlCount = 2
+			}
+			expectSizes := map[int][2]uint64{0: {3, ttlCount}, 1: {1, 0}}
+			if !reflect.DeepEqual(sizes, expectSizes) {
+				t.Errorf("%s: expect db sizes %v, actual %v", policy, expectSizes, sizes)
+			}
+		}
+	}
+
+	output := filepath.Join(dir, "merged.rdb")
+	err := MergeRDB(inputs, output, MergeError, remap)
+	if err == nil {
+		t.Error("expect error for duplicate keys")
+	}
+	err = MergeRDB(inputs, output, MergeError)
+	if err == nil {
+		t.Error("expect error for duplicate keys")
+	}
+	err = MergeRDB([]string{src1}, output, MergeError, WithDBRemap("0:1=0"))
+	if err != nil {
+		t.Error(err)
+	}
+	values, _, _ := readMergeResult(t, output)
+	if !reflect.DeepEqual(values, map[string]string{"0 a": "1@4102444800000", "0 b": "1", "0 c": "1"}) {
+		t.Errorf("wrong result of db remap: %v", values)
+	}
+	err = MergeRDB(inputs, output, "unknown")
+	if err == nil {
+		t.Error("expect error for unknown policy")
+	}
+	err = MergeRDB(inputs, output, MergeError, WithDBRemap("2:0=1"))
+	if err == nil {
+		t.Error("expect error for illegal db remap")
+	}
+	err = MergeRDB(nil, output, MergeError)
+	if err == nil {
+		t.Error("expect error for empty src")
+	}
+}
+
+func TestMergeRDBWithModules(t *testing.T) {
+	var inputs []string
+	expect := make(map[string]string)
+	for _, name := range moduleCases {
+		input := filepath.Join("../cases", name+".rdb")
+		inputs = append(inputs, input)
+		for key, value := range readModuleValues(t, input) {
+			expect[key] = value
+		}
+	}
+	output := filepath.Join(t.TempDir(), "merged.rdb")
+	err := MergeRDB(inputs, output, MergeError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := readModuleValues(t, output); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("values of module types or module aux data are not kept, expect %v, actual %v", expect, actual)
+	}
+}
@@ -0,0 +1,285 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+// RenameOption rewrites key names by regex replacement in ToRDB
+type RenameOption struct {
+	pattern     string
+	replacement string
+}
+
+// WithRenameOption creates a RenameOption, the first match of pattern in key is replaced by replacement,
+// in which $1 or ${name} stands for submatches like regexp.Regexp.Expand. Keys not matching pattern are not changed.
+func WithRenameOption(pattern string, replacement string) RenameOption {
+	return RenameOption{
+		pattern:     pattern,
+		replacement: replacement,
+	}
+}
+
+// TargetVersionOption sets rdb version of output file in ToRDB, see core.NewEncoderWithVersion
+type TargetVersionOption int
+
+// WithTargetVersion sets rdb version of output file in ToRDB, for example 9 for redis 5.0 and 6.x
+func WithTargetVersion(version int) TargetVersionOption {
+	return TargetVersionOption(version)
+}
+
+// DialectOption sets dialect of output file in ToRDB, redis or valkey
+type DialectOption string
+
+const (
+	// DialectRedis writes rdb file of redis
+	DialectRedis DialectOption = "redis"
+	// DialectValkey writes rdb file of valkey 9+, whose rdb version is core.ValkeyEncoderVersion
+	DialectValkey DialectOption = "valkey"
+)
+
+// WithDialect sets dialect of output file in ToRDB, ToRDB keeps dialect of input file by default.
+// Hashes with field expiration are written in format of the dialect, slot info and slot import jobs of valkey
+// are dropped when writing redis rdb.
+func WithDialect(dialect string) DialectOption {
+	return DialectOption(dialect)
+}
+
+// StreamDowngradeOption makes ToRDB downgrade streams to the format of target version, see WithStreamDowngrade
+type StreamDowngradeOption bool
+
+// WithStreamDowngrade makes ToRDB write streams in the latest format of target version, like core.WithStreamDowngrade.
+// Metadata added by later formats is dropped, for example, entries read of consumer groups before version 11.
+// ToRDB returns an error for streams of later formats by default.
+func WithStreamDowngrade() StreamDowngradeOption {
+	return true
+}
+
+// RawCopyOption makes ToRDB and SplitRDB copy values verbatim, see WithRawCopy
+type RawCopyOption bool
+
+// WithRawCopy makes ToRDB and SplitRDB copy encoded values of keys verbatim without decoding them, which is much
+// faster and keeps encodings and LZF compression of values. Keys of module types are copied too.
+// It cannot convert between redis and valkey, and returns an error if a value type is not supported by
+// TargetVersionOption. Sizes used by SizeOption are evaluated like metadata only mode.
+func WithRawCopy() RawCopyOption {
+	return true
+}
+
+// newTargetEncoder creates encoder by dialect and version of output, it returns whether output is valkey rdb
+func newTargetEncoder(writer io.Writer, dialect DialectOption, version TargetVersionOption,
+	srcValkey bool) (*core.Encoder, bool, error) {
+	switch dialect {
+	case "":
+		if version == 0 && srcValkey {
+			return core.NewEncoderValkey(writer), true, nil
+		}
+	case DialectValkey:
+		if version == 0 {
+			version = core.ValkeyEncoderVersion
+		} else if version != core.ValkeyEncoderVersion {
+			return nil, false, fmt.Errorf("rdb version of valkey dialect should be %d", core.ValkeyEncoderVersion)
+		}
+	case DialectRedis:
+		if version == core.ValkeyEncoderVersion {
+			return nil, false, fmt.Errorf("rdb version %d is not of redis dialect", version)
+		}
+	default:
+		return nil, false, fmt.Errorf("unknown dialect: %s", dialect)
+	}
+	if version > 0 {
+		enc, err := core.NewEncoderWithVersion(writer, int(version))
+		return enc, version == core.ValkeyEncoderVersion, err
+	}
+	return core.NewEncoder(writer), false, nil
+}
+
+// isValkeyRDB returns whether rdb file begins with magic string of valkey
+func isValkeyRDB(file io.ReaderAt) bool {
+	magic := make([]byte, 6)
+	_, err := file.ReadAt(magic, 0)
+	return err == nil && string(magic) == "VALKEY"
+}
+
+// keyDecoder passes keys to callback of Parse, and global metadata like aux fields to onMeta,
+// so that filters of wrapDecoder only apply to keys
+type keyDecoder struct {
+	dec    decoder
+	onMeta func(object model.RedisObject) bool
+}
+
+func (d *keyDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	return d.dec.Parse(func(object model.RedisObject) bool {
+		if !isKeyObject(object) {
+			return d.onMeta(object)
+		}
+		return cb(object)
+	})
+}
+
+// ToRDB reads rdb file and writes keys which pass the filters into a new rdb file.
+// Keys keep their expiration, LRU/LFU info, metadata and encodings if possible, aux fields, functions and module aux
+// data are kept too.
+// Values of module types are written as core.ModuleValues. It returns an error if a key is of a type Encoder cannot
+// write, unless RawCopyOption is set.
+// Supported options: RegexOption, ExcludeRegexOption, DBOption, NoExpiredOption, ExpirationOption, SizeOption,
+// RenameOption, TargetVersionOption, DialectOption, RawCopyOption, StreamDowngradeOption and ProgressOption.
+// Renamed keys are not checked for duplication.
+// With TargetVersionOption, it returns an error if a key cannot be represented in the version.
+// Slot info of valkey is kept as hints of following keys, though numbers in it are not updated after filtering.
+func ToRDB(rdbFilename string, outputFilename string, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	if outputFilename == "" {
+		return errors.New("output file path is required")
+	}
+	var renameReg *regexp.Regexp
+	var replacement string
+	var targetVersion TargetVersionOption
+	var dialect DialectOption
+	var rawCopy RawCopyOption
+	writeOptions := []interface{}{core.WithKeepEncoding()}
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case TargetVersionOption:
+			targetVersion = o
+		case StreamDowngradeOption:
+			writeOptions = append(writeOptions, core.WithStreamDowngrade())
+		case DialectOption:
+			dialect = o
+		case RawCopyOption:
+			rawCopy = o
+		case RenameOption:
+			var err error
+			renameReg, err = regexp.Compile(o.pattern)
+			if err != nil {
+				return fmt.Errorf("illegal regex expression: %v", o.pattern)
+			}
+			replacement = o.replacement
+		}
+	}
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return fmt.Errorf("create output %s failed, %v", outputFilename, err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	writer := bufio.NewWriter(outputFile)
+	srcValkey := isValkeyRDB(rdbFile)
+	enc, valkey, err := newTargetEncoder(writer, dialect, targetVersion, srcValkey)
+	if err != nil {
+		return err
+	}
+	if rawCopy && valkey != srcValkey {
+		return errors.New("raw copy cannot convert between redis and valkey")
+	}
+	enc.EnableCompress()
+	err = enc.WriteHeader()
+	if err != nil {
+		return err
+	}
+
+	inner := core.NewDecoder(rdbFile).WithSpecialOpCode().WithModuleValues()
+	if rawCopy {
+		inner.WithRawValue().WithMetadataOnly()
+	}
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case ProgressOption:
+			inner.WithProgress(core.ProgressHookFunc(o))
+		}
+	}
+	var writeErr error
+	var slotInfo *model.SlotInfoObject // slotInfo is written before the next key, it is dropped if there is none
+	var dec decoder = &keyDecoder{
+		dec: inner,
+		onMeta: func(object model.RedisObject) bool {
+			switch o := object.(type) {
+			case *model.AuxObject:
+				writeErr = enc.WriteAux(o.Key, o.Value)
+			case *model.FunctionsObject:
+				writeErr = enc.WriteFunctions(o.FunctionsLua)
+			case *model.ModuleAuxObject:
+				writeErr = enc.WriteObject(o)
+			case *model.SlotImportObject:
+				if valkey {
+					writeErr = enc.WriteSlotImport(o.JobName, o.Ranges)
+				}
+			case *model.SlotInfoObject:
+				if valkey {
+					slotInfo = o
+				}
+			}
+			return writeErr == nil
+		},
+	}
+	dec, err = wrapDecoder(dec, options...)
+	if err != nil {
+		return err
+	}
+	currentDB := -1
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if !isWritable(object) {
+			writeErr = fmt.Errorf("cannot write %s object %s", object.GetType(), object.GetKey())
+			return false
+		}
+		if db := object.GetDBIndex(); db != currentDB {
+			currentDB = db
+			if writeErr = enc.WriteDBHeader(uint(db), 0, 0); writeErr != nil {
+				return false
+			}
+		}
+		if slotInfo != nil && slotInfo.DB == currentDB {
+			writeErr = enc.WriteSlotInfo(slotInfo.Slot, slotInfo.KeyCount, slotInfo.ExpiresCount)
+			if writeErr != nil {
+				return false
+			}
+		}
+		slotInfo = nil
+		key := object.GetKey()
+		if renameReg != nil {
+			if match := renameReg.FindStringSubmatchIndex(key); match != nil {
+				var dst []byte
+				dst = append(dst, key[:match[0]]...)
+				dst = renameReg.ExpandString(dst, replacement, key, match)
+				key = string(append(dst, key[match[1]:]...))
+			}
+		}
+		writeErr = enc.WriteObject(object, append(writeOptions, core.WithKey(key))...)
+		if writeErr != nil {
+			writeErr = fmt.Errorf("write %s failed: %v", object.GetKey(), writeErr)
+		}
+		return writeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("write rdb failed: %v", writeErr)
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return err
+	}
+	err = writer.Flush()
+	if err != nil {
+		return fmt.Errorf("write rdb failed: %v", err)
+	}
+	return nil
+}
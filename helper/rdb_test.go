@@ -0,0 +1,426 @@
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+// readEncodings returns "db key" -> encoding of keys in rdb file
+func readEncodings(t *testing.T, filename string) map[string]string {
+	rdbFile, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	result := make(map[string]string)
+	err = core.NewDecoder(rdbFile).Parse(func(object model.RedisObject) bool {
+		result[fmt.Sprintf("%d %s", object.GetDBIndex(), object.GetKey())] = object.GetEncoding()
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestToRDB(t *testing.T) {
+	dir := t.TempDir()
+	src := "../cases/memory.rdb"
+	output := filepath.Join(dir, "memory.rdb")
+	err := ToRDB(src, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect, actual := readElemCounts(t, src), readElemCounts(t, output); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("expect %v, actual %v", expect, actual)
+	}
+	if expect, actual := readEncodings(t, src), readEncodings(t, output); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("encodings are not kept, expect %v, actual %v", expect, actual)
+	}
+
+	err = ToRDB(src, output, WithNoExpiredOption(), WithExcludeRegexOption("^l"),
+		WithRenameOption("^(hash|zset)$", "${1}:renamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"0 hash:renamed": model.ZipListEncoding,
+		"0 zset:renamed": model.ZipListEncoding,
+		"0 s":            model.StringEncoding,
+		"0 set":          model.SetEncoding,
+	}
+	if actual := readEncodings(t, output); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("expect %v, actual %v", expect, actual)
+	}
+
+	err = ToRDB("../cases/multiple_databases.rdb", output, WithDBOption(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = map[string]string{"2 key_in_second_database": model.StringEncoding}
+	if actual := readEncodings(t, output); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("expect %v, actual %v", expect, actual)
+	}
+
+	err = ToRDB(src, output, WithRenameOption("(", ""))
+	if err == nil {
+		t.Error("expect error for illegal regex")
+	}
+	err = ToRDB("", output)
+	if err == nil {
+		t.Error("expect error for empty src")
+	}
+	err = ToRDB(src, "")
+	if err == nil {
+		t.Error("expect error for empty output")
+	}
+}
+
+// moduleCases are rdb files with keys of module types or module aux data
+var moduleCases = []string{"rejson", "redisbloom", "timeseries", "module_aux"}
+
+// readModuleValues returns "db key" -> module type and values of keys of module types in rdb file,
+// and "aux type when" -> values of module aux data
+func readModuleValues(t *testing.T, filename string) map[string]string {
+	rdbFile, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	result := make(map[string]string)
+	err = core.NewDecoder(rdbFile).WithSpecialOpCode().WithModuleValues().Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.ModuleTypeObject:
+			result[fmt.Sprintf("%d %s", o.DB, o.Key)] = fmt.Sprintf("%s %v", o.ModuleType, o.Value)
+		case *model.ModuleAuxObject:
+			result[fmt.Sprintf("aux %s %d", o.ModuleType, o.When)] = fmt.Sprintf("%v", o.Value)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestToRDBWithModules(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range moduleCases {
+		src := filepath.Join("../cases", name+".rdb")
+		output := filepath.Join(dir, name+".rdb")
+		err := ToRDB(src, output)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		expect, actual := readModuleValues(t, src), readModuleValues(t, output)
+		if len(expect) == 0 || !reflect.DeepEqual(expect, actual) {
+			t.Errorf("%s: values of module types or module aux data are not kept, expect %v, actual %v", name, expect, actual)
+		}
+	}
+}
+
+// readKeyMetas returns "db key" -> metadata of keys in rdb file
+func readKeyMetas(t *testing.T, filename string) map[string][]*model.KeyMeta {
+	rdbFile, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	result := make(map[string][]*model.KeyMeta)
+	err = core.NewDecoder(rdbFile).WithModuleValues().Parse(func(object model.RedisObject) bool {
+		result[fmt.Sprintf("%d %s", object.GetDBIndex(), object.GetKey())] = object.(model.KeyMetaInfo).GetMetadata()
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestToRDBWithKeyMeta(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"key_meta_12", "key_meta_13"} {
+		src := filepath.Join("../cases", name+".rdb")
+		output := filepath.Join(dir, name+".rdb")
+		err := ToRDB(src, output, WithRenameOption("^user:", "u:"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := make(map[string][]*model.KeyMeta)
+		for key, metas := range readKeyMetas(t, src) {
+			expect[strings.Replace(key, " user:", " u:", 1)] = metas
+		}
+		actual := readKeyMetas(t, output)
+		if len(expect["0 u:1"]) == 0 || !reflect.DeepEqual(expect, actual) {
+			t.Errorf("%s: key metadata is not kept, expect %v, actual %v", name, expect, actual)
+		}
+	}
+}
+
+func TestToRDBWithTargetVersion(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "stream.rdb")
+	err := ToRDB("../cases/stream_listpacks_2.rdb", output, WithTargetVersion(9))
+	if err == nil || !strings.Contains(err.Error(), "stream version 2 requires rdb version 10") {
+		t.Errorf("expect error for stream version 2 in rdb version 9, actual %v", err)
+	}
+	err = ToRDB("../cases/stream_listpacks_2.rdb", output, WithTargetVersion(9), WithStreamDowngrade())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "REDIS0009") {
+		t.Errorf("wrong header: %q", data[:9])
+	}
+	if expect, actual := readElemCounts(t, "../cases/stream_listpacks_2.rdb"), readElemCounts(t, output); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("expect %v, actual %v", expect, actual)
+	}
+	err = ToRDB("../cases/stream_listpacks_2.rdb", output, WithTargetVersion(8))
+	if err == nil || !strings.Contains(err.Error(), "stream requires rdb version 9") {
+		t.Errorf("expect error for stream in rdb version 8, actual %v", err)
+	}
+	err = ToRDB("../cases/hash_with_hfe.rdb", output, WithTargetVersion(11))
+	if err == nil {
+		t.Error("expect error for hash field expiration in rdb version 11")
+	}
+	err = ToRDB("../cases/memory.rdb", output, WithTargetVersion(5))
+	if err == nil {
+		t.Error("expect error for unsupported version")
+	}
+}
+
+// readObjects returns keys, slot info and slot import jobs in rdb file, the rdb file should begin with header
+func readObjects(t *testing.T, filename string, header string) []model.RedisObject {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), header) {
+		t.Errorf("%s: expect header %s, actual %q", filename, header, data[:9])
+	}
+	var objects []model.RedisObject
+	err = core.NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+		switch object.(type) {
+		case *model.AuxObject, *model.DBSizeObject:
+			return true
+		}
+		// size is an estimate depending on encoding
+		reflect.ValueOf(object).Elem().FieldByName("BaseObject").Elem().FieldByName("Size").SetInt(0)
+		objects = append(objects, object)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestToRDBWithDialect(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"valkey_hash2_with_hfe", "hash_with_hfe", "valkey_slots"} {
+		src := filepath.Join("../cases", name+".rdb")
+		srcHeader := "REDIS"
+		if strings.HasPrefix(name, "valkey") {
+			srcHeader = "VALKEY080"
+		}
+		expect := readObjects(t, src, srcHeader)
+		// the same dialect as input by default
+		output := filepath.Join(dir, name+".rdb")
+		err := ToRDB(src, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := readObjects(t, output, srcHeader); !reflect.DeepEqual(expect, actual) {
+			t.Errorf("%s: objects are not kept", name)
+		}
+
+		redisOutput := filepath.Join(dir, name+".redis.rdb")
+		err = ToRDB(src, redisOutput, WithDialect("redis"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectKeys []model.RedisObject
+		for _, object := range expect {
+			if isKeyObject(object) {
+				expectKeys = append(expectKeys, object)
+			}
+		}
+		if actual := readObjects(t, redisOutput, "REDIS"); !reflect.DeepEqual(expectKeys, actual) {
+			t.Errorf("%s: wrong objects in redis dialect: %v", name, actual)
+		}
+
+		valkeyOutput := filepath.Join(dir, name+".valkey.rdb")
+		err = ToRDB(redisOutput, valkeyOutput, WithDialect("valkey"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := readObjects(t, valkeyOutput, "VALKEY080"); !reflect.DeepEqual(expectKeys, actual) {
+			t.Errorf("%s: wrong objects in valkey dialect: %v", name, actual)
+		}
+	}
+
+	output := filepath.Join(dir, "slots.rdb")
+	err := ToRDB("../cases/valkey_slots.rdb", output, WithExcludeRegexOption("^bar$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slots []int
+	for _, object := range readObjects(t, output, "VALKEY080") {
+		if info, ok := object.(*model.SlotInfoObject); ok {
+			slots = append(slots, info.Slot)
+		}
+	}
+	if !reflect.DeepEqual(slots, []int{5474, 12182}) {
+		t.Errorf("slot info of filtered keys should be dropped, actual %v", slots)
+	}
+	err = ToRDB("../cases/memory.rdb", output, WithDialect("valkey"), WithTargetVersion(9))
+	if err == nil {
+		t.Error("expect error for rdb version 9 of valkey")
+	}
+	err = ToRDB("../cases/memory.rdb", output, WithDialect("keydb"))
+	if err == nil {
+		t.Error("expect error for unknown dialect")
+	}
+}
+
+// readFunctions returns source code of function libraries in rdb file
+func readFunctions(t *testing.T, filename string) []string {
+	rdbFile, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	var functions []string
+	err = core.NewDecoder(rdbFile).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+		if o, ok := object.(*model.FunctionsObject); ok {
+			functions = append(functions, o.FunctionsLua)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return functions
+}
+
+func TestCopyFunctions(t *testing.T) {
+	dir := t.TempDir()
+	src := "../cases/function.rdb"
+	expect := readFunctions(t, src)
+	if len(expect) == 0 {
+		t.Fatal("no functions in source")
+	}
+	output := filepath.Join(dir, "function.rdb")
+	err := ToRDB(src, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := readFunctions(t, output); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("rdb: expect functions %v, actual %v", expect, actual)
+	}
+	err = MergeRDB([]string{src, output}, output+".merged", MergeFirstWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := readFunctions(t, output+".merged"); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("merge: expect functions %v, actual %v", expect, actual)
+	}
+	err = SplitRDB(src, []*SlotNode{{Name: "a", Ranges: []model.SlotRange{{From: 0, To: 16383}}}}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := readFunctions(t, filepath.Join(dir, "a.rdb")); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("split: expect functions %v, actual %v", expect, actual)
+	}
+	err = ToRDB(src, output, WithTargetVersion(9))
+	if err == nil {
+		t.Error("expect error for functions in rdb version 9")
+	}
+}
+
+// readRawValues returns "db key" -> type byte and encoded value of keys in rdb file
+func readRawValues(t *testing.T, filename string) map[string]string {
+	rdbFile, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	result := make(map[string]string)
+	err = core.NewDecoder(rdbFile).WithRawValue().WithMetadataOnly().Parse(func(object model.RedisObject) bool {
+		rawType, rawValue := object.(model.RawValueInfo).GetRawValue()
+		result[fmt.Sprintf("%d %s", object.GetDBIndex(), object.GetKey())] = string(append([]byte{rawType}, rawValue...))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestToRDBWithRawCopy(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"memory", "redisbloom", "ziplist_that_compresses_easily", "valkey_hash2_with_hfe"} {
+		src := filepath.Join("../cases", name+".rdb")
+		output := filepath.Join(dir, name+".rdb")
+		err := ToRDB(src, output, WithRawCopy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect, actual := readRawValues(t, src), readRawValues(t, output); !reflect.DeepEqual(expect, actual) {
+			t.Errorf("%s: values are not copied verbatim", name)
+		}
+	}
+	output := filepath.Join(dir, "filtered.rdb")
+	err := ToRDB("../cases/memory.rdb", output, WithRawCopy(), WithExcludeRegexOption("^l"),
+		WithRenameOption("^(hash|zset)$", "${1}:renamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := readRawValues(t, "../cases/memory.rdb")
+	actual := readRawValues(t, output)
+	for key, value := range expect {
+		if strings.HasPrefix(key, "0 l") {
+			continue
+		}
+		if key == "0 hash" || key == "0 zset" {
+			key += ":renamed"
+		}
+		if actual[key] != value {
+			t.Errorf("value of %s is not copied verbatim", key)
+		}
+		delete(actual, key)
+	}
+	if len(actual) > 0 {
+		t.Errorf("unexpected keys: %v", actual)
+	}
+
+	err = ToRDB("../cases/listpack.rdb", output, WithRawCopy(), WithTargetVersion(9))
+	if err == nil {
+		t.Error("expect error for listpack in rdb version 9")
+	}
+	err = ToRDB("../cases/valkey_hash2_with_hfe.rdb", output, WithRawCopy(), WithDialect("redis"))
+	if err == nil {
+		t.Error("expect error for converting dialect")
+	}
+}
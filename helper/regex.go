@@ -11,6 +11,7 @@ import (
 
 	"github.com/hdt3213/rdb/bytefmt"
 	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/core/modules"
 	"github.com/hdt3213/rdb/model"
 )
 
@@ -19,19 +20,26 @@ type decoder interface {
 }
 
 type regexDecoder struct {
-	reg *regexp.Regexp
-	dec decoder
+	reg     *regexp.Regexp
+	dec     decoder
+	exclude bool // exclude returns keys not matching reg
 }
 
 func (d *regexDecoder) Parse(cb func(object model.RedisObject) bool) error {
 	return d.dec.Parse(func(object model.RedisObject) bool {
-		if d.reg.MatchString(object.GetKey()) {
+		if d.reg.MatchString(object.GetKey()) != d.exclude {
 			return cb(object)
 		}
 		return true
 	})
 }
 
+func (d *regexDecoder) ParseElements(cb func(event *core.ElementEvent) bool) error {
+	return filterElements(d.dec, func(object model.RedisObject) bool {
+		return d.reg.MatchString(object.GetKey()) != d.exclude
+	}, cb)
+}
+
 // regexWrapper returns
 func regexWrapper(d decoder, expr string) (*regexDecoder, error) {
 	reg, err := regexp.Compile(expr)
@@ -52,6 +60,43 @@ func WithRegexOption(expr string) RegexOption {
 	return &expr
 }
 
+// ExcludeRegexOption filters out keys matching regex expression
+type ExcludeRegexOption *string
+
+// WithExcludeRegexOption creates an ExcludeRegexOption from regex expression
+func WithExcludeRegexOption(expr string) ExcludeRegexOption {
+	return &expr
+}
+
+// dbDecoder returns entries in the db
+type dbDecoder struct {
+	dec decoder
+	db  int
+}
+
+func (d *dbDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	return d.dec.Parse(func(object model.RedisObject) bool {
+		if object.GetDBIndex() == d.db {
+			return cb(object)
+		}
+		return true
+	})
+}
+
+func (d *dbDecoder) ParseElements(cb func(event *core.ElementEvent) bool) error {
+	return filterElements(d.dec, func(object model.RedisObject) bool {
+		return object.GetDBIndex() == d.db
+	}, cb)
+}
+
+// DBOption filters keys by index of db
+type DBOption *int
+
+// WithDBOption creates a DBOption, only keys in the db are returned
+func WithDBOption(db int) DBOption {
+	return &db
+}
+
 // noExpiredDecoder filter all expired keys
 type noExpiredDecoder struct {
 	dec decoder
@@ -68,6 +113,14 @@ func (d *noExpiredDecoder) Parse(cb func(object model.RedisObject) bool) error {
 	})
 }
 
+func (d *noExpiredDecoder) ParseElements(cb func(event *core.ElementEvent) bool) error {
+	now := time.Now()
+	return filterElements(d.dec, func(object model.RedisObject) bool {
+		expiration := object.GetExpiration()
+		return expiration == nil || expiration.After(now)
+	}, cb)
+}
+
 // NoExpiredOption tells decoder to filter all expired keys
 type NoExpiredOption bool
 
@@ -101,17 +154,26 @@ type expirationDecoder struct {
 
 func (d *expirationDecoder) Parse(cb func(object model.RedisObject) bool) error {
 	return d.dec.Parse(func(object model.RedisObject) bool {
-		expiration := object.GetExpiration()
-		if expiration != nil {
-			timestamp := expiration.Unix()
-			if timestamp >= d.expirationRange[0] && timestamp <= d.expirationRange[1] {
-				return cb(object)
-			}
+		if d.match(object) {
+			return cb(object)
 		}
 		return true
 	})
 }
 
+func (d *expirationDecoder) ParseElements(cb func(event *core.ElementEvent) bool) error {
+	return filterElements(d.dec, d.match, cb)
+}
+
+func (d *expirationDecoder) match(object model.RedisObject) bool {
+	expiration := object.GetExpiration()
+	if expiration == nil {
+		return false
+	}
+	timestamp := expiration.Unix()
+	return timestamp >= d.expirationRange[0] && timestamp <= d.expirationRange[1]
+}
+
 // sizeDecoder returns entries with size within the range.
 type sizeDecoder struct {
 	dec       decoder
@@ -120,14 +182,34 @@ type sizeDecoder struct {
 
 func (d *sizeDecoder) Parse(cb func(object model.RedisObject) bool) error {
 	return d.dec.Parse(func(object model.RedisObject) bool {
-		size := object.GetSize()
-		if size >= d.sizeRange[0] && size <= d.sizeRange[1] {
+		if d.match(object) {
 			return cb(object)
 		}
 		return true
 	})
 }
 
+// ParseElements returns error, since elements are emitted before the size of object is known.
+// Loading whole objects instead would break the bounded memory usage silently.
+func (d *sizeDecoder) ParseElements(cb func(event *core.ElementEvent) bool) error {
+	return errors.New("size filter is not supported in element-level streaming mode, " +
+		"since size of a key is known after all its elements are read")
+}
+
+func (d *sizeDecoder) parseObjectEnds(cb func(event *core.ElementEvent) bool) error {
+	return parseObjectEnds(d.dec, func(event *core.ElementEvent) bool {
+		if d.match(event.Object) {
+			return cb(event)
+		}
+		return true
+	})
+}
+
+func (d *sizeDecoder) match(object model.RedisObject) bool {
+	size := object.GetSize()
+	return size >= d.sizeRange[0] && size <= d.sizeRange[1]
+}
+
 // noExpirationDecoder returns entries without expiration
 type noExpirationDecoder struct {
 	dec decoder
@@ -143,6 +225,12 @@ func (d *noExpirationDecoder) Parse(cb func(object model.RedisObject) bool) erro
 	})
 }
 
+func (d *noExpirationDecoder) ParseElements(cb func(event *core.ElementEvent) bool) error {
+	return filterElements(d.dec, func(object model.RedisObject) bool {
+		return object.GetExpiration() == nil
+	}, cb)
+}
+
 func parseExpireExpr(s string) ([]int64, error) {
 	parseValue := func(s string) (int64, error) {
 		if s == "now" {
@@ -205,16 +293,56 @@ func WithGlobalMeta() GlobalMetaOption {
 	return GlobalMetaOption(true)
 }
 
+// MetadataOnlyOption skips values of keys, only key, type, encoding, size and element count are available.
+// It speeds up analysis which doesn't care about values, like bigkey, hotkey, prefix and flamegraph
+type MetadataOnlyOption bool
+
+// WithMetadataOnly creates a MetadataOnlyOption, see core.Decoder.WithMetadataOnly
+func WithMetadataOnly() MetadataOnlyOption {
+	return MetadataOnlyOption(true)
+}
+
+// ProgressOption reports progress of parsing, see core.Decoder.WithProgress
+type ProgressOption core.ProgressHookFunc
+
+// WithProgress creates a ProgressOption
+func WithProgress(hook core.ProgressHookFunc) ProgressOption {
+	return ProgressOption(hook)
+}
+
+// ParallelOption decodes rdb with multiple goroutines, see core.Decoder.WithParallel
+type ParallelOption struct {
+	concurrency int
+	ordered     bool
+}
+
+// WithParallel creates a ParallelOption, ordered means objects are processed in original order
+func WithParallel(concurrency int, ordered bool) ParallelOption {
+	return ParallelOption{
+		concurrency: concurrency,
+		ordered:     ordered,
+	}
+}
+
 func wrapDecoder(dec decoder, options ...interface{}) (decoder, error) {
 	var regexOpt RegexOption
+	var excludeRegexOpt ExcludeRegexOption
+	var dbOpt DBOption
 	var noExpiredOpt NoExpiredOption
 	var expirationOpt ExpirationOption
 	var sizeOpt SizeOption
 	var globalMetaOpt GlobalMetaOption
+	var metadataOnlyOpt MetadataOnlyOption
+	var progressOpt ProgressOption
+	var parallelOpt ParallelOption
 	for _, opt := range options {
 		switch o := opt.(type) {
 		case RegexOption:
 			regexOpt = o
+		case ExcludeRegexOption:
+			excludeRegexOpt = o
+		case DBOption:
+			dbOpt = o
 		case NoExpiredOption:
 			noExpiredOpt = o
 		case ExpirationOption:
@@ -223,6 +351,33 @@ func wrapDecoder(dec decoder, options ...interface{}) (decoder, error) {
 			sizeOpt = o
 		case GlobalMetaOption:
 			globalMetaOpt = o
+		case MetadataOnlyOption:
+			metadataOnlyOpt = o
+		case ProgressOption:
+			progressOpt = o
+		case ParallelOption:
+			parallelOpt = o
+		}
+	}
+	if inner, ok := dec.(*core.Decoder); ok {
+		modules.Register(inner)
+	}
+	if parallelOpt.concurrency > 1 {
+		inner, ok := dec.(*core.Decoder)
+		if ok {
+			inner.WithParallel(parallelOpt.concurrency, parallelOpt.ordered)
+		}
+	}
+	if progressOpt != nil {
+		inner, ok := dec.(*core.Decoder)
+		if ok {
+			inner.WithProgress(core.ProgressHookFunc(progressOpt))
+		}
+	}
+	if metadataOnlyOpt {
+		inner, ok := dec.(*core.Decoder)
+		if ok {
+			inner.WithMetadataOnly()
 		}
 	}
 	if globalMetaOpt {
@@ -238,6 +393,20 @@ func wrapDecoder(dec decoder, options ...interface{}) (decoder, error) {
 			return nil, err
 		}
 	}
+	if excludeRegexOpt != nil {
+		regDec, err := regexWrapper(dec, *excludeRegexOpt)
+		if err != nil {
+			return nil, err
+		}
+		regDec.exclude = true
+		dec = regDec
+	}
+	if dbOpt != nil {
+		dec = &dbDecoder{
+			dec: dec,
+			db:  *dbOpt,
+		}
+	}
 	if noExpiredOpt {
 		dec = &noExpiredDecoder{
 			dec: dec,
@@ -197,6 +197,13 @@ func ObjectToCmd(obj model.RedisObject, opts ...interface{}) []CmdLine {
 	if obj == nil {
 		return nil
 	}
+	cmdLines := valueToCmd(obj, opts...)
+	cmdLines = append(cmdLines, objectMetaToCmd(obj)...)
+	return cmdLines
+}
+
+// valueToCmd generates command lines to write value of the given object
+func valueToCmd(obj model.RedisObject, opts ...interface{}) []CmdLine {
 	useLexOrder := false
 	for _, o := range opts {
 		switch o.(type) {
@@ -225,9 +232,33 @@ func ObjectToCmd(obj model.RedisObject, opts ...interface{}) []CmdLine {
 		streamObj := obj.(*model.StreamObject)
 		cmdLines = append(cmdLines, streamToCmd(streamObj)...)
 	}
+	return cmdLines
+}
+
+// objectMetaToCmd generates command lines to set expiration and metadata of the given object
+func objectMetaToCmd(obj model.RedisObject) []CmdLine {
+	var cmdLines []CmdLine
 	if obj.GetExpiration() != nil {
 		cmdLines = append(cmdLines, makeExpireCmd(obj))
 	}
+	cmdLines = append(cmdLines, keyMetaToCmd(obj)...)
+	return cmdLines
+}
+
+// keyMetaToCmd generates command lines to restore metadata of the given key.
+// Metadata is owned by redis module, so only values returned as CmdLine by the handler
+// registered with core.Decoder.WithSpecialType can be restored, others will be skipped
+func keyMetaToCmd(obj model.RedisObject) []CmdLine {
+	metaInfo, ok := obj.(model.KeyMetaInfo)
+	if !ok {
+		return nil
+	}
+	var cmdLines []CmdLine
+	for _, meta := range metaInfo.GetMetadata() {
+		if cmdLine, ok := meta.Value.(CmdLine); ok {
+			cmdLines = append(cmdLines, cmdLine)
+		}
+	}
 	return cmdLines
 }
 
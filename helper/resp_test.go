@@ -0,0 +1,28 @@
+package helper
+
+import (
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func TestKeyMetaToCmd(t *testing.T) {
+	obj := &model.StringObject{
+		BaseObject: &model.BaseObject{
+			Key: "user:1",
+			Metadata: []*model.KeyMeta{
+				{Class: "tenant-id", Value: CmdLine{[]byte("TENANT.SET"), []byte("user:1"), []byte("acme")}},
+				{Class: "unknown-x"},
+			},
+		},
+		Value: []byte("alice"),
+	}
+	cmdLines := ObjectToCmd(obj)
+	if len(cmdLines) != 2 {
+		t.Errorf("expect 2 command lines, actual %d", len(cmdLines))
+		return
+	}
+	if string(cmdLines[1][0]) != "TENANT.SET" || string(cmdLines[1][2]) != "acme" {
+		t.Error("wrong key meta command")
+	}
+}
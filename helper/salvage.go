@@ -0,0 +1,131 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+// Salvage reads a damaged rdb file, skips damaged regions, and writes recovered keys into a new rdb file.
+// Keys of types which Encoder cannot write are not written, values of module types are written as core.ModuleValues.
+// Aux fields and functions before the first key are kept.
+// Encoder writes each db once, if a key is recovered after its db has been written (e.g. its db selector is
+// found again after a damaged region), it is not written and is reported in SalvageReport.Unplaced.
+// Only ProgressOption is supported in options.
+func Salvage(rdbFilename string, outputFilename string, options ...interface{}) (*core.SalvageReport, error) {
+	if rdbFilename == "" {
+		return nil, errors.New("src file path is required")
+	}
+	if outputFilename == "" {
+		return nil, errors.New("output file path is required")
+	}
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return nil, fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("create output %s failed, %v", outputFilename, err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	dec := core.NewDecoder(rdbFile).WithSpecialOpCode().WithModuleValues()
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case ProgressOption:
+			dec.WithProgress(core.ProgressHookFunc(o))
+		}
+	}
+
+	writer := bufio.NewWriter(outputFile)
+	enc := core.NewEncoder(writer).EnableCompress()
+	err = enc.WriteHeader()
+	if err != nil {
+		return nil, err
+	}
+	currentDB := -1
+	writtenDB := make(map[int]struct{})
+	writtenFunctions := false
+	var unplaced []*core.UnplacedKey
+	var writeErr error
+	report, err := dec.Salvage(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.AuxObject:
+			if currentDB < 0 && !writtenFunctions { // aux fields must be written before functions and keys
+				writeErr = enc.WriteAux(o.Key, o.Value)
+			}
+			return writeErr == nil
+		case *model.FunctionsObject:
+			if currentDB < 0 {
+				writeErr = enc.WriteFunctions(o.FunctionsLua)
+				writtenFunctions = true
+			}
+			return writeErr == nil
+		}
+		if !isWritable(object) {
+			return true
+		}
+		db := object.GetDBIndex()
+		if _, ok := writtenDB[db]; ok && db != currentDB {
+			// Encoder cannot select a db twice
+			unplaced = append(unplaced, &core.UnplacedKey{DB: db, Key: object.GetKey()})
+			return true
+		}
+		if db != currentDB {
+			currentDB = db
+			writeErr = enc.WriteDBHeader(uint(currentDB), 0, 0)
+			if writeErr != nil {
+				return false
+			}
+			writtenDB[currentDB] = struct{}{}
+		}
+		writeErr = enc.WriteObject(object, core.WithKeepEncoding())
+		return writeErr == nil
+	})
+	if report != nil {
+		report.Unplaced = unplaced
+	}
+	if err != nil {
+		return report, err
+	}
+	if writeErr != nil {
+		return report, fmt.Errorf("write rdb failed: %v", writeErr)
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return report, err
+	}
+	err = writer.Flush()
+	if err != nil {
+		return report, fmt.Errorf("write rdb failed: %v", err)
+	}
+	return report, nil
+}
+
+// isWritable returns whether key object can be written by core.Encoder.WriteObject.
+// Values of module types are writable if they are read as core.ModuleValues, so decoders of helpers which rewrite
+// rdb files use WithModuleValues and do not register module handlers.
+func isWritable(object model.RedisObject) bool {
+	if info, ok := object.(model.RawValueInfo); ok {
+		if _, rawValue := info.GetRawValue(); rawValue != nil {
+			return true
+		}
+	}
+	switch o := object.(type) {
+	case *model.StringObject, *model.ListObject, *model.SetObject, *model.HashObject,
+		*model.ZSetObject, *model.StreamObject:
+		return true
+	case *model.ModuleTypeObject:
+		_, ok := o.Value.(core.ModuleValues)
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package helper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+func readElemCounts(t *testing.T, filename string) map[string]int {
+	rdbFile, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	result := make(map[string]int)
+	err = core.NewDecoder(rdbFile).Parse(func(object model.RedisObject) bool {
+		result[object.GetType()+" "+object.GetKey()] = object.GetElemCount()
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSalvage(t *testing.T) {
+	dir := t.TempDir()
+	expect := readElemCounts(t, "../cases/memory.rdb")
+
+	output := filepath.Join(dir, "intact.rdb")
+	report, err := Salvage("../cases/memory.rdb", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Recovered != len(expect) || len(report.Damaged) != 0 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	actual := readElemCounts(t, output)
+	if len(actual) != len(expect) {
+		t.Errorf("expect %d keys, actual %d", len(expect), len(actual))
+	}
+	for key, count := range expect {
+		if actual[key] != count {
+			t.Errorf("%s: expect %d elements, actual %d", key, count, actual[key])
+		}
+	}
+
+	data, err := os.ReadFile("../cases/memory.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[bytes.Index(data, []byte("\x04zset"))-1] = 8 // unknown type
+	damaged := filepath.Join(dir, "damaged.rdb")
+	err = os.WriteFile(damaged, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output = filepath.Join(dir, "salvaged.rdb")
+	report, err = Salvage(damaged, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Recovered != len(expect)-1 || len(report.Damaged) != 1 || report.Damaged[0].LastKey != "list" {
+		t.Errorf("wrong report: %+v", report)
+	}
+	actual = readElemCounts(t, output)
+	delete(expect, "zset zset")
+	if len(actual) != len(expect) {
+		t.Errorf("expect %d keys, actual %d", len(expect), len(actual))
+	}
+	for key, count := range expect {
+		if actual[key] != count {
+			t.Errorf("%s: expect %d elements, actual %d", key, count, actual[key])
+		}
+	}
+
+	_, err = Salvage("", output)
+	if err == nil {
+		t.Error("expect error for empty src")
+	}
+	_, err = Salvage("/none/a", output)
+	if err == nil {
+		t.Error("expect error for missing src")
+	}
+}
+
+func TestSalvageUnplaced(t *testing.T) {
+	dir := t.TempDir()
+	buf := bytes.NewBuffer(nil)
+	enc := core.NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for db := 0; db < 3; db++ {
+		err = enc.WriteDBHeader(uint(db), 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = enc.WriteStringObject("key"+strconv.Itoa(db), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// db 0 is selected again after db 1
+	data := buf.Bytes()
+	data[bytes.Index(data, []byte{0xfe, 2})+1] = 0
+	src := filepath.Join(dir, "src.rdb")
+	err = os.WriteFile(src, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "salvaged.rdb")
+	report, err := Salvage(src, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Unplaced) != 1 || report.Unplaced[0].DB != 0 || report.Unplaced[0].Key != "key2" {
+		t.Errorf("wrong unplaced keys: %+v", report.Unplaced)
+	}
+	actual := readElemCounts(t, output)
+	expect := map[string]int{"string key0": 0, "string key1": 0}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf("expect %v, actual %v", expect, actual)
+	}
+}
@@ -0,0 +1,459 @@
+package helper
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/crc16"
+	"github.com/hdt3213/rdb/model"
+)
+
+// ClusterSlots is number of hash slots in redis cluster
+const ClusterSlots = 16384
+
+// KeySlot returns hash slot of key in redis cluster.
+// If key contains a hash tag like {user}, only the content between the first { and the following } is hashed.
+func KeySlot(key string) int {
+	if begin := strings.IndexByte(key, '{'); begin >= 0 {
+		if end := strings.IndexByte(key[begin+1:], '}'); end > 0 {
+			key = key[begin+1 : begin+1+end]
+		}
+	}
+	return int(crc16.ChecksumString(key) % ClusterSlots)
+}
+
+// isKeyObject returns whether object is a key rather than metadata returned by core.Decoder.WithSpecialOpCode
+func isKeyObject(object model.RedisObject) bool {
+	switch object.(type) {
+	case *model.AuxObject, *model.DBSizeObject, *model.FunctionsObject, *model.ModuleAuxObject,
+		*model.SlotInfoObject, *model.SlotImportObject:
+		return false
+	}
+	return true
+}
+
+// slotCheck compares slot info recorded in rdb with keys actually in the slot
+type slotCheck struct {
+	recorded        bool
+	recordedKeys    uint64
+	recordedExpires uint64
+	actualKeys      uint64
+	actualExpires   uint64
+}
+
+// HasSlotInfo returns whether rdb file has slot info or slot import records saved by Valkey cluster.
+// Valkey writes slot info before keys of each slot, so that it stops at the first key.
+func HasSlotInfo(rdbFilename string) (bool, error) {
+	if rdbFilename == "" {
+		return false, errors.New("src file path is required")
+	}
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return false, fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	found := false
+	err = core.NewDecoder(rdbFile).WithSpecialOpCode().WithMetadataOnly().Parse(func(object model.RedisObject) bool {
+		switch object.(type) {
+		case *model.SlotInfoObject, *model.SlotImportObject:
+			found = true
+			return false
+		}
+		return !isKeyObject(object)
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// CheckSlots reads slot info records saved by Valkey cluster and compares them with number of keys in each slot
+// computed by CRC16. The report has a row for each slot which is recorded or has keys, followed by in-flight
+// slot import jobs if there are any.
+// Only ProgressOption is supported in options. The invoker owns output, CheckSlots won't close it.
+func CheckSlots(rdbFilename string, output io.Writer, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	dec := core.NewDecoder(rdbFile).WithSpecialOpCode()
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case ProgressOption:
+			dec.WithProgress(core.ProgressHookFunc(o))
+		}
+	}
+	dbSlots := make(map[int]*[ClusterSlots]slotCheck)
+	getSlots := func(db int) *[ClusterSlots]slotCheck {
+		slots := dbSlots[db]
+		if slots == nil {
+			slots = new([ClusterSlots]slotCheck)
+			dbSlots[db] = slots
+		}
+		return slots
+	}
+	var imports []*model.SlotImportObject
+	var slotErr error
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.SlotInfoObject:
+			if o.Slot < 0 || o.Slot >= ClusterSlots {
+				slotErr = fmt.Errorf("illegal slot %d in slot info", o.Slot)
+				return false
+			}
+			check := &getSlots(o.DB)[o.Slot]
+			check.recorded = true
+			check.recordedKeys += o.KeyCount
+			check.recordedExpires += o.ExpiresCount
+		case *model.SlotImportObject:
+			imports = append(imports, o)
+		default:
+			if !isKeyObject(o) {
+				return true
+			}
+			check := &getSlots(o.GetDBIndex())[KeySlot(o.GetKey())]
+			check.actualKeys++
+			if o.GetExpiration() != nil {
+				check.actualExpires++
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if slotErr != nil {
+		return slotErr
+	}
+
+	dbs := make([]int, 0, len(dbSlots))
+	for db := range dbSlots {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	_, err = io.WriteString(output, "database,slot,recorded_keys,actual_keys,recorded_expires,actual_expires,consistent\n")
+	if err != nil {
+		return fmt.Errorf("write header failed: %v", err)
+	}
+	csvWriter := csv.NewWriter(output)
+	for _, db := range dbs {
+		for slot, check := range dbSlots[db] {
+			if !check.recorded && check.actualKeys == 0 {
+				continue
+			}
+			recordedKeys, recordedExpires := "", ""
+			if check.recorded {
+				recordedKeys = strconv.FormatUint(check.recordedKeys, 10)
+				recordedExpires = strconv.FormatUint(check.recordedExpires, 10)
+			}
+			consistent := check.recorded && check.recordedKeys == check.actualKeys &&
+				check.recordedExpires == check.actualExpires
+			err = csvWriter.Write([]string{
+				strconv.Itoa(db),
+				strconv.Itoa(slot),
+				recordedKeys,
+				strconv.FormatUint(check.actualKeys, 10),
+				recordedExpires,
+				strconv.FormatUint(check.actualExpires, 10),
+				strconv.FormatBool(consistent),
+			})
+			if err != nil {
+				return fmt.Errorf("csv write failed: %v", err)
+			}
+		}
+	}
+	if len(imports) > 0 {
+		csvWriter.Flush()
+		_, err = io.WriteString(output, "\nimport_job,slot_ranges\n")
+		if err != nil {
+			return fmt.Errorf("write header failed: %v", err)
+		}
+		for _, job := range imports {
+			err = csvWriter.Write([]string{job.JobName, formatSlotRanges(job.Ranges)})
+			if err != nil {
+				return fmt.Errorf("csv write failed: %v", err)
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// formatSlotRanges formats ranges like "0-100 200"
+func formatSlotRanges(ranges []model.SlotRange) string {
+	parts := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		if r.From == r.To {
+			parts = append(parts, strconv.Itoa(r.From))
+		} else {
+			parts = append(parts, strconv.Itoa(r.From)+"-"+strconv.Itoa(r.To))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// SlotNode is a node of cluster which serves slots in Ranges
+type SlotNode struct {
+	Name   string
+	Ranges []model.SlotRange
+}
+
+// ParseSlotLayout parses layout of cluster nodes. The expr is either number of nodes which divides slots evenly
+// like redis-cli --cluster create, or nodes separated by ';' like "a=0-5460;b=5461-10922;c=10923-16383",
+// a node may serve multiple ranges separated by ',' like "a=0-100,200-300" or single slots like "a=0,5".
+func ParseSlotLayout(expr string) ([]*SlotNode, error) {
+	if n, err := strconv.Atoi(expr); err == nil {
+		return evenSlotLayout(n)
+	}
+	var nodes []*SlotNode
+	owners := make(map[int]string)
+	for _, item := range strings.Split(expr, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("illegal node: %s", item)
+		}
+		node := &SlotNode{
+			Name: strings.TrimSpace(parts[0]),
+		}
+		for _, rngExpr := range strings.Split(parts[1], ",") {
+			rng, err := parseSlotRange(strings.TrimSpace(rngExpr))
+			if err != nil {
+				return nil, fmt.Errorf("illegal slot range of node %s: %v", node.Name, err)
+			}
+			for slot := rng.From; slot <= rng.To; slot++ {
+				if owner, ok := owners[slot]; ok {
+					return nil, fmt.Errorf("slot %d is assigned to both %s and %s", slot, owner, node.Name)
+				}
+				owners[slot] = node.Name
+			}
+			node.Ranges = append(node.Ranges, rng)
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no nodes in slot layout")
+	}
+	return nodes, nil
+}
+
+func parseSlotRange(expr string) (model.SlotRange, error) {
+	parts := strings.SplitN(expr, "-", 2)
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return model.SlotRange{}, fmt.Errorf("%s is not a slot", parts[0])
+	}
+	to := from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return model.SlotRange{}, fmt.Errorf("%s is not a slot", parts[1])
+		}
+	}
+	if from < 0 || to >= ClusterSlots || from > to {
+		return model.SlotRange{}, fmt.Errorf("illegal range %d-%d", from, to)
+	}
+	return model.SlotRange{From: from, To: to}, nil
+}
+
+// evenSlotLayout divides slots among n nodes in the same way as redis-cli --cluster create
+func evenSlotLayout(n int) ([]*SlotNode, error) {
+	if n <= 0 || n > ClusterSlots {
+		return nil, fmt.Errorf("illegal number of nodes: %d", n)
+	}
+	nodes := make([]*SlotNode, 0, n)
+	slotsPerNode := float64(ClusterSlots) / float64(n)
+	first := 0
+	cursor := 0.0
+	for i := 0; i < n; i++ {
+		last := int(math.Round(cursor + slotsPerNode - 1))
+		if last > ClusterSlots-1 || i == n-1 {
+			last = ClusterSlots - 1
+		}
+		nodes = append(nodes, &SlotNode{
+			Name:   "node" + strconv.Itoa(i+1),
+			Ranges: []model.SlotRange{{From: first, To: last}},
+		})
+		first = last + 1
+		cursor += slotsPerNode
+	}
+	return nodes, nil
+}
+
+// slotStat is number of keys, size and number of keys with expiration in a slot or a node
+type slotStat struct {
+	slot        int
+	keyCount    int
+	size        int
+	expireCount int
+}
+
+func (s *slotStat) GetSize() int {
+	return s.size
+}
+
+func (s *slotStat) add(other *slotStat) {
+	s.keyCount += other.keyCount
+	s.size += other.size
+	s.expireCount += other.expireCount
+}
+
+// SlotAnalyse read rdb file and computes number of keys, size and number of keys with expiration in each cluster slot.
+// It writes the heaviest N slots by size, if layout is not empty, it also writes the totals of each node and
+// how much each node deviates from the average size.
+// The invoker owns output, SlotAnalyse won't close it
+func SlotAnalyse(rdbFilename string, topN int, layout []*SlotNode, output io.Writer, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	if topN < 0 {
+		return errors.New("n must greater than 0")
+	} else if topN == 0 {
+		topN = ClusterSlots
+	}
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	var dec decoder = core.NewDecoder(rdbFile)
+	if dec, err = wrapDecoder(dec, options...); err != nil {
+		return err
+	}
+	slots := make([]slotStat, ClusterSlots)
+	for i := range slots {
+		slots[i].slot = i
+	}
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if !isKeyObject(object) {
+			return true
+		}
+		stat := &slots[KeySlot(object.GetKey())]
+		stat.keyCount++
+		stat.size += object.GetSize()
+		if object.GetExpiration() != nil {
+			stat.expireCount++
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	toplist := newToplist(topN)
+	for i := range slots {
+		if slots[i].keyCount > 0 {
+			toplist.add(&slots[i])
+		}
+	}
+	_, err = io.WriteString(output, "slot,key_count,size,size_readable,expire_count\n")
+	if err != nil {
+		return fmt.Errorf("write header failed: %v", err)
+	}
+	csvWriter := csv.NewWriter(output)
+	for _, x := range toplist.list {
+		stat := x.(*slotStat)
+		err = csvWriter.Write([]string{
+			strconv.Itoa(stat.slot),
+			strconv.Itoa(stat.keyCount),
+			strconv.Itoa(stat.size),
+			bytefmt.FormatSize(uint64(stat.size)),
+			strconv.Itoa(stat.expireCount),
+		})
+		if err != nil {
+			return fmt.Errorf("csv write failed: %v", err)
+		}
+	}
+	if len(layout) > 0 {
+		csvWriter.Flush()
+		if err = writeNodeStats(output, csvWriter, slots, layout); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// writeNodeStats writes totals of each node, keys in slots not served by any node are counted as unassigned
+func writeNodeStats(output io.Writer, csvWriter *csv.Writer, slots []slotStat, layout []*SlotNode) error {
+	_, err := io.WriteString(output, "\nnode,slot_ranges,key_count,size,size_readable,expire_count,imbalance\n")
+	if err != nil {
+		return fmt.Errorf("write header failed: %v", err)
+	}
+	assigned := make([]bool, ClusterSlots)
+	nodeStats := make([]slotStat, len(layout))
+	total := 0
+	for i, node := range layout {
+		for _, rng := range node.Ranges {
+			for slot := rng.From; slot <= rng.To; slot++ {
+				if !assigned[slot] {
+					assigned[slot] = true
+					nodeStats[i].add(&slots[slot])
+				}
+			}
+		}
+		total += nodeStats[i].size
+	}
+	average := float64(total) / float64(len(layout))
+	for i, node := range layout {
+		stat := &nodeStats[i]
+		imbalance := 0.0
+		if average > 0 {
+			imbalance = (float64(stat.size) - average) / average * 100
+		}
+		err = csvWriter.Write([]string{
+			node.Name,
+			formatSlotRanges(node.Ranges),
+			strconv.Itoa(stat.keyCount),
+			strconv.Itoa(stat.size),
+			bytefmt.FormatSize(uint64(stat.size)),
+			strconv.Itoa(stat.expireCount),
+			fmt.Sprintf("%+.2f%%", imbalance),
+		})
+		if err != nil {
+			return fmt.Errorf("csv write failed: %v", err)
+		}
+	}
+	unassigned := &slotStat{}
+	for slot := range slots {
+		if !assigned[slot] {
+			unassigned.add(&slots[slot])
+		}
+	}
+	if unassigned.keyCount > 0 {
+		err = csvWriter.Write([]string{
+			"unassigned",
+			"",
+			strconv.Itoa(unassigned.keyCount),
+			strconv.Itoa(unassigned.size),
+			bytefmt.FormatSize(uint64(unassigned.size)),
+			strconv.Itoa(unassigned.expireCount),
+			"",
+		})
+		if err != nil {
+			return fmt.Errorf("csv write failed: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	cases := map[string]int{
+		"foo":           12182,
+		"bar":           5061,
+		"123456789":     12739,
+		"{user}:1":      5474,
+		"{user}:2":      5474,
+		"user":          5474,
+		"{}user":        KeySlot("{}user"),
+		"foo{}{bar}":    KeySlot("foo{}{bar}"),
+		"foo{{bar}}":    KeySlot("{bar"),
+		"foo{bar}{zap}": KeySlot("bar"),
+	}
+	for key, slot := range cases {
+		if actual := KeySlot(key); actual != slot {
+			t.Errorf("slot of %s should be %d, actual %d", key, slot, actual)
+		}
+	}
+	if KeySlot("{}user") == KeySlot("user") {
+		t.Error("empty hash tag should be ignored")
+	}
+}
+
+func TestCheckSlots(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := CheckSlots("../cases/valkey_slots.rdb", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "database,slot,recorded_keys,actual_keys,recorded_expires,actual_expires,consistent\n" +
+		"0,5061,1,1,1,1,true\n" +
+		"0,5474,3,2,0,0,false\n" +
+		"0,12182,1,1,0,0,true\n" +
+		"\n" +
+		"import_job,slot_ranges\n" +
+		"import-job-1,100-200 5000\n"
+	if buf.String() != expect {
+		t.Errorf("wrong report:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = CheckSlots("../cases/memory.rdb", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(",,1,,0,false\n")) {
+		t.Errorf("keys without slot info should be inconsistent:\n%s", buf.String())
+	}
+	err = CheckSlots("", buf)
+	if err == nil || err.Error() != "src file path is required" {
+		t.Error("failed when empty src")
+	}
+}
+
+func TestHasSlotInfo(t *testing.T) {
+	for file, expect := range map[string]bool{
+		"../cases/valkey_slots.rdb":          true,
+		"../cases/memory.rdb":                false,
+		"../cases/valkey_hash2_with_hfe.rdb": false,
+	} {
+		actual, err := HasSlotInfo(file)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if actual != expect {
+			t.Errorf("%s: expect %v, actual %v", file, expect, actual)
+		}
+	}
+	_, err := HasSlotInfo("")
+	if err == nil {
+		t.Error("expect error for empty src")
+	}
+}
+
+func TestParseSlotLayout(t *testing.T) {
+	nodes, err := ParseSlotLayout("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"0-5460", "5461-10922", "10923-16383"}
+	if len(nodes) != len(expect) {
+		t.Fatalf("expect %d nodes, actual %d", len(expect), len(nodes))
+	}
+	for i, node := range nodes {
+		if ranges := formatSlotRanges(node.Ranges); ranges != expect[i] {
+			t.Errorf("expect ranges %s of %s, actual %s", expect[i], node.Name, ranges)
+		}
+	}
+
+	nodes, err = ParseSlotLayout("a=0-100,200; b = 101-199")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].Name != "a" || formatSlotRanges(nodes[0].Ranges) != "0-100 200" ||
+		nodes[1].Name != "b" || formatSlotRanges(nodes[1].Ranges) != "101-199" {
+		t.Errorf("wrong layout: %+v %+v", nodes[0], nodes[1])
+	}
+
+	errCases := []string{"0", "16385", "a", "a=1-0", "a=0-16384", "a=x", "a=0-10;b=10-20", ";", "=1"}
+	for _, expr := range errCases {
+		if _, err := ParseSlotLayout(expr); err == nil {
+			t.Errorf("expect error of %s", expr)
+		}
+	}
+}
+
+func TestSlotAnalyse(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	layout, err := ParseSlotLayout("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SlotAnalyse("../cases/valkey_slots.rdb", 2, layout, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "slot,key_count,size,size_readable,expire_count\n" +
+		"5474,2,128,128B,0\n" +
+		"5061,1,80,80B,1\n" +
+		"\n" +
+		"node,slot_ranges,key_count,size,size_readable,expire_count,imbalance\n" +
+		"node1,0-5460,1,80,80B,1,-9.09%\n" +
+		"node2,5461-10922,2,128,128B,0,+45.45%\n" +
+		"node3,10923-16383,1,56,56B,0,-36.36%\n"
+	if buf.String() != expect {
+		t.Errorf("wrong report:\n%s", buf.String())
+	}
+
+	// keys in slots not served by any node
+	buf.Reset()
+	layout, err = ParseSlotLayout("a=0-5460")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SlotAnalyse("../cases/valkey_slots.rdb", 0, layout, buf, WithRegexOption("^[^b]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = "slot,key_count,size,size_readable,expire_count\n" +
+		"5474,2,128,128B,0\n" +
+		"12182,1,56,56B,0\n" +
+		"\n" +
+		"node,slot_ranges,key_count,size,size_readable,expire_count,imbalance\n" +
+		"a,0-5460,0,0,0,0,+0.00%\n" +
+		"unassigned,,3,184,184B,0,\n"
+	if buf.String() != expect {
+		t.Errorf("wrong report:\n%s", buf.String())
+	}
+
+	err = SlotAnalyse("../cases/valkey_slots.rdb", -1, nil, buf)
+	if err == nil {
+		t.Error("expect error of negative n")
+	}
+	err = SlotAnalyse("", 0, nil, buf)
+	if err == nil || err.Error() != "src file path is required" {
+		t.Error("failed when empty src")
+	}
+}
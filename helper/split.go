@@ -0,0 +1,182 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+// splitOutput is the rdb file of a node in SplitRDB
+type splitOutput struct {
+	file    *os.File
+	writer  *bufio.Writer
+	enc     *core.Encoder
+	hasKeys bool
+}
+
+// SplitRDB splits an rdb file into one rdb file per cluster node according to hash slots of keys.
+// Output of node is written to <outputDir>/<node name>.rdb. All keys are written into db 0 since redis cluster
+// supports only one database. Keys of different databases may have the same name, which makes redis refuse to load
+// the output, so it returns an error if input has keys in multiple databases, unless DBOption chooses one of them.
+// Outputs are of the same dialect as input. Expiration, LRU/LFU info, key metadata, aux fields, functions and module
+// aux data are kept. It returns an error if a key belongs to a slot not assigned to any node or is of a type Encoder
+// cannot write, unless RawCopyOption is set.
+// DBOption, ProgressOption and RawCopyOption are supported in options.
+func SplitRDB(rdbFilename string, layout []*SlotNode, outputDir string, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	if outputDir == "" {
+		return errors.New("output directory is required")
+	}
+	if len(layout) == 0 {
+		return errors.New("slot layout is required")
+	}
+	owners := make([]int, ClusterSlots)
+	for i := range owners {
+		owners[i] = -1
+	}
+	for i, node := range layout {
+		if node.Name == "" || node.Name != filepath.Base(node.Name) || strings.HasPrefix(node.Name, ".") {
+			return fmt.Errorf("illegal node name: %s", node.Name)
+		}
+		for _, rng := range node.Ranges {
+			for slot := rng.From; slot <= rng.To; slot++ {
+				owners[slot] = i
+			}
+		}
+	}
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	err = os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return fmt.Errorf("create output directory %s failed, %v", outputDir, err)
+	}
+	valkey := isValkeyRDB(rdbFile)
+	outputs := make([]*splitOutput, 0, len(layout))
+	defer func() {
+		for _, out := range outputs {
+			_ = out.file.Close()
+		}
+	}()
+	for _, node := range layout {
+		filename := filepath.Join(outputDir, node.Name+".rdb")
+		file, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("create output %s failed, %v", filename, err)
+		}
+		writer := bufio.NewWriter(file)
+		enc := core.NewEncoder(writer)
+		if valkey {
+			enc = core.NewEncoderValkey(writer)
+		}
+		out := &splitOutput{
+			file:   file,
+			writer: writer,
+			enc:    enc.EnableCompress(),
+		}
+		outputs = append(outputs, out)
+		err = out.enc.WriteHeader()
+		if err != nil {
+			return err
+		}
+	}
+	dec := core.NewDecoder(rdbFile).WithSpecialOpCode().WithModuleValues()
+	var dbOpt DBOption
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case DBOption:
+			dbOpt = o
+		case ProgressOption:
+			dec.WithProgress(core.ProgressHookFunc(o))
+		case RawCopyOption:
+			dec.WithRawValue().WithMetadataOnly()
+		}
+	}
+	keyDB := -1 // keyDB is the database of written keys
+	if dbOpt != nil {
+		keyDB = *dbOpt
+	}
+
+	var writeErr error
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.AuxObject:
+			for _, out := range outputs {
+				if writeErr = out.enc.WriteAux(o.Key, o.Value); writeErr != nil {
+					return false
+				}
+			}
+			return true
+		case *model.FunctionsObject: // functions are global, every node has them
+			for _, out := range outputs {
+				if writeErr = out.enc.WriteFunctions(o.FunctionsLua); writeErr != nil {
+					return false
+				}
+			}
+			return true
+		case *model.ModuleAuxObject: // module aux data is global too
+			for _, out := range outputs {
+				if writeErr = out.enc.WriteObject(o); writeErr != nil {
+					return false
+				}
+			}
+			return true
+		}
+		if !isKeyObject(object) {
+			return true
+		}
+		if db := object.GetDBIndex(); keyDB < 0 {
+			keyDB = db
+		} else if db != keyDB {
+			if dbOpt != nil {
+				return true
+			}
+			writeErr = fmt.Errorf("input has keys in db %d and db %d, but all keys are written into db 0, "+
+				"choose one db to split", keyDB, db)
+			return false
+		}
+		slot := KeySlot(object.GetKey())
+		if owners[slot] < 0 {
+			writeErr = fmt.Errorf("slot %d of key %s is not assigned to any node", slot, object.GetKey())
+			return false
+		}
+		out := outputs[owners[slot]]
+		if !out.hasKeys {
+			if writeErr = out.enc.WriteDBHeader(0, 0, 0); writeErr != nil {
+				return false
+			}
+			out.hasKeys = true
+		}
+		writeErr = out.enc.WriteObject(object, core.WithKeepEncoding(), core.WithDB(0))
+		return writeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("write rdb failed: %v", writeErr)
+	}
+	for _, out := range outputs {
+		err = out.enc.WriteEnd()
+		if err != nil {
+			return err
+		}
+		err = out.writer.Flush()
+		if err != nil {
+			return fmt.Errorf("write rdb failed: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+func TestSplitRDB(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.rdb")
+	srcFile, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(time.Hour).UnixNano() / 1e6
+	enc := core.NewEncoder(srcFile)
+	steps := []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteAux("redis-ver", "7.0.0") },
+		func() error { return enc.WriteDBHeader(0, 3, 1) },
+		func() error { return enc.WriteStringObject("foo", []byte("1"), core.WithTTL(uint64(expiration))) }, // slot 12182
+		func() error { return enc.WriteStringObject("bar", []byte("2"), core.WithIdleTime(100)) },           // slot 5061
+		func() error { return enc.WriteListObject("{user}:1", [][]byte{[]byte("a")}, core.WithFreq(5)) },    // slot 5474
+		func() error { return enc.WriteDBHeader(3, 1, 0) },
+		func() error { return enc.WriteSetObject("{user}:2", [][]byte{[]byte("b")}) }, // slot 5474
+		enc.WriteEnd,
+	}
+	for _, step := range steps {
+		if err = step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = srcFile.Close()
+
+	layout, err := ParseSlotLayout("a=0-5461;b=5462-10000;c=10001-16383")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputDir := filepath.Join(dir, "output")
+	// keys of db 0 and db 3 cannot be written into db 0 together
+	err = SplitRDB(src, layout, outputDir)
+	if err == nil || !strings.Contains(err.Error(), "db 0 and db 3") {
+		t.Errorf("expect error for multiple databases, actual %v", err)
+	}
+	err = SplitRDB(src, layout, outputDir, WithDBOption(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKeys := map[string][]string{
+		"a": {"bar"},
+		"b": {"{user}:1"},
+		"c": {"foo"},
+	}
+	for node, keys := range expectKeys {
+		file, err := os.Open(filepath.Join(outputDir, node+".rdb"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		auxCount := 0
+		err = core.NewDecoder(file).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+			if aux, ok := object.(*model.AuxObject); ok {
+				if aux.Key == "redis-ver" && aux.Value == "7.0.0" {
+					auxCount++
+				}
+				return true
+			}
+			if !isKeyObject(object) {
+				return true
+			}
+			if object.GetDBIndex() != 0 {
+				t.Errorf("%s: key %s is not in db 0", node, object.GetKey())
+			}
+			info := object.(model.EvictionInfo)
+			switch object.GetKey() {
+			case "foo":
+				if object.GetExpiration() == nil || object.GetExpiration().UnixNano()/1e6 != expiration {
+					t.Errorf("wrong expiration of foo: %v", object.GetExpiration())
+				}
+			case "bar":
+				if info.GetIdleTime() != 100 || info.GetFreq() != -1 {
+					t.Errorf("wrong idle time of bar: %d", info.GetIdleTime())
+				}
+			case "{user}:1":
+				if info.GetFreq() != 5 || info.GetIdleTime() != -1 {
+					t.Errorf("wrong frequency of {user}:1: %d", info.GetFreq())
+				}
+			}
+			actual = append(actual, object.GetKey())
+			return true
+		})
+		_ = file.Close()
+		if err != nil {
+			t.Errorf("%s: %v", node, err)
+			continue
+		}
+		if auxCount != 1 {
+			t.Errorf("%s: aux field is not kept", node)
+		}
+		if len(actual) != len(keys) {
+			t.Errorf("%s: expect keys %v, actual %v", node, keys, actual)
+			continue
+		}
+		for i := range keys {
+			if actual[i] != keys[i] {
+				t.Errorf("%s: expect keys %v, actual %v", node, keys, actual)
+			}
+		}
+	}
+
+	layout, err = ParseSlotLayout("a=0-10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SplitRDB(src, layout, outputDir, WithDBOption(0))
+	if err == nil {
+		t.Error("expect error for unassigned slot")
+	}
+	err = SplitRDB(src, []*SlotNode{{Name: "../a"}}, outputDir)
+	if err == nil {
+		t.Error("expect error for illegal node name")
+	}
+
+	// the same key in db 0 and db 1 cannot be written into db 0 of output
+	duplicated := filepath.Join(dir, "duplicated.rdb")
+	srcFile, err = os.Create(duplicated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc = core.NewEncoder(srcFile)
+	steps = []func() error{
+		enc.WriteHeader,
+		func() error { return enc.WriteDBHeader(0, 1, 0) },
+		func() error { return enc.WriteStringObject("foo", []byte("1")) },
+		func() error { return enc.WriteDBHeader(1, 1, 0) },
+		func() error { return enc.WriteStringObject("foo", []byte("2")) },
+		enc.WriteEnd,
+	}
+	for _, step := range steps {
+		if err = step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = srcFile.Close()
+	all := []*SlotNode{{Name: "a", Ranges: []model.SlotRange{{From: 0, To: 16383}}}}
+	err = SplitRDB(duplicated, all, outputDir)
+	if err == nil || !strings.Contains(err.Error(), "db 0 and db 1") {
+		t.Errorf("expect error for duplicated key, actual %v", err)
+	}
+	err = SplitRDB(duplicated, all, outputDir, WithDBOption(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values, _, _ := readMergeResult(t, filepath.Join(outputDir, "a.rdb")); !reflect.DeepEqual(values, map[string]string{"0 foo": "2"}) {
+		t.Errorf("expect only foo of db 1, actual %v", values)
+	}
+	err = SplitRDB("", layout, outputDir)
+	if err == nil {
+		t.Error("expect error for empty src")
+	}
+	err = SplitRDB(src, layout, "")
+	if err == nil {
+		t.Error("expect error for empty output")
+	}
+}
+
+func TestSplitRDBWithModules(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range moduleCases {
+		src := filepath.Join("../cases", name+".rdb")
+		outputDir := filepath.Join(dir, name)
+		err := SplitRDB(src, []*SlotNode{{Name: "a", Ranges: []model.SlotRange{{From: 0, To: 16383}}}}, outputDir)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		expect, actual := readModuleValues(t, src), readModuleValues(t, filepath.Join(outputDir, "a.rdb"))
+		if len(expect) == 0 || !reflect.DeepEqual(expect, actual) {
+			t.Errorf("%s: values of module types or module aux data are not kept, expect %v, actual %v", name, expect, actual)
+		}
+	}
+}
+
+func TestSplitRDBWithRawCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := "../cases/redisbloom.rdb"
+	err := SplitRDB(src, []*SlotNode{{Name: "a", Ranges: []model.SlotRange{{From: 0, To: 16383}}}}, dir, WithRawCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect, actual := readRawValues(t, src), readRawValues(t, filepath.Join(dir, "a.rdb")); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("values are not copied verbatim, expect %v, actual %v", expect, actual)
+	}
+}
@@ -18,8 +18,26 @@ var (
 // using https://github.com/zhuyie/golzf according to MIT license
 // Decompress decompress lzf compressed data
 func Decompress(input []byte, inLen int, outLen int) ([]byte, error) {
-	input = input[:inLen]
-	output := make([]byte, outLen)
+	// output never exceeds outLen, so it won't stop until all input consumed
+	return decompress(input[:inLen], make([]byte, outLen), outLen+1)
+}
+
+// DecompressPrefix decompresses only the first n bytes of lzf compressed data,
+// the result may be shorter than n if the whole data is shorter
+func DecompressPrefix(input []byte, n int) ([]byte, error) {
+	// the last literal run or back reference may exceed n
+	output, err := decompress(input, make([]byte, n+maxLit+maxRef), n)
+	if err != nil {
+		return nil, err
+	}
+	if len(output) > n {
+		output = output[:n]
+	}
+	return output, nil
+}
+
+// decompress stops once at least limit bytes are decompressed
+func decompress(input []byte, output []byte, limit int) ([]byte, error) {
 	var inputIndex, outputIndex int
 
 	inputLength := len(input)
@@ -28,7 +46,7 @@ func Decompress(input []byte, inLen int, outLen int) ([]byte, error) {
 		return nil, nil
 	}
 
-	for inputIndex < inputLength {
+	for inputIndex < inputLength && outputIndex < limit {
 		ctrl := int(input[inputIndex])
 		inputIndex++
 
@@ -105,7 +123,9 @@ func Compress(input []byte) ([]byte, error) {
 	lit = 0 /* start run */
 	outputIndex++
 
-	hval = uint32(input[inputIndex])<<8 | uint32(input[inputIndex+1])
+	if inputLength > 2 {
+		hval = uint32(input[inputIndex])<<8 | uint32(input[inputIndex+1])
+	}
 	for inputIndex < inputLength-2 {
 		hval = (hval << 8) | uint32(input[inputIndex+2])
 		hslot = ((hval >> (3*8 - htabLog)) - hval*5) & (htabSize - 1)
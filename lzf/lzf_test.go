@@ -1,9 +1,11 @@
 package lzf
 
 import (
+	"bytes"
 	"math/rand"
 	"strings"
 	"testing"
+	"time"
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
@@ -36,3 +38,112 @@ func TestLzf(t *testing.T) {
 		}
 	}
 }
+
+func TestDecompressPrefix(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		str := strings.Repeat(RandString(128), 10)
+		compressed, err := Compress([]byte(str))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, n := range []int{1, 10, 200, len(str), len(str) + 10} {
+			prefix, err := DecompressPrefix(compressed, n)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			expect := str
+			if n < len(str) {
+				expect = str[:n]
+			}
+			if expect != string(prefix) {
+				t.Errorf("wrong prefix of length %d", n)
+				return
+			}
+		}
+	}
+}
+
+// randBytes creates n bytes in which random literals and repetition of previous bytes are mixed,
+// repeatRate is the chance of beginning a repetition
+func randBytes(r *rand.Rand, n int, repeatRate float64, alphabet int) []byte {
+	b := make([]byte, 0, n)
+	for len(b) < n {
+		if len(b) > 0 && r.Float64() < repeatRate {
+			start := r.Intn(len(b))
+			length := r.Intn(maxRef * 2)
+			for i := 0; i < length && len(b) < n; i++ {
+				b = append(b, b[start+i])
+			}
+		} else {
+			b = append(b, byte(r.Intn(alphabet)))
+		}
+	}
+	return b
+}
+
+func TestLzfRoundTrip(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 5000; i++ {
+		n := r.Intn(64)
+		if i%10 == 0 {
+			n = r.Intn(maxOff * 8) // back references reach max offset
+		}
+		input := randBytes(r, n, r.Float64(), 1+r.Intn(256))
+		compressed, err := Compress(input)
+		if err != nil {
+			if err != errInsufficientBuffer {
+				t.Fatalf("seed %d: compress %v failed: %v", seed, input, err)
+			}
+			continue // not compressible
+		}
+		if len(compressed) > len(input) {
+			t.Fatalf("seed %d: compressed length %d is longer than input %d", seed, len(compressed), len(input))
+		}
+		decompressed, err := Decompress(compressed, len(compressed), len(input))
+		if err != nil {
+			t.Fatalf("seed %d: decompress %v failed: %v", seed, input, err)
+		}
+		if !bytes.Equal(input, decompressed) {
+			t.Fatalf("seed %d: wrong decompressed of %v", seed, input)
+		}
+	}
+}
+
+func TestLzfShortInput(t *testing.T) {
+	for n := 0; n < 8; n++ {
+		input := bytes.Repeat([]byte{'a'}, n)
+		compressed, err := Compress(input)
+		if err != nil {
+			continue
+		}
+		decompressed, err := Decompress(compressed, len(compressed), len(input))
+		if err != nil || !bytes.Equal(input, decompressed) {
+			t.Errorf("wrong round trip of %d bytes: %v", n, err)
+		}
+	}
+}
+
+func TestDecompressCorrupted(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	defer func() {
+		if err := recover(); err != nil {
+			t.Fatalf("seed %d: panic: %v", seed, err)
+		}
+	}()
+	input := bytes.Repeat(randBytes(r, 64, 0, 256), 16)
+	compressed, err := Compress(input)
+	if err != nil {
+		t.Fatalf("seed %d: %v", seed, err)
+	}
+	for i := 0; i < 1000; i++ {
+		corrupted := append([]byte(nil), compressed...)
+		corrupted[r.Intn(len(corrupted))] = byte(r.Intn(256))
+		// must not panic, result is either an error or some bytes
+		_, _ = Decompress(corrupted, len(corrupted), len(input))
+		_, _ = DecompressPrefix(corrupted, r.Intn(len(input)))
+	}
+}
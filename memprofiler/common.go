@@ -51,7 +51,10 @@ func sizeOfString(str string) int {
 		// REDIS_SHARED_INTEGERS
 		return 0
 	}
-	size := len(str)
+	return sizeOfStringLen(len(str))
+}
+
+func sizeOfStringLen(size int) int {
 	if size < 32 { // 2^5
 		return getJemallocSize(size + 1 + 1)
 	} else if size < 256 { // 2^8
@@ -29,6 +29,10 @@ func SizeOfObject(obj model.RedisObject) int {
 		size += sizeOfZSetObject(o)
 	case *model.StreamObject:
 		size += sizeOfStreamObject(o)
+	case *model.ModuleTypeObject:
+		if sizer, ok := o.Value.(model.MemorySizer); ok {
+			size += sizer.MemorySize()
+		}
 	}
 	return size
 }
@@ -0,0 +1,118 @@
+package memprofiler
+
+import "github.com/hdt3213/rdb/model"
+
+// ElementSizer evaluates memory usage of an object whose elements are read in batches or skipped.
+// It gives the same result as SizeOfObject on the whole object, except that ziplist and quicklist
+// are evaluated by their raw length when values are skipped.
+type ElementSizer struct {
+	size     int
+	count    int
+	encoding string
+}
+
+// NewElementSizer creates an ElementSizer
+// obj has key/type/encoding/expiration but no elements, count is the length read from rdb
+// (number of elements, number of quicklist nodes or number of stream entries)
+func NewElementSizer(obj model.RedisObject, count int) *ElementSizer {
+	s := &ElementSizer{
+		size:     topLevelObjectOverhead(obj.GetKey(), obj.GetExpiration() != nil),
+		count:    count,
+		encoding: obj.GetEncoding(),
+	}
+	switch obj.GetEncoding() {
+	case model.ListEncoding:
+		s.size += 5*sizeOfPointer() + sizeOfLong()
+	case model.QuickListEncoding:
+		s.size += 2*sizeOfPointer() + sizeOfLong() + 2*4
+	case model.QuickList2Encoding:
+		s.size += 2*sizeOfPointer() + 2*sizeOfLong() + 2*4
+	case model.SetEncoding, model.HashEncoding, model.HashExEncoding:
+		s.size += hashtableOverhead(count)
+	case model.ZSetEncoding, model.ZSet2Encoding:
+		s.size += skipListOverhead(count)
+	}
+	return s
+}
+
+// SizeOfStringLen evaluates memory usage of a string by its length, isInt means it is a shared integer
+func SizeOfStringLen(length int, isInt bool) int {
+	if isInt {
+		return 0
+	}
+	return sizeOfStringLen(length)
+}
+
+// AddElement evaluates memory usage of an element whose value has been skipped.
+// strSizes are results of SizeOfStringLen: member of list/set/zset, or field and value of hash
+func (s *ElementSizer) AddElement(strSizes ...int) {
+	switch s.encoding {
+	case model.ListEncoding:
+		s.size += 3*sizeOfPointer() + strSizes[0]
+	case model.SetEncoding:
+		s.size += hashTableEntryOverhead() + strSizes[0]
+	case model.HashEncoding, model.HashExEncoding:
+		s.size += hashTableEntryOverhead() + strSizes[0] + strSizes[1]
+	case model.ZSetEncoding, model.ZSet2Encoding:
+		s.size += strSizes[0] + 8 + skipListEntryOverhead()
+	}
+}
+
+// AddNode evaluates memory usage of a quicklist node whose value has been skipped,
+// rawLength is length of ziplist/listpack or length of the only element in plain node
+func (s *ElementSizer) AddNode(rawLength int, plain bool) {
+	switch s.encoding {
+	case model.QuickListEncoding:
+		s.size += 4*sizeOfPointer() + sizeOfLong() + 2*4 + rawLength
+	case model.QuickList2Encoding:
+		s.size += 3*sizeOfPointer() + sizeOfLong() + 4
+		if plain {
+			s.size += sizeOfStringLen(rawLength)
+		} else {
+			s.size += rawLength
+		}
+	}
+}
+
+// AddRaw adds memory usage of a compact encoded value (string, ziplist, listpack, intset)
+func (s *ElementSizer) AddRaw(size int) {
+	s.size += size
+}
+
+// Add evaluates memory usage of a batch of elements, batch of quicklist contains exactly one node
+func (s *ElementSizer) Add(batch model.RedisObject) {
+	switch o := batch.(type) {
+	case *model.ListObject:
+		switch o.GetEncoding() {
+		case model.ListEncoding:
+			for _, v := range o.Values {
+				s.size += 3*sizeOfPointer() + sizeOfString(unsafeBytes2Str(v))
+			}
+		case model.QuickListEncoding:
+			s.size += 4*sizeOfPointer() + sizeOfLong() + 2*4 + sizeOfZiplist(o.Values)
+		case model.QuickList2Encoding:
+			detail := o.Extra.(*model.Quicklist2Detail)
+			s.size += sizeOfQuicklist2(o.Values, detail) - (2*sizeOfPointer() + 2*sizeOfLong() + 2*4)
+		}
+	case *model.SetObject:
+		for _, v := range o.Members {
+			s.size += hashTableEntryOverhead() + sizeOfString(unsafeBytes2Str(v))
+		}
+	case *model.HashObject:
+		for k, v := range o.Hash {
+			s.size += hashTableEntryOverhead() + sizeOfString(k) + sizeOfString(unsafeBytes2Str(v))
+		}
+	case *model.ZSetObject:
+		for _, entry := range o.Entries {
+			s.size += sizeOfString(entry.Member) + 8 + skipListEntryOverhead()
+		}
+	}
+}
+
+// Size returns memory usage of the whole object, final is the object read after all elements
+func (s *ElementSizer) Size(final model.RedisObject) int {
+	if o, ok := final.(*model.StreamObject); ok {
+		return s.size + sizeOfStream(o, s.count)
+	}
+	return s.size
+}
@@ -3,9 +3,13 @@ package memprofiler
 import "github.com/hdt3213/rdb/model"
 
 func sizeOfStreamObject(obj *model.StreamObject) int {
+	return sizeOfStream(obj, len(obj.Entries))
+}
+
+func sizeOfStream(obj *model.StreamObject, entryCount int) int {
 	size := sizeOfPointer()*2 + 8 + 16 + // size of stream struct
 		sizeOfPointer() + 8*2 + // rax struct
-		sizeOfStreamRaxTree(len(obj.Entries))
+		sizeOfStreamRaxTree(entryCount)
 	if obj.Version >= 2 {
 		size += 16*2 + 8 // size of 2 new streamID and a uint64
 	}
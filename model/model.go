@@ -24,6 +24,12 @@ const (
 	StreamType = "stream"
 	// FunctionsType is redis functions
 	FunctionsType = "functions"
+	// ModuleAuxType is for RDB_OPCODE_MODULE_AUX
+	ModuleAuxType = "module-aux"
+	// SlotInfoType is for RDB_OPCODE_SLOT_INFO of Valkey
+	SlotInfoType = "slot-info"
+	// SlotImportType is for RDB_OPCODE_SLOT_IMPORT of Valkey
+	SlotImportType = "slot-import"
 )
 
 const (
@@ -87,6 +93,20 @@ type EvictionInfo interface {
 	GetFreq() int64
 }
 
+// KeyMetaInfo is an optional interface for objects that carry metadata of RDB_OPCODE_KEY_META.
+// Use type assertion to check if an object implements this interface.
+type KeyMetaInfo interface {
+	// GetMetadata returns metadata attached to the key, nil if not available
+	GetMetadata() []*KeyMeta
+}
+
+// RawValueInfo is an optional interface for objects that carry encoded value in rdb file.
+// Use type assertion to check if an object implements this interface.
+type RawValueInfo interface {
+	// GetRawValue returns type byte and encoded value of object, value is nil if not available
+	GetRawValue() (byte, []byte)
+}
+
 // BaseObject is basement of redis object
 type BaseObject struct {
 	DB         int         `json:"db"`                   // DB is db index of redis object
@@ -98,6 +118,16 @@ type BaseObject struct {
 	Extra      interface{} `json:"-"`                    // Extra stores more detail of encoding for memory profiler and other usages
 	IdleTime   *int64      `json:"lru,omitempty"`
 	Freq       *int64      `json:"lfu,omitempty"`
+	Metadata   []*KeyMeta  `json:"meta,omitempty"` // Metadata is attached by RDB_OPCODE_KEY_META since Redis 8
+	RawType    byte        `json:"-"`              // RawType is the type byte of value in rdb, set by Decoder.WithRawValue
+	RawValue   []byte      `json:"-"`              // RawValue is encoded value in rdb without type and key
+}
+
+// KeyMeta is a metadata attached to a key, its class is registered by redis module
+type KeyMeta struct {
+	Class      string      `json:"class"`           // Class is name of metadata class
+	EncVersion int         `json:"encVersion"`      // EncVersion is encoding version of metadata class
+	Value      interface{} `json:"value,omitempty"` // Value is parsed by handler registered with Decoder.WithSpecialType
 }
 
 // GetKey returns key of object
@@ -146,6 +176,16 @@ func (o *BaseObject) GetFreq() int64 {
 	return *o.Freq
 }
 
+// GetMetadata returns metadata attached to the key, nil if not available
+func (o *BaseObject) GetMetadata() []*KeyMeta {
+	return o.Metadata
+}
+
+// GetRawValue returns type byte and encoded value of object, value is nil if not available
+func (o *BaseObject) GetRawValue() (byte, []byte) {
+	return o.RawType, o.RawValue
+}
+
 // StringObject stores a string object
 type StringObject struct {
 	*BaseObject
@@ -328,7 +368,22 @@ func (o *AuxObject) MarshalJSON() ([]byte, error) {
 // FunctionsObject stores redis functions
 type FunctionsObject struct {
 	*BaseObject
-	FunctionsLua string `json:"functionsLua"`
+	FunctionsLua string            `json:"functionsLua"`
+	Libraries    []FunctionLibrary `json:"libraries,omitempty"` // Libraries are parsed from FunctionsLua
+}
+
+// FunctionLibrary is metadata of a function library
+type FunctionLibrary struct {
+	Engine    string     `json:"engine"`
+	Name      string     `json:"name"`
+	Functions []Function `json:"functions"`
+}
+
+// Function is a function registered by redis.register_function
+type Function struct {
+	Name        string   `json:"name"`
+	Flags       []string `json:"flags,omitempty"`
+	Description string   `json:"description,omitempty"`
 }
 
 // GetType returns redis object type
@@ -348,10 +403,104 @@ func (o *DBSizeObject) GetType() string {
 	return DBSizeType
 }
 
+const (
+	// ModuleAuxBeforeRDB means module aux data is saved before keys, REDISMODULE_AUX_BEFORE_RDB
+	ModuleAuxBeforeRDB = 1
+	// ModuleAuxAfterRDB means module aux data is saved after keys, REDISMODULE_AUX_AFTER_RDB
+	ModuleAuxAfterRDB = 2
+)
+
+// ModuleAuxObject stores global data of a module, like index definitions of RediSearch.
+// Value is decoded by the handler registered for ModuleType, it is nil if no handler registered
+type ModuleAuxObject struct {
+	*BaseObject
+	ModuleType string
+	When       int
+	EncVersion int
+	Value      interface{}
+}
+
+// GetType returns redis object type
+func (o *ModuleAuxObject) GetType() string {
+	return ModuleAuxType
+}
+
+// MarshalJSON marshal module aux data
+func (o *ModuleAuxObject) MarshalJSON() ([]byte, error) {
+	o2 := struct {
+		*BaseObject
+		ModuleType string      `json:"moduleType"`
+		When       int         `json:"when"`
+		EncVersion int         `json:"encVersion"`
+		Value      interface{} `json:"value"`
+	}{
+		BaseObject: o.BaseObject,
+		ModuleType: o.ModuleType,
+		When:       o.When,
+		EncVersion: o.EncVersion,
+		Value:      o.Value,
+	}
+	return json.Marshal(o2)
+}
+
+// SlotInfoObject stores number of keys in a cluster slot, Valkey saves it before keys of the slot
+type SlotInfoObject struct {
+	*BaseObject
+	Slot         int    `json:"slot"`
+	KeyCount     uint64 `json:"keyCount"`
+	ExpiresCount uint64 `json:"expiresCount"` // ExpiresCount is number of keys with expiration in the slot
+}
+
+// GetType returns redis object type
+func (o *SlotInfoObject) GetType() string {
+	return SlotInfoType
+}
+
+// SlotRange is a range of cluster slots, both ends are inclusive
+type SlotRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// SlotImportObject stores an in-flight slot import job of Valkey
+type SlotImportObject struct {
+	*BaseObject
+	JobName string      `json:"jobName"`
+	Ranges  []SlotRange `json:"ranges"`
+}
+
+// GetType returns redis object type
+func (o *SlotImportObject) GetType() string {
+	return SlotImportType
+}
+
+// MetadataObject stores type, encoding, size and element count of an object whose value is skipped,
+// see core.Decoder.WithMetadataOnly
+type MetadataObject struct {
+	*BaseObject
+	ElemCount int `json:"elemCount"`
+}
+
+// GetType returns redis type of the skipped object
+func (o *MetadataObject) GetType() string {
+	return o.Type
+}
+
+// GetElemCount returns number of elements in list/set/hash/zset
+func (o *MetadataObject) GetElemCount() int {
+	return o.ElemCount
+}
+
+// MemorySizer is implemented by values of module types which can evaluate their memory usage
+type MemorySizer interface {
+	MemorySize() int
+}
+
 // ModuleTypeObject stores a module type object parsed by custom handler
 type ModuleTypeObject struct {
 	*BaseObject
 	ModuleType string
+	EncVersion int
 	Value      interface{}
 }
 
@@ -23,6 +23,8 @@ const (
 	DBSizeType = model.DBSizeType
 	// StreamType is for redis stream
 	StreamType = model.StreamType
+	// ModuleAuxType is for RDB_OPCODE_MODULE_AUX
+	ModuleAuxType = model.ModuleAuxType
 )
 
 type (
@@ -44,6 +46,19 @@ type (
 	AuxObject = model.AuxObject
 	// DBSizeObject stores db size metadata
 	DBSizeObject = model.DBSizeObject
+	// ModuleAuxObject stores global data of a module
+	ModuleAuxObject = model.ModuleAuxObject
+	// ElementEvent is emitted by Decoder.ParseElements
+	ElementEvent = core.ElementEvent
+)
+
+const (
+	// ObjectBeginEvent is emitted before elements of an object
+	ObjectBeginEvent = core.ObjectBeginEvent
+	// ObjectElementsEvent carries a batch of elements
+	ObjectElementsEvent = core.ObjectElementsEvent
+	// ObjectEndEvent is emitted after all elements of an object
+	ObjectEndEvent = core.ObjectEndEvent
 )
 
 var (
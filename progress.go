@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/core"
+)
+
+const progressInterval = 500 * time.Millisecond
+
+// progressPrinter prints a progress line at most once per progressInterval
+type progressPrinter struct {
+	output   io.Writer
+	latest   core.Progress
+	lastTime time.Time
+	printed  bool
+	finished bool
+}
+
+func newProgressPrinter(output io.Writer) *progressPrinter {
+	return &progressPrinter{
+		output:   output,
+		lastTime: time.Now(), // do not print for small files
+	}
+}
+
+func (p *progressPrinter) update(progress core.Progress) {
+	p.latest = progress
+	now := time.Now()
+	if now.Sub(p.lastTime) < progressInterval {
+		return
+	}
+	p.lastTime = now
+	p.print()
+}
+
+func (p *progressPrinter) print() {
+	line := "parsed " + bytefmt.FormatSize(uint64(p.latest.ReadBytes))
+	if p.latest.TotalBytes > 0 {
+		line += fmt.Sprintf("/%s (%.1f%%)", bytefmt.FormatSize(uint64(p.latest.TotalBytes)),
+			float64(p.latest.ReadBytes)*100/float64(p.latest.TotalBytes))
+	}
+	line += fmt.Sprintf(", %d objects, db %d", p.latest.Objects, p.latest.DB)
+	// \033[K clears rest of previous line
+	_, _ = fmt.Fprintf(p.output, "\r%s\033[K", line)
+	p.printed = true
+}
+
+// finish prints the final progress and ends the line
+func (p *progressPrinter) finish() {
+	if p.finished || (!p.printed && p.latest.ReadBytes == 0) {
+		return
+	}
+	p.finished = true
+	p.print()
+	_, _ = fmt.Fprintln(p.output)
+}